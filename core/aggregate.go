@@ -0,0 +1,156 @@
+package core
+
+import (
+	"fmt"
+)
+
+// aggFuncs are the aggregate functions, the field `sum_price` sums the column `price`
+var aggFuncs = []string{"count", "sum", "avg", "min", "max"}
+
+// aggFunctions returns the aggregate functions that apply to the type of the column,
+// keys are numbers without a meaningful sum or average
+func aggFunctions(c DBColumn) []string {
+	name, isList := getType(c.Type)
+	switch {
+	case c.Array || isList || name == "JSON" || name == Boolean:
+		return aggFuncs[:1]
+	case (name == Int || name == Float) && !c.PrimaryKey && c.FKeyTable == "":
+		return aggFuncs
+	}
+	return []string{"count", "min", "max"}
+}
+
+// aggType returns the graphql type of the aggregate of a column of the type
+func aggType(fn, name string) string {
+	switch fn {
+	case "count":
+		return Int
+	case "sum", "avg":
+		return Float
+	}
+	return name
+}
+
+// aggregate resolves the field `<func>_<column>` unless aggregates are disabled for the table
+func (my *builder) aggregate(t *DBTable, name string) (DBColumn, string, bool) {
+	r := my.rule(t, ruleQuery)
+	if my.conf.DisableAgg || r.noAgg {
+		return DBColumn{}, "", false
+	}
+	for _, c := range t.Columns {
+		if c.Blocked || !r.allows(c) {
+			continue
+		}
+		for _, fn := range aggFunctions(c) {
+			if my.conf.getName(fn+"_"+c.Name, true) == name {
+				return c, fn, true
+			}
+		}
+	}
+	return DBColumn{}, "", false
+}
+
+// finishAggregate groups the rows of a selection with aggregates by its columns,
+// only columns, aggregates and __typename can be selected next to the aggregates
+func (my *builder) finishAggregate(s *selection) error {
+	for _, f := range s.fields {
+		if f.kind == kindAgg {
+			s.grouped = true
+		}
+	}
+	if !s.grouped {
+		return nil
+	}
+	if s.mutation != nil {
+		return fmt.Errorf("aggregates are not supported in mutations on '%s'", s.name)
+	}
+	if len(s.distinct) != 0 || s.paging != nil {
+		return fmt.Errorf("aggregates cannot be combined with 'distinctOn' or pagination on '%s'", s.name)
+	}
+	for _, f := range s.fields {
+		switch f.kind {
+		case kindColumn:
+			if f.includeIf != nil || f.skipIf != nil {
+				return fmt.Errorf("field '%s' cannot use 'includeIf' or 'skipIf' next to aggregates", f.name)
+			}
+		case kindAgg, kindTypename:
+		default:
+			return fmt.Errorf("field '%s' cannot be selected next to aggregates on '%s'", f.name, s.name)
+		}
+	}
+	for _, o := range s.orders {
+		if !hasColumn(s.fields, o.column) {
+			return fmt.Errorf("cannot sort '%s' by '%s', it is not selected next to the aggregates", s.name, o.column.Name)
+		}
+	}
+	return nil
+}
+
+func hasColumn(fields []*field, c DBColumn) bool {
+	for _, f := range fields {
+		if f.kind == kindColumn && f.column.Name == c.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// groupColumns lists the selected columns once
+func groupColumns(s *selection) []DBColumn {
+	var list []DBColumn
+	for _, f := range s.fields {
+		if f.kind != kindColumn {
+			continue
+		}
+		var seen bool
+		for _, c := range list {
+			seen = seen || c.Name == f.column.Name
+		}
+		if !seen {
+			list = append(list, f.column)
+		}
+	}
+	return list
+}
+
+// renderAggregates selects the grouped columns and the aggregates in the base query
+func (my *renderer) renderAggregates(s *selection) {
+	n := 0
+	for _, c := range groupColumns(s) {
+		if n != 0 {
+			my.WriteString(`, `)
+		}
+		n++
+		my.column(s, c)
+	}
+	for _, f := range s.fields {
+		if f.kind != kindAgg {
+			continue
+		}
+		if n != 0 {
+			my.WriteString(`, `)
+		}
+		n++
+		my.WriteString(f.value)
+		my.WriteString(`(`)
+		my.column(s, f.column)
+		my.WriteString(`) AS `)
+		my.quote(aggAlias(f))
+	}
+}
+
+// renderGroupBy groups the rows by the selected columns
+func (my *renderer) renderGroupBy(s *selection) {
+	for i, c := range groupColumns(s) {
+		if i == 0 {
+			my.WriteString(` GROUP BY `)
+		} else {
+			my.WriteString(`, `)
+		}
+		my.column(s, c)
+	}
+}
+
+func aggAlias(f *field) string {
+	return "__agg_" + f.name
+}
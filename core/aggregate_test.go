@@ -0,0 +1,83 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileAggregate(t *testing.T) {
+	st := compileTest(t, &Config{}, `{
+		posts(sort: {user_id: desc}) {
+			user_id
+			count_id
+			max_title
+		}
+	}`, nil)
+	for _, v := range []string{
+		`'count_id', "posts_0"."__agg_count_id", 'max_title', "posts_0"."__agg_max_title"`,
+		`SELECT "posts_0"."user_id", count("posts_0"."id") AS "__agg_count_id", max("posts_0"."title") AS "__agg_max_title" FROM "public"."posts" AS "posts_0" GROUP BY "posts_0"."user_id" ORDER BY "posts_0"."user_id" DESC`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+
+	// the rows of a related table are grouped per parent row
+	st = compileTest(t, &Config{}, `{ users { email posts { count_id } } }`, nil)
+	if v := `SELECT count("posts_1"."id") AS "__agg_count_id" FROM "public"."posts" AS "posts_1" WHERE`; !strings.Contains(st.sql, v) {
+		t.Errorf("expected %s in:\n%s", v, st.sql)
+	}
+
+	c := newTestCompiler(t, &Config{}, nil)
+	fields := make(map[string]string)
+	for _, f := range c.schema("").Types["posts"].Fields {
+		fields[f.Name] = f.Type.Name
+	}
+	for k, v := range map[string]string{"count_id": Int, "max_user_id": Int, "max_title": String, "count_meta": Int} {
+		if fields[k] != v {
+			t.Errorf("expected the field %s of type %s, got '%s'", k, v, fields[k])
+		}
+	}
+	for _, k := range []string{"sum_title", "sum_id", "avg_user_id"} {
+		if _, ok := fields[k]; ok {
+			t.Errorf("expected no field %s", k)
+		}
+	}
+
+	// the sum of a number that is not a key is kept
+	di := newTestInfo()
+	addTestTable(di, "products",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "price", Type: "numeric"},
+	)
+	products := newTestCompiler(t, &Config{}, di).schema("").Types["products"]
+	fields = make(map[string]string)
+	for _, f := range products.Fields {
+		fields[f.Name] = f.Type.Name
+	}
+	if fields["sum_price"] != Float || fields["avg_price"] != Float {
+		t.Errorf("expected the sum and average of the price, got %v", fields)
+	}
+}
+
+func TestCompileAggregateErrors(t *testing.T) {
+	disabled := &Config{Tables: []TableConfig{{Name: "posts", Query: &QueryConfig{DisableAgg: true}}}}
+	for _, v := range []struct {
+		conf *Config
+		gql  string
+	}{
+		{&Config{}, `{ posts { sum_title } }`},
+		{&Config{}, `{ posts { count_id users { id } } }`},
+		{&Config{}, `{ posts(first: 2) { count_id } }`},
+		{&Config{}, `{ posts(distinctOn: [user_id]) { count_id } }`},
+		{&Config{}, `{ posts(sort: {title: asc}) { user_id count_id } }`},
+		{&Config{}, `mutation { posts(insert: {title: "a"}) { count_id } }`},
+		{&Config{}, `{ users { count_password } }`},
+		{&Config{DisableAgg: true}, `{ posts { count_id } }`},
+		{disabled, `{ posts { count_id } }`},
+	} {
+		if _, err := compileSession(t, newTestCompiler(t, v.conf, nil), v.gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", v.gql)
+		}
+	}
+}
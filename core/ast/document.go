@@ -95,11 +95,60 @@ func (*FragmentSpread) isSelection() {}
 func (*InlineFragment) isSelection() {}
 
 type SchemaDocument struct {
-	Description string
-	Schema      []*SchemaDocument
-	Directives  []*Directive
-	Definitions []*Definition
+	Description     string
+	Schema          []*SchemaDefinition
+	SchemaExtension []*SchemaDefinition
+	Directives      []*DirectiveDefinition
+	Definitions     []*Definition
+	Extensions      []*Definition
+}
+
+type SchemaDefinition struct {
+	Description    string
+	Directives     []*Directive
+	OperationTypes []*OperationTypeDefinition
 }
+
+type OperationTypeDefinition struct {
+	Operation OperationType
+	Type      string
+}
+
+type DirectiveDefinition struct {
+	Description  string
+	Name         string
+	Arguments    []*ArgumentDefinition
+	Locations    []DirectiveLocation
+	IsRepeatable bool
+}
+
+type DirectiveLocation string
+
+const (
+	// Executable
+	LocationQuery              DirectiveLocation = `QUERY`
+	LocationMutation           DirectiveLocation = `MUTATION`
+	LocationSubscription       DirectiveLocation = `SUBSCRIPTION`
+	LocationField              DirectiveLocation = `FIELD`
+	LocationFragmentDefinition DirectiveLocation = `FRAGMENT_DEFINITION`
+	LocationFragmentSpread     DirectiveLocation = `FRAGMENT_SPREAD`
+	LocationInlineFragment     DirectiveLocation = `INLINE_FRAGMENT`
+	LocationVariableDefinition DirectiveLocation = `VARIABLE_DEFINITION`
+
+	// Type System
+	LocationSchema               DirectiveLocation = `SCHEMA`
+	LocationScalar               DirectiveLocation = `SCALAR`
+	LocationObject               DirectiveLocation = `OBJECT`
+	LocationFieldDefinition      DirectiveLocation = `FIELD_DEFINITION`
+	LocationArgumentDefinition   DirectiveLocation = `ARGUMENT_DEFINITION`
+	LocationInterface            DirectiveLocation = `INTERFACE`
+	LocationUnion                DirectiveLocation = `UNION`
+	LocationEnum                 DirectiveLocation = `ENUM`
+	LocationEnumValue            DirectiveLocation = `ENUM_VALUE`
+	LocationInputObject          DirectiveLocation = `INPUT_OBJECT`
+	LocationInputFieldDefinition DirectiveLocation = `INPUT_FIELD_DEFINITION`
+)
+
 type Kind string
 
 const (
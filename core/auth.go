@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	authNone   = "none"
+	authJWT    = "jwt"
+	authRails  = "rails"
+	authHeader = "header"
+
+	headerUserID   = "X-User-ID"
+	headerUserRole = "X-User-Role"
+)
+
+// Authenticator reads the credentials of a request, an empty user id means the request is anonymous
+type Authenticator interface {
+	Authenticate(r *http.Request) (uid, role string, err error)
+}
+
+// newAuthenticator returns the provider of the auth type, none has no provider
+func newAuthenticator(conf *Config) (Authenticator, error) {
+	switch conf.Auth.Type {
+	case "", authNone:
+		return nil, nil
+	case authJWT:
+		return newJWTAuth(conf)
+	case authRails:
+		return newRailsAuth(conf)
+	case authHeader:
+		return newHeaderAuth(conf)
+	}
+	return nil, fmt.Errorf("unknown auth type '%s'", conf.Auth.Type)
+}
+
+// NewAuth returns the middleware that authenticates the requests with the auth config,
+// the user id and the role are added to the request context where the engine picks them up
+func NewAuth(conf *Config) (func(http.Handler) http.Handler, error) {
+	if conf.Auth.CredsInHeader && conf.Production {
+		return nil, errors.New("creds_in_header is not allowed in production")
+	}
+	if conf.Auth.SubsCredsInVars && conf.Production {
+		return nil, errors.New("subs_creds_in_vars is not allowed in production")
+	}
+	a, err := newAuthenticator(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, uid, role := r.Context(), "", ""
+			if a != nil {
+				var err error
+				uid, role, err = a.Authenticate(r)
+				if err != nil && conf.AuthFailBlock {
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					uid, role = "", ""
+				}
+			}
+			// the headers never replace a user verified by the provider
+			if uid == "" && conf.Auth.CredsInHeader {
+				uid, role = r.Header.Get(headerUserID), r.Header.Get(headerUserRole)
+			}
+			ctx = withUser(ctx, uid, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
+
+func withUser(ctx context.Context, uid, role string) context.Context {
+	if uid != "" {
+		ctx = context.WithValue(ctx, UserIDKey, uid)
+	}
+	if role != "" {
+		ctx = context.WithValue(ctx, UserRoleKey, role)
+	}
+	return ctx
+}
+
+// headerAuth trusts the requests of a gateway that sets the configured header,
+// the gateway forwards the user in the X-User-ID and X-User-Role headers
+type headerAuth struct {
+	name   string
+	value  string
+	exists bool
+}
+
+func newHeaderAuth(conf *Config) (*headerAuth, error) {
+	c := conf.Auth.Header
+	if c.Name == "" {
+		return nil, errors.New("header auth requires a header name")
+	}
+	if !c.Exists && c.Value == "" {
+		return nil, errors.New("header auth requires a header value or exists")
+	}
+	return &headerAuth{name: c.Name, value: c.Value, exists: c.Exists}, nil
+}
+
+func (my *headerAuth) Authenticate(r *http.Request) (string, string, error) {
+	v, ok := r.Header[http.CanonicalHeaderKey(my.name)]
+	if !ok || (!my.exists && (len(v) == 0 || v[0] != my.value)) {
+		return "", "", fmt.Errorf("header '%s' is missing or invalid", my.name)
+	}
+	return r.Header.Get(headerUserID), r.Header.Get(headerUserRole), nil
+}
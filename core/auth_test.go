@@ -0,0 +1,316 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signToken builds a token signed with a HS256 secret, a rsa key or an ecdsa key
+func signToken(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// writePublicKey stores the public key as pem in the directory and returns the file name
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err = os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// authRequest runs the request through the middleware and returns the status and the user of the context
+func authRequest(t *testing.T, conf *Config, r *http.Request) (int, interface{}, interface{}) {
+	t.Helper()
+	auth, err := NewAuth(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uid, role interface{}
+	h := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, role = r.Context().Value(UserIDKey), r.Context().Value(UserRoleKey)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, uid, role
+}
+
+func TestJWTAuth(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("supercalifajalistics")
+	claims := map[string]interface{}{"sub": "auth0|42", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()}
+
+	for _, v := range []struct {
+		name string
+		jwt  JWTConfig
+		alg  string
+		key  interface{}
+	}{
+		{"HS256", JWTConfig{Secret: string(secret), Provider: "auth0"}, "HS256", secret},
+		{"RS256", JWTConfig{PublicKeyFile: writePublicKey(t, dir, "rsa.pem", &rsaKey.PublicKey), PublicKeyType: "rsa", Provider: "auth0"}, "RS256", rsaKey},
+		{"ES256", JWTConfig{PublicKeyFile: writePublicKey(t, dir, "ec.pem", &ecKey.PublicKey), PublicKeyType: "ecdsa", Provider: "auth0"}, "ES256", ecKey},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			conf := &Config{ConfigPath: dir, Auth: AuthConfig{Type: authJWT, JWT: v.jwt}}
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+signToken(t, v.alg, v.key, claims))
+			code, uid, role := authRequest(t, conf, r)
+			if code != http.StatusOK || uid != "42" || role != "admin" {
+				t.Errorf("unexpected result %d %v %v", code, uid, role)
+			}
+		})
+	}
+
+	// the token is read from the cookie when there is no authorization header
+	conf := &Config{Auth: AuthConfig{Type: authJWT, Cookie: "session", JWT: JWTConfig{Secret: string(secret)}}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: signToken(t, "HS256", secret, map[string]interface{}{"sub": 7})})
+	if code, uid, role := authRequest(t, conf, r); code != http.StatusOK || uid != "7" || role != nil {
+		t.Errorf("unexpected result %d %v %v", code, uid, role)
+	}
+}
+
+func TestJWTAuthFail(t *testing.T) {
+	secret := []byte("supercalifajalistics")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := map[string]interface{}{"sub": "1", "exp": time.Now().Add(-time.Minute).Unix()}
+	early := map[string]interface{}{"sub": "1", "nbf": time.Now().Add(time.Hour).Unix()}
+	valid := map[string]interface{}{"sub": "1"}
+
+	for _, token := range []string{
+		"not.a.token",
+		signToken(t, "HS256", []byte("other"), valid),
+		signToken(t, "HS256", secret, expired),
+		signToken(t, "HS256", secret, early),
+		signToken(t, "ES256", ecKey, valid),
+		signToken(t, "none", nil, valid),
+	} {
+		conf := &Config{AuthFailBlock: true, Auth: AuthConfig{Type: authJWT, JWT: JWTConfig{Secret: string(secret)}}}
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if code, uid, _ := authRequest(t, conf, r); code != http.StatusUnauthorized || uid != nil {
+			t.Errorf("expected 401 for %s, got %d", token, code)
+		}
+
+		// without auth_fail_block the request continues as anonymous
+		conf.AuthFailBlock = false
+		if code, uid, _ := authRequest(t, conf, r); code != http.StatusOK || uid != nil {
+			t.Errorf("expected an anonymous request for %s, got %d %v", token, code, uid)
+		}
+	}
+
+	for _, v := range []AuthConfig{
+		{Type: "oauth"},
+		{Type: authJWT},
+		{Type: authJWT, JWT: JWTConfig{PublicKeyFile: "/missing.pem"}},
+	} {
+		if _, err = NewAuth(&Config{Auth: v}); err == nil {
+			t.Errorf("expected an error for %+v", v)
+		}
+	}
+}
+
+func TestAuthEngine(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{}`))
+	conf := &Config{
+		Auth:  AuthConfig{Type: authJWT, CredsInHeader: true, JWT: JWTConfig{Secret: "secret"}},
+		Roles: []RoleConfig{{Name: roleAnon, Tables: []RoleTable{{Name: "posts"}}}},
+	}
+	h := newTestEngine(t, conf, db).Handler()
+
+	for _, v := range []struct {
+		query, uid string
+		errors     bool
+	}{
+		{`{ posts { id } }`, "", false},
+		{`{ users { id } }`, "", true},
+		{`{ users { id } }`, "3", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/?query="+url.QueryEscape(v.query), nil)
+		if v.uid != "" {
+			r.Header.Set(headerUserID, v.uid)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		var res Result
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if (len(res.Errors) != 0) != v.errors {
+			t.Errorf("unexpected result for %s as '%s': %s", v.query, v.uid, w.Body)
+		}
+	}
+
+	if q, _ := f.last(); q == "" {
+		t.Error("expected the allowed queries to run")
+	}
+
+	// the headers never replace the user of a verified token
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, "HS256", []byte("secret"), map[string]interface{}{"sub": "5"}))
+	r.Header.Set(headerUserID, "3")
+	r.Header.Set(headerUserRole, "admin")
+	if code, uid, role := authRequest(t, conf, r); code != http.StatusOK || uid != "5" || role != nil {
+		t.Errorf("expected the verified user, got %d %v %v", code, uid, role)
+	}
+
+	conf.Production = true
+	if _, err := NewAuth(conf); err == nil {
+		t.Error("expected an error for creds_in_header in production")
+	}
+	if _, err := NewAuth(&Config{Production: true, Auth: AuthConfig{SubsCredsInVars: true}}); err == nil {
+		t.Error("expected an error for subs_creds_in_vars in production")
+	}
+}
+
+// railsCookie encrypts the session like rails 5.2, the message is wrapped in the metadata envelope
+func railsCookie(t *testing.T, a *railsAuth, session string, exp *time.Time, purpose string) string {
+	t.Helper()
+	env := map[string]interface{}{"message": base64.StdEncoding.EncodeToString([]byte(session)), "exp": exp, "pur": purpose}
+	plain, _ := json.Marshal(map[string]interface{}{"_rails": env})
+	iv := make([]byte, a.aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	sealed := a.aead.Seal(nil, iv, plain, nil)
+	data, tag := sealed[:len(sealed)-a.aead.Overhead()], sealed[len(sealed)-a.aead.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return url.QueryEscape(enc(data) + "--" + enc(iv) + "--" + enc(tag))
+}
+
+func TestRailsAuth(t *testing.T) {
+	conf := &Config{AuthFailBlock: true, Auth: AuthConfig{Type: authRails, Cookie: "_app_session", Rails: RailsConfig{
+		Version:       "5.2",
+		SecretKeyBase: "0a248500a64c01184edb4d7ad3a805488f8097ac761b76aaa6c17c01dcb7af03",
+	}}}
+	a, err := newRailsAuth(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	for _, v := range []struct {
+		cookie string
+		code   int
+		uid    interface{}
+	}{
+		{railsCookie(t, a, `{"session_id":"x","warden.user.user.key":[[12345678901],"salt"]}`, nil, "cookie._app_session"), http.StatusOK, "12345678901"},
+		{railsCookie(t, a, `{"user_id":5}`, &future, "cookie._app_session"), http.StatusOK, "5"},
+		{railsCookie(t, a, `{"session_id":"x"}`, nil, ""), http.StatusOK, nil},
+		{railsCookie(t, a, `{"user_id":5}`, &past, "cookie._app_session"), http.StatusUnauthorized, nil},
+		{railsCookie(t, a, `{"user_id":5}`, nil, "cookie.other"), http.StatusUnauthorized, nil},
+		{"bm90--YSBjb29raWU=--eA==", http.StatusUnauthorized, nil},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "_app_session", Value: v.cookie})
+		if code, uid, _ := authRequest(t, conf, r); code != v.code || uid != v.uid {
+			t.Errorf("expected %d %v, got %d %v", v.code, v.uid, code, uid)
+		}
+	}
+
+	// a cookie of another secret does not open
+	other, err := newRailsCipher("_app_session", "other", railsAuthSalt, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "_app_session", Value: railsCookie(t, other, `{"user_id":5}`, nil, "")})
+	if code, _, _ := authRequest(t, conf, r); code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", code)
+	}
+
+	for _, v := range []RailsConfig{
+		{SecretKeyBase: "x", Version: "4.2"},
+		{SecretKeyBase: "x", URL: "redis://redis:6379"},
+		{Version: "5.2"},
+	} {
+		if _, err = NewAuth(&Config{Auth: AuthConfig{Type: authRails, Cookie: "s", Rails: v}}); err == nil {
+			t.Errorf("expected an error for %+v", v)
+		}
+	}
+}
+
+func TestHeaderAuth(t *testing.T) {
+	for _, v := range []struct {
+		header HeaderConfig
+		value  string
+		code   int
+		uid    interface{}
+	}{
+		{HeaderConfig{Name: "X-Gateway", Value: "secret"}, "secret", http.StatusOK, "9"},
+		{HeaderConfig{Name: "X-Gateway", Value: "secret"}, "wrong", http.StatusUnauthorized, nil},
+		{HeaderConfig{Name: "X-Gateway", Exists: true}, "anything", http.StatusOK, "9"},
+		{HeaderConfig{Name: "X-Gateway", Exists: true}, "", http.StatusUnauthorized, nil},
+	} {
+		conf := &Config{AuthFailBlock: true, Auth: AuthConfig{Type: authHeader, Header: v.header}}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(headerUserID, "9")
+		if v.value != "" {
+			r.Header.Set("x-gateway", v.value)
+		}
+		if code, uid, _ := authRequest(t, conf, r); code != v.code || uid != v.uid {
+			t.Errorf("expected %d %v for %+v, got %d %v", v.code, v.uid, v.header, code, uid)
+		}
+	}
+	if _, err := NewAuth(&Config{Auth: AuthConfig{Type: authHeader, Header: HeaderConfig{Name: "X-Gateway"}}}); err == nil {
+		t.Error("expected an error without a value")
+	}
+}
@@ -0,0 +1,1009 @@
+package core
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"github.com/ichaly/tiny-go/core/ast"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultLimit = 20
+
+type fieldKind int8
+
+const (
+	kindColumn fieldKind = iota
+	kindTypename
+	kindSelect
+	kindJSON
+	kindCursor
+	kindUnion
+	kindFunc
+	kindAgg
+	kindSearch
+	kindResolver
+)
+
+type expOp int8
+
+const (
+	opAnd expOp = iota + 1
+	opOr
+	opNot
+	opEquals
+	opNotEquals
+	opGreaterThan
+	opLesserThan
+	opGreaterOrEquals
+	opLesserOrEquals
+	opLike
+	opNotLike
+	opILike
+	opNotILike
+	opSimilar
+	opNotSimilar
+	opRegex
+	opNotRegex
+	opIRegex
+	opNotIRegex
+	opIn
+	opNotIn
+	opIsNull
+	opHasKey
+	opHasKeyAny
+	opHasKeyAll
+	opContains
+	opContainedIn
+	opSearch
+)
+
+// expOps maps the operators of the `Expression` input types to their codes
+var expOps = map[string]expOp{
+	"equals":          opEquals,
+	"notEquals":       opNotEquals,
+	"greaterThan":     opGreaterThan,
+	"lesserThan":      opLesserThan,
+	"greaterOrEquals": opGreaterOrEquals,
+	"lesserOrEquals":  opLesserOrEquals,
+	"like":            opLike,
+	"notLike":         opNotLike,
+	"iLike":           opILike,
+	"notILike":        opNotILike,
+	"similar":         opSimilar,
+	"notSimilar":      opNotSimilar,
+	"regex":           opRegex,
+	"notRegex":        opNotRegex,
+	"iRegex":          opIRegex,
+	"notIRegex":       opNotIRegex,
+	"in":              opIn,
+	"notIn":           opNotIn,
+	"isNull":          opIsNull,
+	"hasKey":          opHasKey,
+	"hasKeyAny":       opHasKeyAny,
+	"hasKeyAll":       opHasKeyAll,
+	"contains":        opContains,
+	"containedIn":     opContainedIn,
+}
+
+// sortDirs maps the values of the `Direction` enum to sql
+var sortDirs = map[string]string{
+	"asc":              "ASC",
+	"desc":             "DESC",
+	"asc_nulls_first":  "ASC NULLS FIRST",
+	"desc_nulls_first": "DESC NULLS FIRST",
+	"asc_nulls_last":   "ASC NULLS LAST",
+	"desc_nulls_last":  "DESC NULLS LAST",
+}
+
+// query is an operation resolved against the database info
+type query struct {
+	kind   ast.OperationType
+	name   string
+	fields []*field
+	// cursor is set when the response holds cursors that must be encrypted
+	cursor bool
+	// resolve is set when the response holds the column values of resolvers
+	resolve bool
+}
+
+// selection is a field backed by a table, it renders to a json object or array
+type selection struct {
+	id       int
+	name     string
+	table    *DBTable
+	parent   *selection
+	rel      *DBRel
+	singular bool
+	fields   []*field
+	where    *exp
+	orders   []*order
+	distinct []DBColumn
+	limit    int
+	offset   int
+	mutation *mutation
+	paging   *paging
+	// members are the selections of the types of a polymorphic union, it has no table of its own
+	members []*selection
+	// fn is the set returning function that reads the rows of the table
+	fn     *DBFunction
+	fnArgs map[string]interface{}
+	// grouped selections have aggregate fields, their rows are grouped by the selected columns
+	grouped bool
+	search  *search
+	// find walks a recursive relation to the children or the parents, at most depth levels when set
+	find  string
+	depth int
+}
+
+type field struct {
+	kind      fieldKind
+	name      string
+	column    DBColumn
+	child     *selection
+	fn        *DBFunction
+	row       []DBColumn // the columns of the row passed to fn
+	value     string
+	includeIf *exp
+	skipIf    *exp
+}
+
+type exp struct {
+	op       expOp
+	column   DBColumn
+	value    interface{}
+	children []*exp
+}
+
+type order struct {
+	column DBColumn
+	dir    string
+}
+
+type compiler struct {
+	conf   *Config
+	info   *DBInfo
+	tables map[string]*DBTable
+	rules  map[string]*rule
+	cipher cipher.AEAD
+	// orderBy holds the named orders of the tables, keyed by table and name
+	orderBy map[string]map[string][]*order
+	// relFields holds the relationship fields of the tables, keyed by table and field name
+	relFields map[*DBTable]map[string]*DBRelField
+	// resolvers back fields of tables with the responses of remote apis or go code
+	resolvers []*resolver
+
+	// schemas caches the introspection schema of every configured role
+	mu      sync.Mutex
+	schemas map[string]*__Schema
+}
+
+func newCompiler(conf *Config, info *DBInfo) (*compiler, error) {
+	rules, err := newRules(conf)
+	if err != nil {
+		return nil, err
+	}
+	my := &compiler{
+		conf:      conf,
+		info:      info,
+		tables:    make(map[string]*DBTable),
+		rules:     rules,
+		relFields: make(map[*DBTable]map[string]*DBRelField),
+		schemas:   make(map[string]*__Schema),
+	}
+	if my.cipher, err = newCursorCipher(conf.SecretKey); err != nil {
+		return nil, err
+	}
+	if my.orderBy, err = newOrderBy(conf, info); err != nil {
+		return nil, err
+	}
+	for _, t := range info.Tables {
+		if t.Blocked {
+			continue
+		}
+		my.tables[my.conf.getName(t.Name, true)] = t
+
+		list := info.GetRelFields(t)
+		fields := make(map[string]*DBRelField, len(list))
+		for i := range list {
+			fields[my.conf.getName(list[i].Name, true)] = &list[i]
+		}
+		my.relFields[t] = fields
+	}
+	return my, nil
+}
+
+// newOrderBy parses the order by options of the table configs, every column must exist in the table
+func newOrderBy(conf *Config, info *DBInfo) (map[string]map[string][]*order, error) {
+	res := make(map[string]map[string][]*order)
+	for _, t := range info.Tables {
+		tc := conf.getTable(t.Name)
+		if tc == nil || len(tc.OrderBy) == 0 {
+			continue
+		}
+		res[t.Name] = make(map[string][]*order)
+		for name, list := range tc.OrderBy {
+			for _, v := range list {
+				parts := strings.Fields(strings.ToLower(v))
+				if len(parts) == 0 {
+					return nil, fmt.Errorf("table '%s': order by '%s' is empty", t.Name, name)
+				}
+				c, ok := tableColumn(t, parts[0])
+				if !ok {
+					return nil, fmt.Errorf("table '%s': order by '%s': unknown column '%s'", t.Name, name, parts[0])
+				}
+				dir := "ASC"
+				if len(parts) > 1 {
+					if dir, ok = sortDirs[strings.Join(parts[1:], "_")]; !ok {
+						return nil, fmt.Errorf("table '%s': order by '%s': invalid direction '%s'", t.Name, name, v)
+					}
+				}
+				res[t.Name][name] = append(res[t.Name][name], &order{column: c, dir: dir})
+			}
+		}
+	}
+	return res, nil
+}
+
+// tableColumn finds the column of the table by its database name
+func tableColumn(t *DBTable, name string) (DBColumn, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name && !c.Blocked {
+			return c, true
+		}
+	}
+	return DBColumn{}, false
+}
+
+// schema returns the introspection schema of the role, it is built on first use
+func (my *compiler) schema(role string) *__Schema {
+	// roles without rules see the tables as configured, they share one schema
+	if !my.hasRules(role) {
+		role = ""
+	}
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	s, ok := my.schemas[role]
+	if !ok {
+		s = newSchema(my.conf, my.info, role, my.rules)
+		my.schemas[role] = s
+	}
+	return s
+}
+
+// hasRules reports whether the role is configured, the anonymous role has rules with authentication
+func (my *compiler) hasRules(role string) bool {
+	if _, ok := my.rules[ruleKey(role, "", ruleQuery)]; ok {
+		return true
+	}
+	for _, rc := range my.conf.Roles {
+		if rc.Name == role {
+			return true
+		}
+	}
+	return false
+}
+
+// builder holds the state of a single compilation
+type builder struct {
+	*compiler
+	kind ast.OperationType
+	doc  *ast.QueryDocument
+	vars map[string]interface{}
+	seq  int
+	// cursor is set once a list of the query returns its cursor
+	cursor  bool
+	resolve bool
+	// session holds the role and the trusted variables used by filters and presets
+	session *session
+}
+
+func (my *compiler) compile(
+	doc *ast.QueryDocument, op *ast.OperationDefinition, vars map[string]interface{}, sess *session,
+) (*query, error) {
+	if sess == nil {
+		sess = &session{}
+	}
+	b := &builder{compiler: my, kind: op.OperationType, doc: doc, vars: vars, session: sess}
+	q := &query{kind: op.OperationType, name: op.Name}
+
+	fields, err := b.collectFields(op.SelectionSet)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		switch f.Name {
+		case "__typename":
+			q.fields = append(q.fields, &field{kind: kindTypename, name: responseKey(f), value: my.rootName(op.OperationType)})
+			continue
+		case "__schema", "__type":
+			if op.OperationType != ast.Query {
+				break
+			}
+			data, err := b.introspect(f)
+			if err != nil {
+				return nil, err
+			}
+			q.fields = append(q.fields, &field{kind: kindJSON, name: responseKey(f), value: string(data)})
+			continue
+		}
+		t, ok := b.table(f.Name, op.OperationType != ast.Mutation)
+		if !ok && op.OperationType != ast.Mutation {
+			if fn, ft, ok := b.function(f.Name); ok {
+				s := &selection{id: b.seq, name: responseKey(f), table: ft, fn: fn}
+				b.seq++
+				if err = b.buildSelection(s, f.Arguments, f.SelectionSet); err != nil {
+					return nil, err
+				}
+				q.fields = append(q.fields, &field{kind: kindSelect, name: s.name, child: s})
+				continue
+			}
+			if c, ok := cursorField(f); ok {
+				q.fields = append(q.fields, c)
+				continue
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot query field '%s' on type '%s'", f.Name, my.rootName(op.OperationType))
+		}
+		if t.embedded() {
+			return nil, fmt.Errorf("field '%s' can only be selected on type '%s'", f.Name, my.conf.getName(t.Source))
+		}
+		s, err := b.newSelection(f, t, nil)
+		if err != nil {
+			return nil, err
+		}
+		q.fields = append(q.fields, &field{kind: kindSelect, name: s.name, child: s})
+	}
+	if err = b.linkCursors(q.fields); err != nil {
+		return nil, err
+	}
+	q.cursor = b.cursor
+	q.resolve = b.resolve
+	return q, nil
+}
+
+// static builds the response of queries that only select meta fields, they need no database
+func (my *query) static() (json.RawMessage, bool) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range my.fields {
+		if f.kind == kindSelect {
+			return nil, false
+		}
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		if f.kind == kindTypename {
+			val, _ := json.Marshal(f.value)
+			buf.Write(val)
+		} else {
+			buf.WriteString(f.value)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), true
+}
+
+func (my *compiler) rootName(op ast.OperationType) string {
+	switch op {
+	case ast.Mutation:
+		return "Mutation"
+	case ast.Subscription:
+		return "Subscription"
+	}
+	return "Query"
+}
+
+// collectFields flattens fragments and drops fields excluded by @skip or @include
+func (my *builder) collectFields(set []ast.Selection) ([]*ast.Field, error) {
+	var list []*ast.Field
+	for _, s := range set {
+		var (
+			dirs []*ast.Directive
+			sub  []ast.Selection
+		)
+		switch v := s.(type) {
+		case *ast.Field:
+			ok, err := my.isIncluded(v.Directives)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				list = append(list, v)
+			}
+			continue
+		case *ast.InlineFragment:
+			dirs, sub = v.Directives, v.SelectionSet
+		case *ast.FragmentSpread:
+			f := my.fragment(v.Name)
+			if f == nil {
+				return nil, fmt.Errorf("unknown fragment '%s'", v.Name)
+			}
+			dirs, sub = v.Directives, f.SelectionSet
+		}
+		ok, err := my.isIncluded(dirs)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		fields, err := my.collectFields(sub)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fields...)
+	}
+	return list, nil
+}
+
+func (my *builder) fragment(name string) *ast.FragmentDefinition {
+	for _, f := range my.doc.Fragments {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (my *builder) isIncluded(dirs []*ast.Directive) (bool, error) {
+	for _, d := range dirs {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		var cond bool
+		for _, a := range d.Arguments {
+			if a.Name != "if" {
+				continue
+			}
+			v, err := my.valueOf(a.Value)
+			if err != nil {
+				return false, err
+			}
+			cond, _ = v.(bool)
+		}
+		if cond == (d.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (my *builder) newSelection(f *ast.Field, t *DBTable, parent *selection) (*selection, error) {
+	s := &selection{id: my.seq, name: responseKey(f), table: t, parent: parent}
+	my.seq++
+
+	if parent != nil && parent.table == t {
+		rel, err := my.info.GetRecursive(t)
+		if err != nil {
+			return nil, err
+		}
+		s.rel = rel
+	} else if parent != nil {
+		rel, err := my.info.GetRelation(t, parent.table)
+		if err != nil {
+			return nil, err
+		}
+		s.rel = rel
+		s.singular = rel.Type == RelOneToOne
+	}
+	if err := my.buildSelection(s, f.Arguments, f.SelectionSet); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newRelated builds the selection of a relationship field, joined by the foreign key of the field
+func (my *builder) newRelated(f *ast.Field, rf *DBRelField, parent *selection) (*selection, error) {
+	s := &selection{id: my.seq, name: responseKey(f), table: rf.Table, parent: parent, rel: rf.Rel}
+	my.seq++
+	s.singular = rf.Rel.Type == RelOneToOne
+	if err := my.buildSelection(s, f.Arguments, f.SelectionSet); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// relField resolves a relationship field of the table, the related table must be readable
+func (my *builder) relField(t *DBTable, name string) (*DBRelField, bool) {
+	rf, ok := my.relFields[t][name]
+	if !ok || rf.Table.Blocked || my.rule(rf.Table, ruleQuery).block {
+		return nil, false
+	}
+	return rf, true
+}
+
+// buildSelection reads the arguments and the fields of the selection
+func (my *builder) buildSelection(s *selection, args []*ast.Argument, set []ast.Selection) error {
+	t := s.table
+	if err := my.parseArgs(s, args); err != nil {
+		return err
+	}
+	if s.singular && s.mutation == nil {
+		s.limit = 1
+	}
+
+	fields, err := my.collectFields(set)
+	if err != nil {
+		return err
+	}
+	for _, v := range fields {
+		if v.Name == "__typename" {
+			s.fields = append(s.fields, &field{kind: kindTypename, name: responseKey(v), value: my.conf.getName(t.Name)})
+			continue
+		}
+		if rf, ok := my.relField(t, v.Name); ok && len(v.SelectionSet) != 0 {
+			child, err := my.newRelated(v, rf, s)
+			if err != nil {
+				return err
+			}
+			s.fields = append(s.fields, &field{kind: kindSelect, name: responseKey(v), child: child})
+			continue
+		}
+		// a json column with a selection is read as the rows of its json table
+		if ct, ok := my.table(v.Name, true); ok && len(v.SelectionSet) != 0 {
+			child, err := my.newSelection(v, ct, s)
+			if err != nil {
+				return err
+			}
+			s.fields = append(s.fields, &field{kind: kindSelect, name: responseKey(v), child: child})
+			continue
+		}
+		if c, ok := my.column(t, v.Name); ok {
+			if !my.rule(t, ruleQuery).allows(c) {
+				return fmt.Errorf("cannot query field '%s' on type '%s'", v.Name, my.conf.getName(t.Name))
+			}
+			cf := &field{kind: kindColumn, name: responseKey(v), column: c}
+			if err := my.parseFieldArgs(s, cf, v.Arguments); err != nil {
+				return err
+			}
+			s.fields = append(s.fields, cf)
+			continue
+		}
+		sf, ok, err := my.searchField(s, v.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			sf.name = responseKey(v)
+			s.fields = append(s.fields, sf)
+			continue
+		}
+		if c, fn, ok := my.aggregate(t, v.Name); ok {
+			s.fields = append(s.fields, &field{kind: kindAgg, name: responseKey(v), column: c, value: fn})
+			continue
+		}
+		if i, ok := my.resolver(t, v.Name); ok && my.rule(t, ruleQuery).allows(my.resolvers[i].column) {
+			my.resolve = true
+			s.fields = append(s.fields, &field{kind: kindResolver, name: responseKey(v), column: my.resolvers[i].column, value: strconv.Itoa(i)})
+			continue
+		}
+		if fn, ok := my.rowFunction(t, v.Name); ok && !my.rule(t, ruleQuery).noFuncs {
+			if s.mutation != nil {
+				return fmt.Errorf("cannot query the function '%s' in a mutation", v.Name)
+			}
+			s.fields = append(s.fields, &field{kind: kindFunc, name: responseKey(v), fn: fn, row: my.rowColumns(t)})
+			continue
+		}
+		if vt, ok := my.virtualTable(t, v.Name); ok {
+			child, err := my.newUnion(v, vt, s)
+			if err != nil {
+				return err
+			}
+			s.fields = append(s.fields, &field{kind: kindUnion, name: responseKey(v), child: child})
+			continue
+		}
+		if c, ok := cursorField(v); ok {
+			s.fields = append(s.fields, c)
+			continue
+		}
+		return fmt.Errorf("cannot query field '%s' on type '%s'", v.Name, my.conf.getName(t.Name))
+	}
+	if err := my.finishAggregate(s); err != nil {
+		return err
+	}
+	return my.linkCursors(s.fields)
+}
+
+// column resolves a graphql field name to a column of the table
+func (my *compiler) column(t *DBTable, name string) (DBColumn, bool) {
+	for _, c := range t.Columns {
+		if !c.Blocked && my.conf.getName(c.Name, true) == name {
+			return c, true
+		}
+	}
+	return DBColumn{}, false
+}
+
+func (my *builder) parseArgs(s *selection, args []*ast.Argument) error {
+	for _, a := range args {
+		v, err := my.valueOf(a.Value)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		switch a.Name {
+		case "id":
+			if s.table.PrimaryCol.Name == "" {
+				return fmt.Errorf("table '%s' has no primary key", s.table.Name)
+			}
+			// the row of the id is still returned in a list, the type of the field does not change with its arguments
+			s.where = and(s.where, &exp{op: opEquals, column: s.table.PrimaryCol, value: v})
+			s.limit = 1
+		case "limit":
+			if s.limit, err = toInt(v); err != nil {
+				return fmt.Errorf("argument 'limit': %w", err)
+			}
+		case "first", "last", "after", "before":
+			if err = my.parsePaging(s, a.Name, v); err != nil {
+				return err
+			}
+		case "offset":
+			if s.offset, err = toInt(v); err != nil {
+				return fmt.Errorf("argument 'offset': %w", err)
+			}
+		case "where":
+			e, err := my.parseWhere(s.table, v)
+			if err != nil {
+				return err
+			}
+			s.where = and(s.where, e)
+		case "sort":
+			if err = my.parseSort(s, a.Value, v); err != nil {
+				return err
+			}
+		case "orderBy":
+			list, ok := my.orderBy[s.table.Name][fmt.Sprint(v)]
+			if !ok {
+				return fmt.Errorf("argument 'orderBy': unknown value '%v'", v)
+			}
+			for _, o := range list {
+				if !hasOrder(s.orders, o.column) {
+					s.orders = append(s.orders, o)
+				}
+			}
+		case "find":
+			if err = my.parseFind(s, v); err != nil {
+				return err
+			}
+		case "depth":
+			if err = my.parseDepth(s, v); err != nil {
+				return err
+			}
+		case "search":
+			if err = my.parseSearch(s, v); err != nil {
+				return err
+			}
+		case "args":
+			if err = my.parseFuncArgs(s, v); err != nil {
+				return err
+			}
+		case "distinctOn":
+			list, ok := v.([]interface{})
+			if !ok {
+				list = []interface{}{v}
+			}
+			for _, n := range list {
+				c, ok := my.column(s.table, fmt.Sprint(n))
+				if !ok || !my.rule(s.table, ruleQuery).allows(c) {
+					return fmt.Errorf("argument 'distinctOn': unknown column '%v'", n)
+				}
+				s.distinct = append(s.distinct, c)
+			}
+		case "insert", "update", "upsert", "delete":
+			if err = my.parseMutation(s, a.Name, v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown argument '%s' on field '%s'", a.Name, s.name)
+		}
+	}
+
+	if err := my.finishMutation(s); err != nil {
+		return err
+	}
+	if err := my.finishFind(s); err != nil {
+		return err
+	}
+	if s.search != nil && s.mutation != nil {
+		return fmt.Errorf("argument 'search' is not supported in mutations on '%s'", s.name)
+	}
+	if s.mutation == nil {
+		e, err := my.filter(s.table, ruleQuery)
+		if err != nil {
+			return err
+		}
+		s.where = and(s.where, e)
+		if max := my.maxLimit(s.table); s.limit == 0 || s.limit > max {
+			s.limit = max
+		}
+	}
+	if s.paging != nil {
+		return my.finishPaging(s)
+	}
+	return nil
+}
+
+// table resolves a field to a table, tables blocked for the role are unknown
+func (my *builder) table(name string, read bool) (*DBTable, bool) {
+	t, ok := my.tables[name]
+	if !ok || (read && my.rule(t, ruleQuery).block) {
+		return nil, false
+	}
+	return t, true
+}
+
+// maxLimit is the limit of the lists of the table and the most rows a client can ask for,
+// the smaller one of the role limit and the configured default limit
+func (my *builder) maxLimit(t *DBTable) int {
+	max := my.conf.DefaultLimit
+	if max <= 0 {
+		max = defaultLimit
+	}
+	if v := my.rule(t, ruleQuery).limit; v > 0 && v < max {
+		max = v
+	}
+	return max
+}
+
+func (my *builder) parseFieldArgs(s *selection, f *field, args []*ast.Argument) error {
+	for _, a := range args {
+		v, err := my.valueOf(a.Value)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		e, err := my.parseWhere(s.table, v)
+		if err != nil {
+			return err
+		}
+		switch a.Name {
+		case "includeIf":
+			f.includeIf = e
+		case "skipIf":
+			f.skipIf = e
+		default:
+			return fmt.Errorf("unknown argument '%s' on field '%s'", a.Name, f.name)
+		}
+	}
+	return nil
+}
+
+// parseSort keeps the key order of literal objects, variables are sorted by key to stay deterministic
+func (my *builder) parseSort(s *selection, raw *ast.Value, v interface{}) error {
+	var keys []string
+	if raw.Kind == ast.ObjectValue {
+		for _, c := range raw.Children {
+			keys = append(keys, c.Name)
+		}
+	} else if m, ok := v.(map[string]interface{}); ok {
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("argument 'sort': expected an object")
+	}
+	for _, k := range keys {
+		c, ok := my.column(s.table, k)
+		if !ok || !my.rule(s.table, ruleQuery).allows(c) {
+			return fmt.Errorf("argument 'sort': unknown column '%s'", k)
+		}
+		dir, ok := sortDirs[fmt.Sprint(m[k])]
+		if !ok {
+			return fmt.Errorf("argument 'sort': invalid direction '%v'", m[k])
+		}
+		s.orders = append(s.orders, &order{column: c, dir: dir})
+	}
+	return nil
+}
+
+// parseWhere parses a where expression of the client, columns the role cannot read are unknown
+func (my *builder) parseWhere(t *DBTable, v interface{}) (*exp, error) {
+	return my.parseExp(t, v, my.rule(t, ruleQuery))
+}
+
+// parseExp parses the expression on the columns allowed by the rule
+func (my *builder) parseExp(t *DBTable, v interface{}, r *rule) (*exp, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid where expression on '%s'", t.Name)
+	}
+
+	var res *exp
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		val := m[k]
+		switch k {
+		case "and", "or":
+			list, ok := val.([]interface{})
+			if !ok {
+				list = []interface{}{val}
+			}
+			// an empty list would match every row or none, so it is not a filter
+			if len(list) == 0 {
+				return nil, fmt.Errorf("empty '%s' in where expression on '%s'", k, t.Name)
+			}
+			e := &exp{op: opAnd}
+			if k == "or" {
+				e.op = opOr
+			}
+			for _, item := range list {
+				c, err := my.parseExp(t, item, r)
+				if err != nil {
+					return nil, err
+				}
+				if c == nil {
+					return nil, fmt.Errorf("empty expression in '%s' on '%s'", k, t.Name)
+				}
+				e.children = append(e.children, c)
+			}
+			res = and(res, e)
+		case "not":
+			c, err := my.parseExp(t, val, r)
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				return nil, fmt.Errorf("empty expression in 'not' on '%s'", t.Name)
+			}
+			res = and(res, &exp{op: opNot, children: []*exp{c}})
+		default:
+			col, ok := my.column(t, k)
+			if !ok || !r.allows(col) {
+				return nil, fmt.Errorf("unknown column '%s' in where expression on '%s'", k, t.Name)
+			}
+			ops, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid expression on column '%s'", k)
+			}
+			names := make([]string, 0, len(ops))
+			for n := range ops {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				op, ok := expOps[n]
+				if !ok {
+					return nil, fmt.Errorf("unknown operator '%s' on column '%s'", n, k)
+				}
+				res = and(res, &exp{op: op, column: col, value: ops[n]})
+			}
+		}
+	}
+	return res, nil
+}
+
+func (my *builder) valueOf(v *ast.Value) (interface{}, error) {
+	return valueOf(v, my.vars)
+}
+
+// valueOf converts an argument to its go value, variables are looked up in the bound variables
+func valueOf(v *ast.Value, vars map[string]interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch v.Kind {
+	case ast.Variable:
+		val, ok := vars[v.Raw]
+		if !ok {
+			return nil, fmt.Errorf("variable '$%s' is not defined", v.Raw)
+		}
+		return val, nil
+	case ast.IntValue:
+		return strconv.ParseInt(v.Raw, 10, 64)
+	case ast.FloatValue:
+		return strconv.ParseFloat(v.Raw, 64)
+	case ast.BooleanValue:
+		return v.Raw == "true", nil
+	case ast.NullValue:
+		return nil, nil
+	case ast.ListValue:
+		list := make([]interface{}, 0, len(v.Children))
+		for _, c := range v.Children {
+			val, err := valueOf(c, vars)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
+	case ast.ObjectValue:
+		obj := make(map[string]interface{}, len(v.Children))
+		for _, c := range v.Children {
+			var child *ast.Value
+			if len(c.Children) > 0 {
+				child = c.Children[0]
+			}
+			val, err := valueOf(child, vars)
+			if err != nil {
+				return nil, err
+			}
+			obj[c.Name] = val
+		}
+		return obj, nil
+	}
+	return v.Raw, nil
+}
+
+// matchesAll reports whether the expression keeps every row, an and without conditions does
+func (my *exp) matchesAll() bool {
+	if my == nil {
+		return true
+	}
+	if my.op != opAnd {
+		return false
+	}
+	for _, c := range my.children {
+		if !c.matchesAll() {
+			return false
+		}
+	}
+	return true
+}
+
+func and(left, right *exp) *exp {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.op == opAnd:
+		left.children = append(left.children, right)
+		return left
+	}
+	return &exp{op: opAnd, children: []*exp{left, right}}
+}
+
+func toInt(v interface{}) (int, error) {
+	switch n := normalize(v).(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	}
+	return 0, fmt.Errorf("expected an integer but got '%v'", v)
+}
+
+// normalize turns json numbers into int64 or float64 so drivers can bind them
+func normalize(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
+
+func responseKey(f *ast.Field) string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"github.com/ichaly/tiny-go/core/internal/data"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+	"github.com/ichaly/tiny-go/core/parser"
+	"strings"
+	"testing"
+)
+
+func newTestInfo() *DBInfo {
+	di := &DBInfo{
+		Dialect:  "postgres",
+		Schema:   "public",
+		Tables:   map[string]*DBTable{},
+		relation: data.BiDict{},
+	}
+	addTestTable(di, "users",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "full_name", Type: "text"},
+		DBColumn{Name: "email", Type: "character varying(255)", NotNull: true},
+		DBColumn{Name: "tags", Type: "text[]", Array: true},
+		DBColumn{Name: "password", Type: "text", Blocked: true},
+	)
+	addTestTable(di, "posts",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "title", Type: "text"},
+		DBColumn{Name: "meta", Type: "jsonb"},
+		DBColumn{Name: "user_id", Type: "bigint", FKeyTable: "users", FKeyCol: "id"},
+	)
+	return di
+}
+
+func addTestTable(di *DBInfo, table string, cols ...DBColumn) {
+	t := &DBTable{
+		Name:     table,
+		Schema:   "public",
+		Columns:  map[string]DBColumn{},
+		FullText: map[string]DBColumn{},
+	}
+	for _, c := range cols {
+		c.Schema, c.Table = "public", table
+		if c.PrimaryKey {
+			c.UniqueKey = true
+			t.PrimaryCol = c
+		}
+		if c.FKeyTable != "" {
+			c.FKeySchema = "public"
+			c.FKRecursive = c.FKeyTable == table
+			di.relation.Put(table, c.FKeyTable)
+		}
+		if c.FullText {
+			t.FullText["public:"+table+":"+c.Name] = c
+		}
+		t.Columns["public:"+table+":"+c.Name] = c
+	}
+	di.Tables["public:"+table] = t
+}
+
+// newTestCompiler applies the config to the database info, the test tables are used when di is nil
+func newTestCompiler(t *testing.T, conf *Config, di *DBInfo) *compiler {
+	t.Helper()
+	if di == nil {
+		di = newTestInfo()
+	}
+	if err := di.applyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newCompiler(conf, di)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func compileTest(t *testing.T, conf *Config, gql string, vars map[string]interface{}) *statement {
+	t.Helper()
+	st, err := compileSession(t, newTestCompiler(t, conf, nil), gql, vars, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// compileQuery fails the test when the document does not parse, the errors of the compiler are returned
+func compileQuery(t *testing.T, c *compiler, gql string, vars map[string]interface{}, sess *session) (*query, error) {
+	t.Helper()
+	doc, err := parser.ParseQuery(&_lexer.Input{Content: gql})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.compile(doc, doc.Operations[0], vars, sess)
+}
+
+func compileSession(t *testing.T, c *compiler, gql string, vars map[string]interface{}, sess *session) (*statement, error) {
+	t.Helper()
+	q, err := compileQuery(t, c, gql, vars, sess)
+	if err != nil {
+		return nil, err
+	}
+	return c.render(q)
+}
+
+func TestCompileQuery(t *testing.T) {
+	st := compileTest(t, &Config{}, `
+	query {
+		users(where: {email: {like: "%@x.io"}}, sort: {full_name: desc, id: asc}, limit: 5) {
+			id
+			name: full_name
+			__typename
+			posts { title }
+		}
+	}`, nil)
+
+	expected := `SELECT json_build_object('users', "__sj_0"."json") AS "__root" FROM (SELECT true) AS "__root_x" ` +
+		`LEFT OUTER JOIN LATERAL (SELECT coalesce(json_agg("__sj_0"."json"), '[]') AS "json" FROM (` +
+		`SELECT json_build_object('id', "users_0"."id", 'name', "users_0"."full_name", '__typename', 'users', 'posts', "__sj_1"."json") AS "json" FROM (` +
+		`SELECT "users_0".* FROM "public"."users" AS "users_0" WHERE ("users_0"."email" LIKE $1) ` +
+		`ORDER BY "users_0"."full_name" DESC, "users_0"."id" ASC LIMIT 5) AS "users_0" ` +
+		`LEFT OUTER JOIN LATERAL (SELECT coalesce(json_agg("__sj_1"."json"), '[]') AS "json" FROM (` +
+		`SELECT json_build_object('title', "posts_1"."title") AS "json" FROM (` +
+		`SELECT "posts_1".* FROM "public"."posts" AS "posts_1" WHERE "posts_1"."user_id" = "users_0"."id" LIMIT 20) AS "posts_1"` +
+		`) AS "__sj_1") AS "__sj_1" ON true) AS "__sj_0") AS "__sj_0" ON true`
+	if st.sql != expected {
+		t.Errorf("unexpected sql:\n%s\nexpected:\n%s", st.sql, expected)
+	}
+	if len(st.args) != 1 || st.args[0] != "%@x.io" {
+		t.Errorf("unexpected args %v", st.args)
+	}
+}
+
+func TestCompileSingular(t *testing.T) {
+	st := compileTest(t, &Config{EnableCamelcase: true}, `
+	query ($id: ID!) {
+		posts(id: $id) { id users { fullName } }
+	}`, map[string]interface{}{"id": "3"})
+
+	// the id keeps the list of the schema, only the one to one relation is an object
+	if strings.Count(st.sql, "json_agg") != 1 || !strings.Contains(st.sql, `coalesce(json_agg("__sj_0"."json"), '[]')`) {
+		t.Errorf("expected a list of posts with a single user, got:\n%s", st.sql)
+	}
+	if !strings.Contains(st.sql, `WHERE ("posts_0"."id" = $1) LIMIT 1`) {
+		t.Errorf("expected primary key filter, got:\n%s", st.sql)
+	}
+	if !strings.Contains(st.sql, `'fullName', "users_1"."full_name"`) {
+		t.Errorf("expected camel case keys, got:\n%s", st.sql)
+	}
+}
+
+func TestCompileExpressions(t *testing.T) {
+	st := compileTest(t, &Config{}, `
+	query {
+		users(where: {or: [{id: {in: [1, 2]}}, {not: {tags: {notIn: ["a"]}}}], full_name: {isNull: false}}) {
+			email(skipIf: {id: {greaterThan: 10}})
+		}
+		posts(where: {meta: {hasKeyAny: ["a", "b"], contains: {x: 1}}}, distinctOn: ["user_id"]) { id }
+	}`, nil)
+
+	for _, v := range []string{
+		`(("users_0"."id" IN ($2, $3)) OR NOT NOT ("users_0"."tags" && ARRAY[$4]::text[]))`,
+		`("users_0"."full_name" IS NOT NULL)`,
+		`(CASE WHEN ("users_0"."id" > $1) THEN null ELSE "users_0"."email" END)`,
+		`SELECT DISTINCT ON ("posts_1"."user_id") "posts_1".*`,
+		`("posts_1"."meta"::jsonb @> $5::jsonb)`,
+		`("posts_1"."meta"::jsonb ?| ARRAY[$6, $7]::text[])`,
+		`ORDER BY "posts_1"."user_id" ASC`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+}
+
+func TestCompileEmptyLists(t *testing.T) {
+	st := compileTest(t, &Config{}, `
+	query ($ids: [ID!], $tags: [String!]) {
+		users(where: {id: {in: $ids}, tags: {notIn: $tags}}) { id }
+		posts(where: {id: {notIn: $ids}}) { id }
+	}`, map[string]interface{}{"ids": []interface{}{}, "tags": []interface{}{}})
+
+	for _, v := range []string{
+		`WHERE (false AND NOT ("users_0"."tags" && ARRAY[]::text[]))`,
+		`WHERE true LIMIT 20`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+	if len(st.args) != 0 {
+		t.Errorf("unexpected args %v", st.args)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, nil)
+	for _, gql := range []string{
+		`{ unknown { id } }`,
+		`{ users { password } }`,
+		`{ users(where: {id: {near: 1}}) { id } }`,
+		`{ users { ...missing } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+}
+
+func TestCompileOrderBy(t *testing.T) {
+	conf := &Config{Tables: []TableConfig{{Name: "posts", OrderBy: map[string][]string{
+		"newest":      {"id desc"},
+		"user_and_id": {"user_id desc nulls last", "id"},
+	}}}}
+	st := compileTest(t, conf, `{ posts(orderBy: user_and_id) { id } }`, nil)
+	if !strings.Contains(st.sql, `ORDER BY "posts_0"."user_id" DESC NULLS LAST, "posts_0"."id" ASC LIMIT 20`) {
+		t.Errorf("expected the named order, got:\n%s", st.sql)
+	}
+
+	c := newTestCompiler(t, conf, nil)
+	enum := c.schema("").Types["posts"+SUFFIX_ORDER_BY]
+	if len(enum.EnumValues) != 2 || enum.EnumValues[0].Name != "newest" || enum.EnumValues[1].Name != "user_and_id" {
+		t.Errorf("unexpected enum %+v", enum.EnumValues)
+	}
+	if _, err := compileSession(t, c, `{ posts(orderBy: oldest) { id } }`, nil, nil); err == nil {
+		t.Error("expected an error for an unknown order")
+	}
+
+	for _, list := range [][]string{{"title sideways"}, {"password asc"}, {""}} {
+		conf := &Config{Tables: []TableConfig{{Name: "posts", OrderBy: map[string][]string{"bad": list}}}}
+		if _, err := newCompiler(conf, newTestInfo()); err == nil {
+			t.Errorf("expected an error for %v", list)
+		}
+	}
+}
@@ -2,11 +2,14 @@ package core
 
 import (
 	"fmt"
+	"github.com/iancoleman/strcase"
 	"github.com/ichaly/tiny-go/core/internal/util"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -15,11 +18,21 @@ type Config struct {
 	Debug     bool             `jsonschema:"title=Debug,default=false"`
 	Tables    []TableConfig    `jsonschema:"title=Tables"`
 	Resolvers []ResolverConfig `jsonschema:"-"`
+	Roles     []RoleConfig     `jsonschema:"title=Roles"`
 	Blocklist []string         `jsonschema:"title=Block List"`
+	Auth      AuthConfig       `jsonschema:"title=Authentication"`
 
+	Production      bool          `mapstructure:"production" json:"production" yaml:"production" jsonschema:"title=Production Mode,default=false"`
 	EnableCamelcase bool          `mapstructure:"enable_camelcase" json:"enable_camelcase" yaml:"enable_camelcase" jsonschema:"title=Enable Camel Case,default=false"`
 	ConfigPath      string        `mapstructure:"config_path" jsonschema:"title=Config Path"`
 	PollDuration    time.Duration `mapstructure:"poll_duration" json:"poll_duration" yaml:"poll_duration" jsonschema:"title=Schema Change Detection Polling Duration,default=10s"`
+	DefaultLimit    int           `mapstructure:"default_limit" json:"default_limit" yaml:"default_limit" jsonschema:"title=Default Row Limit,default=20"`
+	PollEvery       int           `mapstructure:"poll_every_seconds" json:"poll_every_seconds" yaml:"poll_every_seconds" jsonschema:"title=Subscription Polling Interval,default=5"`
+	SubsMode        string        `mapstructure:"subs_mode" json:"subs_mode" yaml:"subs_mode" jsonschema:"title=Subscription Mode,enum=poll,enum=notify,default=poll"`
+	RolesQuery      string        `mapstructure:"roles_query" json:"roles_query" yaml:"roles_query" jsonschema:"title=Roles Query"`
+	AuthFailBlock   bool          `mapstructure:"auth_fail_block" json:"auth_fail_block" yaml:"auth_fail_block" jsonschema:"title=Block Request On Authorization Failure,default=false"`
+	SecretKey       string        `mapstructure:"secret_key" json:"secret_key" yaml:"secret_key" jsonschema:"title=Secret Key For Encrypting Cursors"`
+	DisableAgg      bool          `mapstructure:"disable_agg_functions" json:"disable_agg_functions" yaml:"disable_agg_functions" jsonschema:"title=Disable Aggregation Functions,default=false"`
 	FS              interface{}   `mapstructure:"-" jsonschema:"-" json:"-"`
 }
 
@@ -32,6 +45,8 @@ type TableConfig struct {
 	Blocklist []string
 	// Permitted order by options
 	OrderBy map[string][]string `mapstructure:"order_by" json:"order_by" yaml:"order_by" jsonschema:"title=Order By Options,example=created_at desc"`
+	// Members are the tables a polymorphic table can point to
+	Members []string `jsonschema:"title=Member Tables,example=users"`
 
 	Query  *QueryConfig
 	Insert *InsertConfig
@@ -40,12 +55,79 @@ type TableConfig struct {
 	Delete *DeleteConfig
 }
 
+// RoleConfig restricts the tables for the requests made with the role
+type RoleConfig struct {
+	Name string
+	// Match is the sql expression that selects the role in the row of the roles query
+	Match  string `jsonschema:"title=Match,example=admin = true"`
+	Tables []RoleTable
+}
+
+type RoleTable struct {
+	Name     string
+	Schema   string
+	Block    bool
+	ReadOnly bool `mapstructure:"read_only" json:"read_only" yaml:"read_only" jsonschema:"title=Read Only"`
+
+	Query  *QueryConfig
+	Insert *InsertConfig
+	Update *UpdateConfig
+	Upsert *UpsertConfig
+	Delete *DeleteConfig
+}
+
+// AuthConfig selects how the user id and the role of a request are authenticated
+type AuthConfig struct {
+	// Type can be none, jwt, rails or header
+	Type   string `jsonschema:"title=Type,enum=none,enum=jwt,enum=rails,enum=header"`
+	Cookie string `jsonschema:"title=Cookie Name,example=_app_session"`
+	// CredsInHeader trusts the X-User-ID and X-User-Role headers of the requests no provider authenticated,
+	// it is refused in production
+	CredsInHeader bool `mapstructure:"creds_in_header" json:"creds_in_header" yaml:"creds_in_header" jsonschema:"title=Credentials In Header,default=false"`
+	// SubsCredsInVars trusts the user_id and user_role of the connection_init payload of the websocket connections
+	// no provider authenticated, it is refused in production
+	SubsCredsInVars bool `mapstructure:"subs_creds_in_vars" json:"subs_creds_in_vars" yaml:"subs_creds_in_vars" jsonschema:"title=Subscription Credentials In Variables,default=false"`
+
+	JWT    JWTConfig    `jsonschema:"title=JWT"`
+	Rails  RailsConfig  `jsonschema:"title=Rails"`
+	Header HeaderConfig `jsonschema:"title=Header"`
+}
+
+type JWTConfig struct {
+	Provider      string `jsonschema:"title=Provider,enum=auth0,enum=other"`
+	Secret        string `jsonschema:"title=HMAC Secret"`
+	PublicKeyFile string `mapstructure:"public_key_file" json:"public_key_file" yaml:"public_key_file" jsonschema:"title=Public Key File"`
+	PublicKeyType string `mapstructure:"public_key_type" json:"public_key_type" yaml:"public_key_type" jsonschema:"title=Public Key Type,enum=ecdsa,enum=rsa"`
+	// RoleClaim names the claim that holds the role, defaults to role
+	RoleClaim string `mapstructure:"role_claim" json:"role_claim" yaml:"role_claim" jsonschema:"title=Role Claim,default=role"`
+}
+
+// RailsConfig decrypts the session cookie of a rails app, versions 5.2 and later are supported
+type RailsConfig struct {
+	Version       string `jsonschema:"title=Rails Version,example=5.2,example=7.0"`
+	SecretKeyBase string `mapstructure:"secret_key_base" json:"secret_key_base" yaml:"secret_key_base" jsonschema:"title=Secret Key Base"`
+	// AuthSalt is the salt of the authenticated encrypted cookies, defaults to `authenticated encrypted cookie`
+	AuthSalt string `mapstructure:"auth_salt" json:"auth_salt" yaml:"auth_salt" jsonschema:"title=Authenticated Encrypted Cookie Salt"`
+	URL      string `jsonschema:"title=Remote Cookie Store URL"`
+}
+
+// HeaderConfig authenticates requests that carry the header, with Exists any value is accepted
+type HeaderConfig struct {
+	Name   string `jsonschema:"title=Header Name,example=X-Gateway"`
+	Value  string `jsonschema:"title=Header Value"`
+	Exists bool   `jsonschema:"title=Header Exists"`
+}
+
 type Column struct {
 	Name       string
 	Type       string `jsonschema:"example=integer,example=text"`
 	Array      bool
 	Primary    bool
 	ForeignKey string `mapstructure:"related_to" json:"related_to" yaml:"related_to" jsonschema:"title=Related To,example=other_table.id_column,example=users.id"`
+	// Field names the object field of the foreign key, the column name without `_id` by default
+	Field string
+	// RelatedField names the list field on the related table, the name of this table by default
+	RelatedField string `mapstructure:"related_field" json:"related_field" yaml:"related_field"`
 }
 
 type QueryConfig struct {
@@ -54,6 +136,7 @@ type QueryConfig struct {
 	Filters          []string
 	Columns          []string
 	DisableFunctions bool `mapstructure:"disable_functions" json:"disable_functions" yaml:"disable_functions"`
+	DisableAgg       bool `mapstructure:"disable_aggregation" json:"disable_aggregation" yaml:"disable_aggregation"`
 	Block            bool
 }
 
@@ -84,6 +167,25 @@ type DeleteConfig struct {
 	Block   bool
 }
 
+func (my *Config) getName(name string, isField ...bool) string {
+	if my.EnableCamelcase {
+		if len(isField) > 0 && isField[0] {
+			return strcase.ToLowerCamel(name)
+		}
+		return strcase.ToCamel(name)
+	}
+	return name
+}
+
+func (my *Config) getTable(name string) *TableConfig {
+	for i, t := range my.Tables {
+		if t.Name == name {
+			return &my.Tables[i]
+		}
+	}
+	return nil
+}
+
 func ReadInConfig(configFile string) (*Config, error) {
 	return readInConfig(configFile, nil)
 }
@@ -132,13 +234,41 @@ func readInConfig(configFile string, fs afero.Fs) (*Config, error) {
 	c := &Config{}
 	c.ConfigPath = cp
 
-	if err := vi.Unmarshal(&c); err != nil {
+	hook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mergeMapsHook,
+	))
+	if err := vi.Unmarshal(&c, hook); err != nil {
 		return nil, fmt.Errorf("failed to decode config, %v", err)
 	}
 
 	return c, nil
 }
 
+// mergeMapsHook accepts a list of single key maps where a map is expected,
+// the presets of the roles are written as `- user_id: $user_id`
+func mergeMapsHook(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if f.Kind() != reflect.Slice || t.Kind() != reflect.Map {
+		return data, nil
+	}
+	list, ok := data.([]interface{})
+	if !ok {
+		return data, nil
+	}
+	res := make(map[string]interface{})
+	for _, v := range list {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return data, nil
+		}
+		for k, val := range m {
+			res[k] = val
+		}
+	}
+	return res, nil
+}
+
 func newViper(configPath, configFile string) *viper.Viper {
 	vi := newViperWithDefaults()
 	vi.SetConfigName(strings.TrimSuffix(configFile, filepath.Ext(configFile)))
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ichaly/tiny-go/core/ast"
+	"strings"
+)
+
+var (
+	reverseDir        = strings.NewReplacer("ASC", "DESC", "DESC", "ASC", "FIRST", "LAST", "LAST", "FIRST")
+	errInvalidCursor  = errors.New("invalid cursor")
+	errCursorNoSecret = errors.New("cursor pagination requires a secret_key")
+)
+
+// paging is the keyset pagination of a list, the cursor holds the values of the order columns of a row
+type paging struct {
+	last   bool
+	cursor bool
+	after  *pageCursor
+	before *pageCursor
+}
+
+// pageCursor is a decrypted cursor, on is the table and the order of the list it was sealed for
+type pageCursor struct {
+	On     string          `json:"on"`
+	Values json.RawMessage `json:"values"`
+	values []interface{}
+}
+
+func newCursorCipher(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parsePaging reads the first, last, after and before arguments
+func (my *builder) parsePaging(s *selection, name string, v interface{}) error {
+	if s.paging == nil {
+		s.paging = &paging{}
+	}
+	var err error
+	switch name {
+	case "first", "last":
+		if s.limit, err = toInt(v); err != nil {
+			return fmt.Errorf("argument '%s': %w", name, err)
+		}
+		s.paging.last = name == "last"
+	case "after":
+		s.paging.after, err = my.decryptCursor(v)
+	case "before":
+		s.paging.before, err = my.decryptCursor(v)
+	}
+	if err != nil {
+		return fmt.Errorf("argument '%s': %w", name, err)
+	}
+	return nil
+}
+
+// finishPaging orders the list by the primary key last so that every row has a distinct cursor,
+// the cursors of after and before become seek predicates on the order columns
+func (my *builder) finishPaging(s *selection) error {
+	if s.mutation != nil || s.singular {
+		return fmt.Errorf("pagination is only supported on lists, not on '%s'", s.name)
+	}
+	if len(s.distinct) != 0 {
+		return fmt.Errorf("pagination cannot be combined with 'distinctOn' on '%s'", s.name)
+	}
+	pk := s.table.PrimaryCol
+	if pk.Name == "" {
+		return fmt.Errorf("pagination on '%s' requires a primary key", s.table.Name)
+	}
+	if !hasOrder(s.orders, pk) {
+		s.orders = append(s.orders, &order{column: pk, dir: "ASC"})
+	}
+	on := cursorOn(s)
+	for _, v := range []struct {
+		cursor  *pageCursor
+		reverse bool
+	}{{s.paging.after, false}, {s.paging.before, true}} {
+		if v.cursor == nil {
+			continue
+		}
+		if v.cursor.On != on || len(v.cursor.values) != len(s.orders) {
+			return fmt.Errorf("cursor was not created for the table and order of '%s'", s.name)
+		}
+		s.where = and(s.where, seek(s.orders, v.cursor.values, v.reverse))
+	}
+	return nil
+}
+
+// cursorOn names the table and the order columns with their directions, a cursor only seeks in the list it came from:
+// public.users:full_name DESC,id ASC
+func cursorOn(s *selection) string {
+	var b strings.Builder
+	b.WriteString(s.table.Schema + "." + s.table.Name + ":")
+	for i, o := range s.orders {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(o.column.Name + " " + o.dir)
+	}
+	return b.String()
+}
+
+// seek selects the rows after the cursor in the order, or before it when reversed:
+// (a > $1) OR (a = $1 AND b > $2) ...
+// Nulls sort last in ascending and first in descending orders unless the order places them.
+func seek(orders []*order, values []interface{}, reverse bool) *exp {
+	res := &exp{op: opOr}
+	for i, o := range orders {
+		asc := strings.HasPrefix(o.dir, "ASC")
+		nullsFirst := strings.HasSuffix(o.dir, "NULLS FIRST") || (!asc && !strings.HasSuffix(o.dir, "NULLS LAST"))
+		if reverse {
+			asc, nullsFirst = !asc, !nullsFirst
+		}
+		nullable := !o.column.NotNull
+
+		var next *exp
+		switch {
+		case values[i] == nil && nullsFirst:
+			next = &exp{op: opIsNull, column: o.column, value: false}
+		case values[i] == nil:
+			// no value follows a null that sorts last, only the next order columns move on
+			continue
+		default:
+			op := opLesserThan
+			if asc {
+				op = opGreaterThan
+			}
+			next = &exp{op: op, column: o.column, value: values[i]}
+			if nullable && !nullsFirst {
+				next = &exp{op: opOr, children: []*exp{next, {op: opIsNull, column: o.column, value: true}}}
+			}
+		}
+
+		e := &exp{op: opAnd}
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				e.children = append(e.children, &exp{op: opIsNull, column: orders[j].column, value: true})
+			} else {
+				e.children = append(e.children, &exp{op: opEquals, column: orders[j].column, value: values[j]})
+			}
+		}
+		e.children = append(e.children, next)
+		res.children = append(res.children, e)
+	}
+	return res
+}
+
+// reverseOrders flips the directions, `last` reads the list from its end
+func reverseOrders(orders []*order) []*order {
+	res := make([]*order, len(orders))
+	for i, o := range orders {
+		res[i] = &order{column: o.column, dir: reverseDir.Replace(o.dir)}
+	}
+	return res
+}
+
+// cursorField returns the field `<list>_cursor`, the cursor of the list that is selected next to it
+func cursorField(f *ast.Field) (*field, bool) {
+	name := strings.TrimSuffix(f.Name, SUFFIX_CURSOR)
+	if name == f.Name || name == "" || len(f.SelectionSet) != 0 {
+		return nil, false
+	}
+	return &field{kind: kindCursor, name: responseKey(f), value: name}, true
+}
+
+// linkCursors connects every `<field>_cursor` to the list of the field
+func (my *builder) linkCursors(fields []*field) error {
+	for _, f := range fields {
+		if f.kind != kindCursor {
+			continue
+		}
+		for _, v := range fields {
+			if v.kind == kindSelect && v.child.name == f.value {
+				f.child = v.child
+				break
+			}
+		}
+		if f.child == nil {
+			return fmt.Errorf("cannot query field '%s' without the list '%s'", f.name, f.value)
+		}
+		if my.cipher == nil {
+			return errCursorNoSecret
+		}
+		s := f.child
+		if s.paging == nil {
+			s.paging = &paging{}
+			if err := my.finishPaging(s); err != nil {
+				return err
+			}
+		}
+		s.paging.cursor = true
+		my.cursor = true
+	}
+	return nil
+}
+
+func (my *builder) decryptCursor(v interface{}) (*pageCursor, error) {
+	if my.cipher == nil {
+		return nil, errCursorNoSecret
+	}
+	data, err := base64.RawURLEncoding.DecodeString(fmt.Sprint(v))
+	if err != nil || len(data) < my.cipher.NonceSize() {
+		return nil, errInvalidCursor
+	}
+	n := my.cipher.NonceSize()
+	plain, err := my.cipher.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	c := &pageCursor{}
+	if err = json.Unmarshal(plain, c); err != nil {
+		return nil, errInvalidCursor
+	}
+	dec := json.NewDecoder(bytes.NewReader(c.Values))
+	dec.UseNumber()
+	if err = dec.Decode(&c.values); err != nil {
+		return nil, errInvalidCursor
+	}
+	// the values are bound as text, postgres casts them to the type of the column
+	for i, val := range c.values {
+		switch val := val.(type) {
+		case json.Number:
+			c.values[i] = val.String()
+		case map[string]interface{}, []interface{}:
+			b, _ := json.Marshal(val)
+			c.values[i] = string(b)
+		}
+	}
+	return c, nil
+}
+
+// encryptCursor seals the order values of the last row of a list next to the table and the order of the list,
+// an empty list has no cursor
+func (my *compiler) encryptCursor(s *selection, v json.RawMessage) (json.RawMessage, error) {
+	if isNullJSON(v) {
+		return v, nil
+	}
+	var text string
+	if err := json.Unmarshal(v, &text); err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(&pageCursor{On: cursorOn(s), Values: json.RawMessage(text)})
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, my.cipher.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := my.cipher.Seal(nonce, nonce, plain, nil)
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(sealed))
+}
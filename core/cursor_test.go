@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testCursor seals the values as the cursor of the list of the first cursor field of the query
+func testCursor(t *testing.T, conf *Config, gql, values string) string {
+	t.Helper()
+	c := newTestCompiler(t, conf, nil)
+	q, err := compileQuery(t, c, gql, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	for _, f := range q.fields {
+		if f.kind == kindCursor {
+			key = f.name
+			break
+		}
+	}
+	raw, _ := json.Marshal(map[string]string{key: values})
+	data, _, err := c.finishResponse(context.Background(), q, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]string
+	if err = json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res[key] == "" || strings.Contains(res[key], values) {
+		t.Fatalf("expected an encrypted cursor, got %s", res[key])
+	}
+	return res[key]
+}
+
+func TestCompileCursor(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	st := compileTest(t, conf, `
+	query ($after: Cursor) {
+		users(first: 10, after: $after, sort: {full_name: desc}) { id }
+		users_cursor
+	}`, map[string]interface{}{"after": testCursor(t, conf, `{ users(sort: {full_name: desc}) { id } users_cursor }`, `["a", 3]`)})
+
+	for _, v := range []string{
+		`json_build_object('users', "__sj_0"."json", 'users_cursor', "__sj_0"."cursor")`,
+		`, (array_agg(json_build_array("__sj_0"."__cur_0", "__sj_0"."__cur_1")::text ` +
+			`ORDER BY "__sj_0"."__cur_0" DESC, "__sj_0"."__cur_1" ASC))[count(*)] AS "cursor"`,
+		`AS "json", "users_0"."full_name" AS "__cur_0", "users_0"."id" AS "__cur_1" FROM (`,
+		`WHERE ((("users_0"."full_name" < $1)) OR (("users_0"."full_name" = $2) AND ("users_0"."id" > $3))) ` +
+			`ORDER BY "users_0"."full_name" DESC, "users_0"."id" ASC LIMIT 10`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+	if len(st.args) != 3 || st.args[0] != "a" || st.args[1] != "a" || st.args[2] != "3" {
+		t.Errorf("unexpected args %v", st.args)
+	}
+}
+
+func TestCompileCursorLast(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	st := compileTest(t, conf, `
+	query ($before: Cursor) {
+		users {
+			id
+			posts(last: 2, before: $before) { id }
+			posts_cursor
+		}
+	}`, map[string]interface{}{"before": testCursor(t, conf, `{ posts { id } posts_cursor }`, `[5]`)})
+
+	for _, v := range []string{
+		`'posts_cursor', "__sj_1"."cursor"`,
+		`json_agg("__sj_1"."json" ORDER BY "__sj_1"."__cur_0" ASC)`,
+		`(array_agg(json_build_array("__sj_1"."__cur_0")::text ORDER BY "__sj_1"."__cur_0" DESC))[count(*)]`,
+		`AND ((("posts_1"."id" < $1))) ORDER BY "posts_1"."id" DESC LIMIT 2`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+}
+
+func TestCompileCursorNulls(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	for _, v := range []struct {
+		sort, gql, cursor, expected string
+	}{
+		{`asc`, `users(first: 2, after: $c, sort: {full_name: asc})`, `["a", 3]`,
+			`(((("users_0"."full_name" > $1) OR ("users_0"."full_name" IS NULL))) OR (("users_0"."full_name" = $2) AND ("users_0"."id" > $3)))`},
+		{`asc`, `users(first: 2, after: $c, sort: {full_name: asc})`, `[null, 3]`,
+			`((("users_0"."full_name" IS NULL) AND ("users_0"."id" > $1)))`},
+		{`desc`, `users(first: 2, after: $c, sort: {full_name: desc})`, `[null, 3]`,
+			`((("users_0"."full_name" IS NOT NULL)) OR (("users_0"."full_name" IS NULL) AND ("users_0"."id" > $1)))`},
+		{`asc`, `users(last: 2, before: $c, sort: {full_name: asc})`, `[null, 3]`,
+			`((("users_0"."full_name" IS NOT NULL)) OR (("users_0"."full_name" IS NULL) AND ("users_0"."id" < $1)))`},
+	} {
+		c := testCursor(t, conf, `{ users(sort: {full_name: `+v.sort+`}) { id } users_cursor }`, v.cursor)
+		st := compileTest(t, conf, `query ($c: Cursor) { `+v.gql+` { id } }`, map[string]interface{}{"c": c})
+		if !strings.Contains(st.sql, v.expected) {
+			t.Errorf("expected %s in:\n%s", v.expected, st.sql)
+		}
+	}
+}
+
+func TestEncryptCursors(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	c := newTestCompiler(t, conf, nil)
+	q, err := compileQuery(t, c, `{ users(first: 1) { email } users_cursor }`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the cursor field is encrypted, a column value never is
+	data, _, err := c.finishResponse(context.Background(), q, []byte(`{"users":[{"email":"__gj_cur:[1]"}],"users_cursor":"[1]"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res struct {
+		Users []struct {
+			Email string `json:"email"`
+		} `json:"users"`
+		Cursor string `json:"users_cursor"`
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 || res.Users[0].Email != "__gj_cur:[1]" || res.Cursor == "[1]" || res.Cursor == "" {
+		t.Errorf("unexpected response %s", data)
+	}
+	// the objects of a union are tagged with their member
+	conf, di := newPolymorphicInfo()
+	conf.SecretKey = "secret"
+	c = newTestCompiler(t, conf, di)
+	if q, err = compileQuery(t, c, `{
+		notifications { subject { ... on posts { title } ... on users { posts(first: 1) { id } posts_cursor } } }
+	}`, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	st, err := c.render(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := `coalesce(CASE WHEN "__sj_2"."json" IS NOT NULL THEN json_build_array(0, "__sj_2"."json") END, CASE WHEN`; !strings.Contains(st.sql, v) {
+		t.Errorf("expected %s in:\n%s", v, st.sql)
+	}
+	data, _, err = c.finishResponse(context.Background(), q, []byte(`{"notifications":[{"subject":[1,{"posts":[{"id":1}],"posts_cursor":"[1]"}]},{"subject":null}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(data); !strings.HasPrefix(v, `{"notifications":[{"subject":{"posts":[{"id":1}],"posts_cursor":"`) || strings.Contains(v, `"[1]"`) {
+		t.Errorf("unexpected response %s", data)
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	valid := testCursor(t, conf, `{ users { id } users_cursor }`, `[1]`)
+	for _, v := range []struct {
+		conf *Config
+		gql  string
+	}{
+		{&Config{}, `{ users { id } users_cursor }`},
+		{&Config{}, `{ users(after: "x") { id } }`},
+		{conf, `{ users(after: "x") { id } }`},
+		{conf, `{ users(after: "` + valid + `", sort: {email: asc}) { id } }`},
+		{conf, `{ posts_cursor }`},
+		{conf, `{ posts { users(first: 1) { id } } }`},
+		{conf, `{ posts(distinctOn: ["user_id"]) { id } posts_cursor }`},
+		{conf, `mutation { users(id: 1, delete: true) { id } users_cursor }`},
+	} {
+		if _, err := compileSession(t, newTestCompiler(t, v.conf, nil), v.gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", v.gql)
+		}
+	}
+}
+
+func TestCursorBinding(t *testing.T) {
+	conf := &Config{SecretKey: "secret"}
+	c := newTestCompiler(t, conf, nil)
+	byName := testCursor(t, conf, `{ users(sort: {full_name: desc}) { id } users_cursor }`, `["a", 3]`)
+	byPost := testCursor(t, conf, `{ posts { id } posts_cursor }`, `[3]`)
+
+	// the cursors only seek in the table and the order they were created for
+	for _, gql := range []string{
+		`{ users(after: "` + byName + `", sort: {email: desc}) { id } }`,
+		`{ users(after: "` + byName + `", sort: {full_name: asc}) { id } }`,
+		`{ users(after: "` + byPost + `") { id } }`,
+	} {
+		_, err := compileSession(t, c, gql, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "cursor was not created for the table and order of 'users'") {
+			t.Errorf("expected a binding error for %s, got %v", gql, err)
+		}
+	}
+	if _, err := compileSession(t, c, `{ users(after: "`+byName+`", sort: {full_name: desc}) { id } }`, nil, nil); err != nil {
+		t.Error(err)
+	}
+}
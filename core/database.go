@@ -6,6 +6,7 @@ import (
 	"github.com/ichaly/tiny-go/core/internal"
 	"github.com/ichaly/tiny-go/core/internal/data"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -16,20 +17,73 @@ type DBInfo struct {
 	Name    string
 	Tables  map[string]*DBTable
 	VTables []VirtualTable `json:"-"` // for polymorphic relationships
+	// Functions are the user defined functions ordered by schema and name
+	Functions []*DBFunction
 
 	relation data.BiDict
-	hash     int
+	// through are the join tables of the many-to-many relations
+	through []*DBTable
+	// relFields are the relationship fields of every table
+	relFields map[*DBTable][]DBRelField
+	hash      int
 }
 
 func (my *DBInfo) Hash() int {
 	return my.hash
 }
 
+// GetTable returns the table with the given schema and name
+func (my *DBInfo) GetTable(schema, name string) (*DBTable, bool) {
+	t, ok := my.Tables[fmt.Sprintf("%s:%s", schema, name)]
+	return t, ok
+}
+
+// table types of the table configs
+const (
+	tablePolymorphic = "polymorphic"
+	tableJSON        = "json"
+	tableJSONB       = "jsonb"
+)
+
+// DBFunction is a user defined function, the type of a function returning the rows of a table is the table name
+type DBFunction struct {
+	ID      string
+	Schema  string
+	Name    string
+	Type    string
+	Set     bool
+	Inputs  []DBFuncParam
+	Outputs []DBFuncParam
+}
+
+type DBFuncParam struct {
+	ID   int
+	Name string
+	Type string
+}
+
+func (my *DBFunction) String() string {
+	return my.Schema + "." + my.Name
+}
+
+// VirtualTable is a polymorphic relationship, the type column holds the table name of the row
+// the foreign key column points to
 type VirtualTable struct {
 	Name       string
 	IDColumn   string
 	TypeColumn string
 	FKeyColumn string
+	Members    []string
+}
+
+// hasMember reports whether the type column may name the table
+func (my *VirtualTable) hasMember(name string) bool {
+	for _, v := range my.Members {
+		if v == name {
+			return true
+		}
+	}
+	return false
 }
 
 type DBTable struct {
@@ -41,12 +95,48 @@ type DBTable struct {
 	Columns    map[string]DBColumn
 	FullText   map[string]DBColumn
 	Blocked    bool
+	Source     string // the database table of an aliased table
 }
 
 func (my *DBTable) String() string {
 	return my.Schema + "." + my.Name
 }
 
+// embedded reports whether the rows of the table are the json array in a column of its source table
+func (my *DBTable) embedded() bool {
+	return my.Type == tableJSON || my.Type == tableJSONB
+}
+
+// sourceName returns the name of the table in the database
+func (my *DBTable) sourceName() string {
+	if my.Source != "" {
+		return my.Source
+	}
+	return my.Name
+}
+
+// GetColumn returns the column of this table with the given database name
+func (my *DBTable) GetColumn(name string) (DBColumn, bool) {
+	for _, c := range my.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return DBColumn{}, false
+}
+
+// SortedColumns returns the columns ordered by name so that generated code is stable
+func (my *DBTable) SortedColumns() []DBColumn {
+	list := make([]DBColumn, 0, len(my.Columns))
+	for _, c := range my.Columns {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
 type DBColumn struct {
 	Comment     string
 	Name        string
@@ -60,6 +150,9 @@ type DBColumn struct {
 	FKeySchema  string
 	FKeyTable   string
 	FKeyCol     string
+	// FKeyField and FKeyRelated override the names of the relationship fields of the foreign key
+	FKeyField   string
+	FKeyRelated string
 	Blocked     bool
 
 	Schema      string
@@ -89,8 +182,8 @@ func GetDBInfo(db *sql.DB, dialect string, blockList []string) (*DBInfo, error)
 
 	// get db info
 	row := db.QueryRow(internal.PostgresInfo)
-	if row.Scan(&dbVersion, &dbSchema, &dbName) != nil {
-		return nil, err
+	if err = row.Scan(&dbVersion, &dbSchema, &dbName); err != nil {
+		return nil, fmt.Errorf("error fetching database info: %s", err)
 	}
 
 	// get columns from db
@@ -123,7 +216,7 @@ func GetDBInfo(db *sql.DB, dialect string, blockList []string) (*DBInfo, error)
 		if err != nil {
 			return nil, err
 		}
-		if strings.HasPrefix(c.Table, "_gj_") {
+		if strings.HasPrefix(c.Table, "_gj_") || strings.HasPrefix(c.Schema, "_gj_") {
 			continue
 		}
 
@@ -162,11 +255,70 @@ func GetDBInfo(db *sql.DB, dialect string, blockList []string) (*DBInfo, error)
 		if c.FKeyTable != "" {
 			di.relation.Put(t.Name, c.FKeyTable)
 		}
+		// a column is listed once per constraint, the keys of a join table are both primary and foreign keys
+		if v, ok := t.Columns[ck]; ok {
+			c.PrimaryKey, c.UniqueKey = c.PrimaryKey || v.PrimaryKey, c.UniqueKey || v.UniqueKey
+			if c.FKeyTable == "" {
+				c.FKeySchema, c.FKeyTable, c.FKeyCol, c.FKRecursive = v.FKeySchema, v.FKeyTable, v.FKeyCol, v.FKRecursive
+			}
+		}
 		t.Columns[ck] = c
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if di.Functions, err = getFunctions(db, blockList); err != nil {
+		return nil, err
+	}
 	return di, nil
 }
 
+// getFunctions reads the user defined functions with their parameters, only the first of overloaded functions is kept
+func getFunctions(db *sql.DB, blockList []string) ([]*DBFunction, error) {
+	rows, err := db.Query(internal.PostgresFunctions)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching functions: %s", err)
+	}
+	defer rows.Close()
+
+	var (
+		list  []*DBFunction
+		names = make(map[string]string)
+	)
+	for rows.Next() {
+		var (
+			fn   DBFunction
+			p    DBFuncParam
+			kind string
+		)
+		if err = rows.Scan(&fn.ID, &fn.Schema, &fn.Name, &fn.Type, &fn.Set, &p.ID, &p.Name, &p.Type, &kind); err != nil {
+			return nil, err
+		}
+		if isBlocked(fn.Name, blockList) {
+			continue
+		}
+		key := fn.Schema + ":" + fn.Name
+		if id, ok := names[key]; ok && id != fn.ID {
+			continue
+		} else if !ok {
+			names[key] = fn.ID
+			list = append(list, &fn)
+		}
+		last := list[len(list)-1]
+		switch {
+		case p.ID == 0:
+		case kind == "IN" || kind == "INOUT" || kind == "":
+			last.Inputs = append(last.Inputs, p)
+			if kind == "INOUT" {
+				last.Outputs = append(last.Outputs, p)
+			}
+		default:
+			last.Outputs = append(last.Outputs, p)
+		}
+	}
+	return list, rows.Err()
+}
+
 func isBlocked(val string, list []string) bool {
 	for _, v := range list {
 		regex := fmt.Sprintf("^%s$", v)
@@ -176,3 +328,213 @@ func isBlocked(val string, list []string) bool {
 	}
 	return false
 }
+
+// applyConfig merges the table configs onto the tables read from the database: aliases become tables
+// of their own, `related_to` adds the foreign keys the catalog cannot see and `primary` sets the primary
+// key of views. Tables that are missing in the database are skipped. The join tables are found last.
+func (my *DBInfo) applyConfig(conf *Config) error {
+	if my.relation == nil {
+		my.relation = data.BiDict{}
+	}
+	my.VTables = nil
+	for _, tc := range conf.Tables {
+		if tc.Type == tablePolymorphic {
+			vt, err := newVirtualTable(tc)
+			if err != nil {
+				return err
+			}
+			my.VTables = append(my.VTables, vt)
+			continue
+		}
+		if tc.Type == tableJSON || tc.Type == tableJSONB {
+			if err := my.addEmbedded(tc); err != nil {
+				return err
+			}
+			continue
+		}
+		if isAlias(tc) {
+			continue
+		}
+		if t, ok := my.findTable(tc.Schema, tc.Name); ok {
+			if err := my.applyColumns(t, tc.Columns); err != nil {
+				return err
+			}
+		}
+	}
+	// the aliases are copied after the columns so that they share the foreign keys of their tables
+	for _, tc := range conf.Tables {
+		if !isAlias(tc) {
+			continue
+		}
+		base, ok := my.findTable(tc.Schema, tc.Table)
+		if !ok {
+			continue
+		}
+		t := &DBTable{
+			Name:     tc.Name,
+			Schema:   base.Schema,
+			Comment:  base.Comment,
+			Type:     base.Type,
+			Columns:  make(map[string]DBColumn),
+			FullText: make(map[string]DBColumn),
+			Blocked:  base.Blocked,
+			Source:   base.sourceName(),
+		}
+		for _, c := range base.Columns {
+			c.Table = t.Name
+			ck := fmt.Sprintf("%s:%s:%s", t.Schema, t.Name, c.Name)
+			if c.FullText {
+				t.FullText[ck] = c
+			}
+			if c.PrimaryKey {
+				t.PrimaryCol = c
+			}
+			t.Columns[ck] = c
+		}
+		my.Tables[fmt.Sprintf("%s:%s", t.Schema, t.Name)] = t
+		if err := my.applyColumns(t, tc.Columns); err != nil {
+			return err
+		}
+	}
+	my.addThrough()
+	my.addRelFields()
+	return nil
+}
+
+// newVirtualTable reads the polymorphic config `{ name: subject_id, related_to: subject_type.id }`,
+// the row of the type named in `subject_type` is joined on its `id` column when the type is one of the members
+func newVirtualTable(tc TableConfig) (VirtualTable, error) {
+	if len(tc.Columns) != 1 {
+		return VirtualTable{}, fmt.Errorf("polymorphic table '%s' requires a single column", tc.Name)
+	}
+	c := tc.Columns[0]
+	parts := strings.Split(c.ForeignKey, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return VirtualTable{}, fmt.Errorf("polymorphic table '%s' requires related_to as 'type_column.id_column'", tc.Name)
+	}
+	if len(tc.Members) == 0 {
+		return VirtualTable{}, fmt.Errorf("polymorphic table '%s' requires its member tables", tc.Name)
+	}
+	return VirtualTable{
+		Name: tc.Name, IDColumn: parts[1], TypeColumn: parts[0], FKeyColumn: c.Name, Members: tc.Members,
+	}, nil
+}
+
+// GetVirtualTable returns the polymorphic relationship of the table with the given name
+func (my *DBInfo) GetVirtualTable(t *DBTable, name string) (*VirtualTable, bool) {
+	for i, vt := range my.VTables {
+		if vt.Name != name {
+			continue
+		}
+		_, ok1 := t.GetColumn(vt.TypeColumn)
+		_, ok2 := t.GetColumn(vt.FKeyColumn)
+		if ok1 && ok2 {
+			return &my.VTables[i], true
+		}
+	}
+	return nil, false
+}
+
+// addEmbedded adds the table of the json column `name` of the table `table`,
+// its columns are declared in the config since the catalog cannot see them
+func (my *DBInfo) addEmbedded(tc TableConfig) error {
+	base, ok := my.findTable(tc.Schema, tc.Table)
+	if !ok {
+		return nil
+	}
+	if c, ok := base.GetColumn(tc.Name); !ok || (c.Type != tableJSON && c.Type != tableJSONB) {
+		return fmt.Errorf("table '%s' requires the json column '%s' on '%s'", tc.Name, tc.Name, base.Name)
+	}
+	if len(tc.Columns) == 0 {
+		return fmt.Errorf("%s table '%s' requires columns", tc.Type, tc.Name)
+	}
+	t := &DBTable{
+		Name:     tc.Name,
+		Schema:   base.Schema,
+		Type:     tc.Type,
+		Columns:  make(map[string]DBColumn),
+		FullText: make(map[string]DBColumn),
+		Blocked:  base.Blocked,
+		Source:   base.sourceName(),
+	}
+	for _, cc := range tc.Columns {
+		if cc.Type == "" {
+			return fmt.Errorf("%s table '%s' column '%s' requires a type", tc.Type, tc.Name, cc.Name)
+		}
+		c := DBColumn{Name: cc.Name, Type: cc.Type, Array: cc.Array, Schema: t.Schema, Table: t.Name}
+		t.Columns[fmt.Sprintf("%s:%s:%s", t.Schema, t.Name, c.Name)] = c
+	}
+	my.Tables[fmt.Sprintf("%s:%s", t.Schema, t.Name)] = t
+	return my.applyColumns(t, tc.Columns)
+}
+
+func isAlias(tc TableConfig) bool {
+	return tc.Table != "" && tc.Table != tc.Name && tc.Type == ""
+}
+
+func (my *DBInfo) applyColumns(t *DBTable, columns []Column) error {
+	for _, cc := range columns {
+		ck := fmt.Sprintf("%s:%s:%s", t.Schema, t.Name, cc.Name)
+		c, ok := t.Columns[ck]
+		if !ok {
+			return fmt.Errorf("table '%s': unknown column '%s'", t.Name, cc.Name)
+		}
+		c.Array = c.Array || cc.Array
+		if cc.Primary && c.Name != t.PrimaryCol.Name {
+			if t.PrimaryCol.Name != "" {
+				return fmt.Errorf("table '%s' already has the primary key '%s'", t.Name, t.PrimaryCol.Name)
+			}
+			c.PrimaryKey, c.UniqueKey = true, true
+			t.PrimaryCol = c
+		}
+		if cc.ForeignKey != "" {
+			ft, fc, err := my.findColumn(cc.ForeignKey, t.Schema)
+			if err != nil {
+				return fmt.Errorf("table '%s' column '%s': %w", t.Name, cc.Name, err)
+			}
+			c.FKeySchema, c.FKeyTable, c.FKeyCol = ft.Schema, ft.sourceName(), fc.Name
+			c.FKRecursive = ft.Schema == t.Schema && ft.sourceName() == t.sourceName()
+			my.relation.Put(t.sourceName(), ft.sourceName())
+		}
+		if cc.Field != "" {
+			c.FKeyField = cc.Field
+		}
+		if cc.RelatedField != "" {
+			c.FKeyRelated = cc.RelatedField
+		}
+		if c.FullText {
+			t.FullText[ck] = c
+		}
+		t.Columns[ck] = c
+	}
+	return nil
+}
+
+// findTable looks the table up in the schema, or in the default schema when none is given
+func (my *DBInfo) findTable(schema, name string) (*DBTable, bool) {
+	if schema == "" {
+		schema = my.Schema
+	}
+	return my.GetTable(schema, name)
+}
+
+// findColumn resolves the reference `table.column` or `schema.table.column`
+func (my *DBInfo) findColumn(ref, schema string) (*DBTable, DBColumn, error) {
+	parts := strings.Split(ref, ".")
+	switch len(parts) {
+	case 2:
+		parts = append([]string{schema}, parts...)
+	case 3:
+	default:
+		return nil, DBColumn{}, fmt.Errorf("invalid reference '%s'", ref)
+	}
+	t, ok := my.GetTable(parts[0], parts[1])
+	if !ok {
+		return nil, DBColumn{}, fmt.Errorf("unknown table '%s' in '%s'", parts[1], ref)
+	}
+	c, ok := t.GetColumn(parts[2])
+	if !ok {
+		return nil, DBColumn{}, fmt.Errorf("unknown column '%s' in '%s'", parts[2], ref)
+	}
+	return t, c, nil
+}
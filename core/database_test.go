@@ -0,0 +1,98 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func newConfigInfo(t *testing.T, conf *Config) *DBInfo {
+	t.Helper()
+	di := newTestInfo()
+	addTestTable(di, "categories",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "name", Type: "text"},
+	)
+	addTestTable(di, "products",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "category_ids", Type: "bigint[]", Array: true},
+	)
+	addTestTable(di, "active_users",
+		DBColumn{Name: "id", Type: "bigint"},
+		DBColumn{Name: "user_id", Type: "bigint"},
+	)
+	if err := di.applyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	return di
+}
+
+func TestApplyConfig(t *testing.T) {
+	conf := &Config{Tables: []TableConfig{
+		{Name: "me", Table: "users"},
+		{Name: "products", Columns: []Column{{Name: "category_ids", ForeignKey: "categories.id"}}},
+		{Name: "active_users", Columns: []Column{{Name: "id", Primary: true}, {Name: "user_id", ForeignKey: "users.id"}}},
+		{Name: "missing", Columns: []Column{{Name: "id", Primary: true}}},
+	}}
+	di := newConfigInfo(t, conf)
+
+	me, ok := di.GetTable("public", "me")
+	if !ok || me.Source != "users" || me.PrimaryCol.Name != "id" || len(me.Columns) != 5 {
+		t.Fatalf("unexpected alias %+v", me)
+	}
+	view, _ := di.GetTable("public", "active_users")
+	if view.PrimaryCol.Name != "id" {
+		t.Errorf("expected the primary key of the view, got %+v", view.PrimaryCol)
+	}
+	if c, _ := view.GetColumn("user_id"); c.FKeyTable != "users" || c.FKeyCol != "id" {
+		t.Errorf("expected the foreign key, got %s", c)
+	}
+
+	c, err := newCompiler(conf, di)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for gql, expected := range map[string][]string{
+		`{ me { posts { id } } }`: {
+			`FROM "public"."users" AS "me_0"`,
+			`WHERE "posts_1"."user_id" = "me_0"."id"`,
+		},
+		`{ products { categories { id } } }`: {
+			`coalesce(json_agg("__sj_1"."json"), '[]')`,
+			`WHERE "categories_1"."id" = ANY("products_0"."category_ids")`,
+		},
+		`{ categories { products { id } } }`: {
+			`WHERE "categories_0"."id" = ANY("products_1"."category_ids")`,
+		},
+		`{ active_users(id: 1) { users { email } } }`: {
+			`WHERE ("active_users_0"."id" = $1) LIMIT 1`,
+			`WHERE "users_1"."id" = "active_users_0"."user_id"`,
+		},
+	} {
+		st, err := compileSession(t, c, gql, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", gql, err)
+		}
+		for _, v := range expected {
+			if !strings.Contains(st.sql, v) {
+				t.Errorf("expected %s in:\n%s", v, st.sql)
+			}
+		}
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	for _, tc := range []TableConfig{
+		{Name: "products", Columns: []Column{{Name: "unknown"}}},
+		{Name: "products", Columns: []Column{{Name: "category_ids", ForeignKey: "categories"}}},
+		{Name: "products", Columns: []Column{{Name: "category_ids", ForeignKey: "tags.id"}}},
+		{Name: "products", Columns: []Column{{Name: "category_ids", ForeignKey: "categories.uid"}}},
+		{Name: "posts", Columns: []Column{{Name: "title", Primary: true}}},
+	} {
+		di := newTestInfo()
+		addTestTable(di, "categories", DBColumn{Name: "id", Type: "bigint", PrimaryKey: true})
+		addTestTable(di, "products", DBColumn{Name: "category_ids", Type: "bigint[]", Array: true})
+		if err := di.applyConfig(&Config{Tables: []TableConfig{tc}}); err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+}
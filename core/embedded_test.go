@@ -0,0 +1,93 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// newEmbeddedInfo returns the config of a json table and the database info it applies to
+func newEmbeddedInfo() (*Config, *DBInfo) {
+	conf := &Config{Tables: []TableConfig{{
+		Name:  "category_counts",
+		Table: "users",
+		Type:  tableJSONB,
+		Columns: []Column{
+			{Name: "category_id", Type: "bigint", ForeignKey: "categories.id"},
+			{Name: "count", Type: "integer"},
+		},
+	}}}
+	di := newTestInfo()
+	users, _ := di.GetTable("public", "users")
+	users.Columns["public:users:category_counts"] = DBColumn{Name: "category_counts", Type: "jsonb", Schema: "public", Table: "users"}
+	addTestTable(di, "categories",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "name", Type: "text"},
+	)
+	return conf, di
+}
+
+func TestCompileEmbedded(t *testing.T) {
+	conf, di := newEmbeddedInfo()
+	c := newTestCompiler(t, conf, di)
+	st, err := compileSession(t, c, `{
+		users {
+			category_counts(where: {count: {greaterThan: 1}}, sort: {count: desc}) {
+				count
+				categories { name }
+			}
+		}
+	}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`SELECT "category_counts_1".* FROM jsonb_to_recordset("users_0"."category_counts") ` +
+			`AS "category_counts_1"("category_id" bigint, "count" integer) ` +
+			`WHERE ("category_counts_1"."count" > $1) ORDER BY "category_counts_1"."count" DESC LIMIT 20`,
+		`WHERE "categories_2"."id" = "category_counts_1"."category_id" LIMIT 1`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+
+	s := c.schema("")
+	var found bool
+	for _, f := range s.Types["users"].Fields {
+		found = found || (f.Name == "category_counts" && f.Type.OfType != nil && f.Type.OfType.Name == "category_counts")
+	}
+	if !found {
+		t.Error("expected the json table on users")
+	}
+	if _, ok := s.Types["category_counts"+SUFFIX_WHERE]; !ok {
+		t.Error("expected the where input of the json table")
+	}
+	for _, f := range s.Types["Query"].Fields {
+		if f.Name == "category_counts" {
+			t.Error("expected no root field for the json table")
+		}
+	}
+}
+
+func TestCompileEmbeddedErrors(t *testing.T) {
+	conf, di := newEmbeddedInfo()
+	c := newTestCompiler(t, conf, di)
+	for _, gql := range []string{
+		`{ category_counts { count } }`,
+		`{ categories { category_counts { count } } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+
+	for _, tc := range []TableConfig{
+		{Name: "email", Table: "users", Type: tableJSON, Columns: []Column{{Name: "a", Type: "text"}}},
+		{Name: "meta", Table: "posts", Type: tableJSONB},
+		{Name: "meta", Table: "posts", Type: tableJSONB, Columns: []Column{{Name: "a"}}},
+	} {
+		if err := newTestInfo().applyConfig(&Config{Tables: []TableConfig{tc}}); err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+}
@@ -2,31 +2,47 @@ package core
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/spf13/afero"
 	_log "log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync/atomic"
 )
 
 type kernel struct {
-	dialect string
-	conf    *Config
-	done    chan bool
-	db      *sql.DB
-	di      *DBInfo
-	fs      FS
-	opts    []Option
-	log     *_log.Logger
+	dialect  string
+	conf     *Config
+	done     chan bool
+	db       *sql.DB
+	di       *DBInfo
+	fs       FS
+	opts     []Option
+	log      *_log.Logger
+	compiler *compiler
+	listener Listener
+	handler  http.Handler
+	// factories are the resolver types registered with WithResolver
+	factories map[string]ResolverFactory
 }
 
 type Engine struct {
 	atomic.Value
 	done chan bool
+	subs subscriptions
 }
 
 type Option func(*kernel) error
 
+// WithListener receives the table changes that re-run subscriptions in notify mode
+func WithListener(l Listener) Option {
+	return func(ke *kernel) error {
+		ke.listener = l
+		return nil
+	}
+}
+
 func NewEngine(conf *Config, db *sql.DB, options ...Option) (e *Engine, err error) {
 	fs, err := getFS(conf)
 	if err != nil {
@@ -52,12 +68,14 @@ func (my *Engine) newKernel(
 	}
 
 	ke := &kernel{
-		conf: conf,
-		db:   db,
-		di:   di,
-		fs:   fs,
-		done: my.done,
-		log:  _log.New(os.Stdout, "", 0),
+		dialect: "postgres",
+		conf:    conf,
+		db:      db,
+		di:      di,
+		fs:      fs,
+		opts:    options,
+		done:    my.done,
+		log:     _log.New(os.Stdout, "", 0),
 	}
 	for _, op := range options {
 		if err = op(ke); err != nil {
@@ -65,6 +83,36 @@ func (my *Engine) newKernel(
 		}
 	}
 
+	switch conf.SubsMode {
+	case "", subsPoll:
+	case subsNotify:
+		if ke.listener == nil {
+			return fmt.Errorf("subs_mode '%s' requires a listener", conf.SubsMode)
+		}
+	default:
+		return fmt.Errorf("unknown subs_mode '%s'", conf.SubsMode)
+	}
+
+	if ke.di == nil {
+		if ke.di, err = GetDBInfo(ke.db, ke.dialect, ke.conf.Blocklist); err != nil {
+			return
+		}
+	}
+	if err = ke.di.applyConfig(ke.conf); err != nil {
+		return
+	}
+	if ke.compiler, err = newCompiler(ke.conf, ke.di); err != nil {
+		return
+	}
+	if ke.compiler.resolvers, err = newResolvers(ke.conf, ke.di, ke.factories); err != nil {
+		return
+	}
+	auth, err := NewAuth(ke.conf)
+	if err != nil {
+		return
+	}
+	ke.handler = auth(http.HandlerFunc(my.serveHTTP))
+
 	my.Store(ke)
 	return
 }
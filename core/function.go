@@ -0,0 +1,171 @@
+package core
+
+import (
+	"fmt"
+)
+
+// isRowFunction reports whether the function is a field of the table, it takes the row of the table
+// as its only input and returns a single value: `full_name(users) RETURNS text` is the field `full_name` of `users`
+func (my *DBInfo) isRowFunction(fn *DBFunction, t *DBTable) bool {
+	if fn.Set || t.embedded() || len(fn.Inputs) != 1 || fn.Inputs[0].Type != t.sourceName() || fn.Schema != t.Schema {
+		return false
+	}
+	_, ok := my.GetTable(fn.Schema, fn.Type)
+	return !ok
+}
+
+// setTable returns the table of the rows returned by the function, the inputs must be named to be passed as args
+func (my *DBInfo) setTable(fn *DBFunction) (*DBTable, bool) {
+	if !fn.Set {
+		return nil, false
+	}
+	t, ok := my.GetTable(fn.Schema, fn.Type)
+	if !ok || t.Blocked || t.embedded() {
+		return nil, false
+	}
+	for _, p := range fn.Inputs {
+		if p.Name == "" {
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+func (my *compiler) rowFunction(t *DBTable, name string) (*DBFunction, bool) {
+	for _, fn := range my.info.Functions {
+		if my.conf.getName(fn.Name, true) == name && my.info.isRowFunction(fn, t) {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// rowColumns returns the columns of the table the role can read, the other columns are null in the row
+// that is passed to a row function
+func (my *builder) rowColumns(t *DBTable) []DBColumn {
+	r := my.rule(t, ruleQuery)
+	var list []DBColumn
+	for _, c := range t.SortedColumns() {
+		if !c.Blocked && r.allows(c) {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// function returns the set returning function of the root field unless the role may not use it
+func (my *builder) function(name string) (*DBFunction, *DBTable, bool) {
+	for _, fn := range my.info.Functions {
+		if my.conf.getName(fn.Name, true) != name {
+			continue
+		}
+		t, ok := my.info.setTable(fn)
+		if !ok {
+			continue
+		}
+		if r := my.rule(t, ruleQuery); r.block || r.noFuncs {
+			return nil, nil, false
+		}
+		return fn, t, true
+	}
+	return nil, nil, false
+}
+
+// parseFuncArgs reads the `args` argument of a set returning function
+func (my *builder) parseFuncArgs(s *selection, v interface{}) error {
+	if s.fn == nil {
+		return fmt.Errorf("unknown argument 'args' on field '%s'", s.name)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("argument 'args': expected an object")
+	}
+	s.fnArgs = make(map[string]interface{}, len(m))
+	for k, val := range m {
+		var found bool
+		for _, p := range s.fn.Inputs {
+			if my.conf.getName(p.Name, true) == k {
+				s.fnArgs[p.Name], found = val, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("argument 'args': unknown input '%s' of function '%s'", k, s.fn.Name)
+		}
+	}
+	return nil
+}
+
+// renderFunction calls the set returning function with named arguments so that missing ones take their defaults:
+// "public"."get_users"("n" => $1) AS "users_0"
+func (my *renderer) renderFunction(s *selection) error {
+	my.quote(s.fn.Schema)
+	my.WriteString(`.`)
+	my.quote(s.fn.Name)
+	my.WriteString(`(`)
+	i := 0
+	for _, p := range s.fn.Inputs {
+		v, ok := s.fnArgs[p.Name]
+		if !ok {
+			continue
+		}
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		i++
+		my.quote(p.Name)
+		my.WriteString(` => `)
+		if err := my.bindValue(DBColumn{Name: p.Name, Type: p.Type}, v); err != nil {
+			return err
+		}
+	}
+	my.WriteString(`) AS `)
+	my.quote(tableAlias(s))
+	return nil
+}
+
+// renderRowFunctions selects the row functions of the fields next to the row in the base query.
+// The row is built from the readable columns by name, so the rows of a mutation or a recursive cte work too:
+// "public"."full_name"(jsonb_populate_record(NULL::"public"."users", jsonb_build_object('id', "users_0"."id", ...))) AS "__fn_full_name"
+func (my *renderer) renderRowFunctions(s *selection) {
+	for _, f := range s.fields {
+		if f.kind != kindFunc {
+			continue
+		}
+		my.WriteString(`, `)
+		my.quote(f.fn.Schema)
+		my.WriteString(`.`)
+		my.quote(f.fn.Name)
+		my.WriteString(`(jsonb_populate_record(NULL::`)
+		my.quote(s.table.Schema)
+		my.WriteString(`.`)
+		my.quote(s.table.sourceName())
+		my.WriteString(`, `)
+		if len(f.row) == 0 {
+			my.WriteString(`'{}'::jsonb`)
+		}
+		// jsonb_build_object takes at most 100 arguments, wider rows are concatenated
+		for i, c := range f.row {
+			switch {
+			case i == 0:
+				my.WriteString(`jsonb_build_object(`)
+			case i%50 == 0:
+				my.WriteString(`) || jsonb_build_object(`)
+			default:
+				my.WriteString(`, `)
+			}
+			my.literal(c.Name)
+			my.WriteString(`, `)
+			my.column(s, c)
+		}
+		if len(f.row) != 0 {
+			my.WriteString(`)`)
+		}
+		my.WriteString(`)) AS `)
+		my.quote(funcAlias(f))
+	}
+}
+
+func funcAlias(f *field) string {
+	return "__fn_" + f.name
+}
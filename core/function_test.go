@@ -0,0 +1,110 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func newFunctionInfo() *DBInfo {
+	di := newTestInfo()
+	di.Functions = []*DBFunction{
+		{ID: "display_name_1", Schema: "public", Name: "display_name", Type: "text",
+			Inputs: []DBFuncParam{{ID: 1, Name: "u", Type: "users"}}},
+		{ID: "search_posts_2", Schema: "public", Name: "search_posts", Type: "posts", Set: true,
+			Inputs: []DBFuncParam{{ID: 1, Name: "query", Type: "text"}, {ID: 2, Name: "lim", Type: "integer"}}},
+		{ID: "unnamed_3", Schema: "public", Name: "unnamed", Type: "posts", Set: true,
+			Inputs: []DBFuncParam{{ID: 1, Type: "text"}}},
+	}
+	return di
+}
+
+func TestCompileFunctions(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newFunctionInfo())
+	st, err := compileSession(t, c, `{
+		search_posts(args: {query: "go"}, where: {id: {greaterThan: 1}}) {
+			title
+			users { display_name }
+		}
+	}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`SELECT "posts_0".* FROM "public"."search_posts"("query" => $1) AS "posts_0" WHERE ("posts_0"."id" > $2)`,
+		`'display_name', "users_1"."__fn_display_name"`,
+		`SELECT "users_1".*, "public"."display_name"(jsonb_populate_record(NULL::"public"."users", jsonb_build_object(` +
+			`'email', "users_1"."email", 'full_name', "users_1"."full_name", 'id', "users_1"."id", 'tags', "users_1"."tags"))) ` +
+			`AS "__fn_display_name" FROM "public"."users" AS "users_1"`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+	if len(st.args) != 2 || st.args[0] != "go" {
+		t.Errorf("unexpected args %v", st.args)
+	}
+
+	s := c.schema("")
+	fields := make(map[string]__Field)
+	for _, f := range s.Types["Query"].Fields {
+		fields[f.Name] = f
+	}
+	if f, ok := fields["search_posts"]; !ok || f.Args[len(f.Args)-1].Type.Name != "search_posts"+SUFFIX_ARGS {
+		t.Errorf("expected the root function with its args, got %+v", f)
+	}
+	if _, ok := fields["unnamed"]; ok {
+		t.Error("expected no root field for a function with unnamed inputs")
+	}
+	if args := s.Types["search_posts"+SUFFIX_ARGS]; len(args.InputFields) != 2 || args.InputFields[1].Type.Name != Int {
+		t.Errorf("unexpected args input %+v", args.InputFields)
+	}
+	var found bool
+	for _, f := range s.Types["users"].Fields {
+		found = found || (f.Name == "display_name" && f.Type.Name == String)
+	}
+	if !found {
+		t.Error("expected the row function on users")
+	}
+}
+
+func TestCompileRowFunctions(t *testing.T) {
+	// the row of the inserted user is read from the mutation, it only holds the columns the role can read
+	conf := &Config{Roles: []RoleConfig{{Name: "user", Tables: []RoleTable{
+		{Name: "users", Query: &QueryConfig{Columns: []string{"id", "email"}}},
+	}}}}
+	c := newTestCompiler(t, conf, newFunctionInfo())
+	st, err := compileSession(t, c, `mutation {
+		posts(insert: {title: "a", users: {email: "a@x.io"}}) { id users { display_name } }
+	}`, nil, &session{role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := `SELECT "users_2".*, "public"."display_name"(jsonb_populate_record(NULL::"public"."users", ` +
+		`jsonb_build_object('email', "users_2"."email", 'id', "users_2"."id"))) AS "__fn_display_name" ` +
+		`FROM (SELECT * FROM "public"."users" WHERE "id" NOT IN (SELECT "id" FROM "__mu_1") UNION ALL SELECT * FROM "__mu_1") AS "users_2"`
+	if !strings.Contains(st.sql, v) {
+		t.Errorf("expected %s in:\n%s", v, st.sql)
+	}
+}
+
+func TestCompileFunctionErrors(t *testing.T) {
+	disabled := &Config{Tables: []TableConfig{
+		{Name: "users", Query: &QueryConfig{DisableFunctions: true}},
+		{Name: "posts", Query: &QueryConfig{DisableFunctions: true}},
+	}}
+	for _, v := range []struct {
+		conf *Config
+		gql  string
+	}{
+		{&Config{}, `{ search_posts(args: {unknown: 1}) { id } }`},
+		{&Config{}, `{ posts(args: {query: "x"}) { id } }`},
+		{&Config{}, `{ unnamed { id } }`},
+		{&Config{}, `mutation { users(insert: {email: "a"}) { display_name } }`},
+		{disabled, `{ users { display_name } }`},
+		{disabled, `{ search_posts { id } }`},
+	} {
+		if _, err := compileSession(t, newTestCompiler(t, v.conf, newFunctionInfo()), v.gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", v.gql)
+		}
+	}
+}
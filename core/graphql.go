@@ -0,0 +1,221 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ichaly/tiny-go/core/ast"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+	"github.com/ichaly/tiny-go/core/parser"
+)
+
+var errReadOnly = errors.New("only query operations are allowed with GET requests")
+
+// Result is the response described in https://spec.graphql.org/draft/#sec-Response-Format
+type Result struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors _lexer.List     `json:"errors,omitempty"`
+}
+
+// GraphQL parses, compiles and executes a single operation of the query document.
+// Every failure is reported in the errors of the result, the returned error is the same list.
+func (my *Engine) GraphQL(
+	ctx context.Context, query string, vars json.RawMessage, opName string,
+) (*Result, error) {
+	return my.execute(ctx, query, vars, opName, false)
+}
+
+// execute runs the operation, read only requests refuse anything but queries
+func (my *Engine) execute(
+	ctx context.Context, query string, vars json.RawMessage, opName string, readOnly bool,
+) (*Result, error) {
+	ke := my.Load().(*kernel)
+	res := &Result{}
+
+	doc, op, err := parseOperation(query, opName)
+	if err != nil {
+		return res.fail(err)
+	}
+
+	if readOnly && op.OperationType != ast.Query {
+		return res.fail(errReadOnly)
+	}
+
+	values, err := bindVariables(op, vars)
+	if err != nil {
+		return res.fail(err)
+	}
+
+	sess, err := ke.session(ctx)
+	if err != nil {
+		return res.fail(err)
+	}
+
+	q, err := ke.compiler.compile(doc, op, values, sess)
+	if err != nil {
+		return res.fail(err)
+	}
+
+	if data, ok := q.static(); ok {
+		res.Data = data
+		return res, nil
+	}
+
+	st, err := ke.compiler.render(q)
+	if err != nil {
+		return res.fail(err)
+	}
+
+	if ke.conf.Debug {
+		ke.log.Println(st.sql)
+	}
+
+	var data []byte
+	if q.kind == ast.Mutation {
+		data, err = ke.mutate(ctx, st)
+	} else {
+		err = ke.db.QueryRowContext(ctx, st.sql, st.args...).Scan(&data)
+	}
+	if err != nil {
+		res.Data = json.RawMessage(`null`)
+		return res.fail(err)
+	}
+	if q.cursor || q.resolve {
+		if data, res.Errors, err = ke.compiler.finishResponse(ctx, q, data); err != nil {
+			res.Data = json.RawMessage(`null`)
+			return res.fail(err)
+		}
+	}
+	res.Data = data
+	// the fields of failed resolver calls are null and reported next to the data
+	if len(res.Errors) != 0 {
+		return res, res.Errors
+	}
+	return res, nil
+}
+
+// mutate runs the statement of a mutation in its own transaction, nothing is kept when any part fails
+func (my *kernel) mutate(ctx context.Context, st *statement) ([]byte, error) {
+	tx, err := my.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err = tx.QueryRowContext(ctx, st.sql, st.args...).Scan(&data); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (my *Result) fail(err error) (*Result, error) {
+	switch e := err.(type) {
+	case *_lexer.Error:
+		my.Errors = append(my.Errors, e)
+	case _lexer.List:
+		my.Errors = append(my.Errors, e...)
+	default:
+		my.Errors = append(my.Errors, _lexer.WrapError(err))
+	}
+	return my, my.Errors
+}
+
+func parseOperation(query, opName string) (*ast.QueryDocument, *ast.OperationDefinition, error) {
+	doc, err := parser.ParseQuery(&_lexer.Input{Content: query})
+	if err != nil {
+		return nil, nil, err
+	}
+	op, err := getOperation(doc, opName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, op, nil
+}
+
+func getOperation(doc *ast.QueryDocument, name string) (*ast.OperationDefinition, error) {
+	if name == "" {
+		if len(doc.Operations) == 1 {
+			return doc.Operations[0], nil
+		}
+		return nil, fmt.Errorf("must provide operation name if query contains %d operations", len(doc.Operations))
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation named '%s'", name)
+}
+
+// bindVariables coerces the request variables against the variable definitions of the operation,
+// see https://spec.graphql.org/draft/#sec-Coercing-Variable-Values
+func bindVariables(op *ast.OperationDefinition, raw json.RawMessage) (map[string]interface{}, error) {
+	input := make(map[string]interface{})
+	if len(bytes.TrimSpace(raw)) != 0 && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&input); err != nil {
+			return nil, fmt.Errorf("variables must be a json object: %w", err)
+		}
+	}
+
+	res := make(map[string]interface{}, len(op.VariablesDefinitions))
+	for _, def := range op.VariablesDefinitions {
+		val, ok := input[def.Variable]
+		if ok {
+			if err := checkVariable(def, val); err != nil {
+				return nil, err
+			}
+		} else if def.DefaultValue != nil {
+			v, err := valueOf(def.DefaultValue, nil)
+			if err != nil {
+				return nil, err
+			}
+			val, ok = v, true
+		}
+		if val == nil && def.Type.NonNull {
+			if !ok {
+				return nil, fmt.Errorf("variable '$%s' of required type '%s' was not provided", def.Variable, def.Type)
+			}
+			return nil, fmt.Errorf("variable '$%s' of non-null type '%s' must not be null", def.Variable, def.Type)
+		}
+		res[def.Variable] = val
+	}
+	return res, nil
+}
+
+// checkVariable validates the builtin scalars, input objects are checked when they are compiled
+func checkVariable(def *ast.VariableDefinition, val interface{}) error {
+	if val == nil || def.Type.Elem != nil {
+		return nil
+	}
+	var ok bool
+	switch def.Type.Name {
+	case Int:
+		n, isNum := val.(json.Number)
+		_, err := n.Int64()
+		ok = isNum && err == nil
+	case Float:
+		_, ok = val.(json.Number)
+	case Boolean:
+		_, ok = val.(bool)
+	case String:
+		_, ok = val.(string)
+	case ID:
+		switch val.(type) {
+		case string, json.Number:
+			ok = true
+		}
+	default:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("variable '$%s' got invalid value '%v' for type '%s'", def.Variable, val, def.Type)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver that records the executed statements and
+// answers each of them with the rows returned by reply
+type fakeDB struct {
+	sync.Mutex
+	queries   []string
+	args      [][]driver.NamedValue
+	commits   int
+	rollbacks int
+	reply     func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+}
+
+var (
+	fakeLock sync.Mutex
+	fakeDBs  = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func openFakeDB(t *testing.T, reply func(string, []driver.NamedValue) ([]string, [][]driver.Value, error)) (*sql.DB, *fakeDB) {
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	f := &fakeDB{reply: reply}
+	fakeDBs[t.Name()] = f
+	db, err := sql.Open("fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, f
+}
+
+// jsonReply answers every statement with a single json row
+func jsonReply(data string) func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	return func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"__root"}, [][]driver.Value{{[]byte(data)}}, nil
+	}
+}
+
+func (my *fakeDB) last() (string, []driver.NamedValue) {
+	my.Lock()
+	defer my.Unlock()
+	if len(my.queries) == 0 {
+		return "", nil
+	}
+	return my.queries[len(my.queries)-1], my.args[len(my.args)-1]
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %s", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (my *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (my *fakeConn) Close() error { return nil }
+
+func (my *fakeConn) Begin() (driver.Tx, error) { return my, nil }
+
+func (my *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return my, nil }
+
+func (my *fakeConn) Commit() error {
+	my.db.Lock()
+	defer my.db.Unlock()
+	my.db.commits++
+	return nil
+}
+
+func (my *fakeConn) Rollback() error {
+	my.db.Lock()
+	defer my.db.Unlock()
+	my.db.rollbacks++
+	return nil
+}
+
+func (my *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	my.db.Lock()
+	my.db.queries = append(my.db.queries, query)
+	my.db.args = append(my.db.args, args)
+	reply := my.db.reply
+	my.db.Unlock()
+
+	cols, rows, err := reply(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+func (my *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := my.QueryContext(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (my *fakeRows) Columns() []string { return my.cols }
+
+func (my *fakeRows) Close() error { return nil }
+
+func (my *fakeRows) Next(dest []driver.Value) error {
+	if len(my.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, my.rows[0])
+	my.rows = my.rows[1:]
+	return nil
+}
+
+func newTestEngine(t *testing.T, conf *Config, db *sql.DB) *Engine {
+	t.Helper()
+	e := &Engine{done: make(chan bool)}
+	if err := e.newKernel(conf, db, newTestInfo(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestGraphQL(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{"users":[{"id":1}]}`))
+	e := newTestEngine(t, &Config{}, db)
+
+	gql := `
+	query a($limit: Int = 3, $email: String!) { users(limit: $limit, where: {email: {equals: $email}}) { id } }
+	query b { posts { id } }
+	`
+	res, err := e.GraphQL(context.Background(), gql, json.RawMessage(`{"email":"a@b.c"}`), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `{"users":[{"id":1}]}` {
+		t.Errorf("unexpected data %s", res.Data)
+	}
+	query, args := f.last()
+	if !strings.Contains(query, "LIMIT 3") {
+		t.Errorf("expected the default limit variable in %s", query)
+	}
+	if len(args) != 1 || args[0].Value != "a@b.c" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	db, _ := openFakeDB(t, func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, errors.New("connection refused")
+	})
+	e := newTestEngine(t, &Config{}, db)
+
+	for _, v := range []struct {
+		query, vars, op, message string
+	}{
+		{`{ users { id }`, ``, ``, `Expected Name, found <EOF>`},
+		{`query a { users { id } } query b { posts { id } }`, ``, ``, `must provide operation name`},
+		{`query a { users { id } }`, ``, `c`, `unknown operation named 'c'`},
+		{`query ($id: ID!) { users(id: $id) { id } }`, `{}`, ``, `was not provided`},
+		{`query ($n: Int) { users(limit: $n) { id } }`, `{"n":"x"}`, ``, `invalid value`},
+		{`query { users(limit: $n) { id } }`, ``, ``, `variable '$n' is not defined`},
+		{`{ users { id } }`, ``, ``, `connection refused`},
+	} {
+		res, err := e.GraphQL(context.Background(), v.query, json.RawMessage(v.vars), v.op)
+		if err == nil || len(res.Errors) != 1 {
+			t.Errorf("expected an error for %s", v.query)
+			continue
+		}
+		if !strings.Contains(res.Errors[0].Message, v.message) {
+			t.Errorf("expected %q in %q", v.message, res.Errors[0].Message)
+		}
+		data, _ := json.Marshal(res)
+		if !strings.HasPrefix(string(data), `{"errors":[{"message":`) && !strings.HasPrefix(string(data), `{"data":null,"errors":[`) {
+			t.Errorf("unexpected response %s", data)
+		}
+	}
+}
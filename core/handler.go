@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Transport described in:
+// https://graphql.github.io/graphql-over-http/draft/
+//
+
+const (
+	mimeJSON            = "application/json"
+	mimeGraphQL         = "application/graphql"
+	mimeGraphQLResponse = "application/graphql-response+json"
+
+	maxBodySize = 10 << 20
+)
+
+type httpRequest struct {
+	Query         string          `json:"query"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+	OperationName string          `json:"operationName,omitempty"`
+	Extensions    json.RawMessage `json:"extensions,omitempty"`
+}
+
+// Handler serves the engine over http, it accepts GET query parameters,
+// POST json bodies, POST application/graphql bodies and graphql-transport-ws websockets.
+// Requests are authenticated with the auth config first.
+func (my *Engine) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		my.Load().(*kernel).handler.ServeHTTP(w, r)
+	})
+}
+
+func (my *Engine) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		my.serveWS(w, r)
+		return
+	}
+
+	accept, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, "Not Acceptable: supported types are "+mimeGraphQLResponse+" and "+mimeJSON, http.StatusNotAcceptable)
+		return
+	}
+
+	req, status, err := parseRequest(w, r)
+	if err != nil {
+		if status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", "GET, POST")
+		}
+		writeResult(w, accept, status, &Result{Errors: _lexer.List{_lexer.WrapError(err)}})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), HeadersKey, r.Header)
+	res, err := my.execute(ctx, req.Query, req.Variables, req.OperationName, r.Method == http.MethodGet)
+	if errors.Is(err, errReadOnly) {
+		w.Header().Set("Allow", "POST")
+		writeResult(w, accept, http.StatusMethodNotAllowed, res)
+		return
+	}
+
+	status = http.StatusOK
+	// without data the request failed before execution started
+	if accept == mimeGraphQLResponse && res.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeResult(w, accept, status, res)
+}
+
+func parseRequest(w http.ResponseWriter, r *http.Request) (*httpRequest, int, error) {
+	req := &httpRequest{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			req.Variables = json.RawMessage(v)
+		}
+		if v := q.Get("extensions"); v != "" {
+			req.Extensions = json.RawMessage(v)
+		}
+	case http.MethodPost:
+		mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, http.StatusUnsupportedMediaType, errors.New("missing or invalid content type")
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", mbe.Limit)
+			}
+			return nil, http.StatusBadRequest, err
+		}
+		switch mt {
+		case mimeJSON:
+			if err = json.Unmarshal(body, req); err != nil {
+				return nil, http.StatusBadRequest, errors.New("request body must be a json object: " + err.Error())
+			}
+		case mimeGraphQL:
+			q := r.URL.Query()
+			req.Query = string(body)
+			req.OperationName = q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				req.Variables = json.RawMessage(v)
+			}
+		default:
+			return nil, http.StatusUnsupportedMediaType, errors.New("unsupported content type " + mt)
+		}
+	default:
+		return nil, http.StatusMethodNotAllowed, errors.New("method " + r.Method + " is not allowed")
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, http.StatusBadRequest, errors.New("request must contain a query")
+	}
+	if len(req.Variables) != 0 && !json.Valid(req.Variables) {
+		return nil, http.StatusBadRequest, errors.New("variables must be a json object")
+	}
+	return req, http.StatusOK, nil
+}
+
+// negotiate picks the response type from the accept header, a missing header means application/json
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return mimeJSON, true
+	}
+
+	type candidate struct {
+		mime string
+		q    float64
+	}
+	var list []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		switch mt {
+		case mimeGraphQLResponse, "application/*", "*/*":
+			list = append(list, candidate{mimeGraphQLResponse, q})
+		case mimeJSON:
+			list = append(list, candidate{mimeJSON, q})
+		}
+	}
+	if len(list) == 0 {
+		return "", false
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].q > list[j].q
+	})
+	return list[0].mime, true
+}
+
+func writeResult(w http.ResponseWriter, accept string, status int, res *Result) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", accept+"; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
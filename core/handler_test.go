@@ -0,0 +1,64 @@
+package core
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{"users":[]}`))
+	h := newTestEngine(t, &Config{}, db).Handler()
+
+	get := "/graphql?" + url.Values{
+		"query":     {`query ($n: Int) { users(limit: $n) { id } }`},
+		"variables": {`{"n": 2}`},
+	}.Encode()
+
+	for _, v := range []struct {
+		name, method, target, ctype, accept, body string
+		status                                    int
+		mime, contains                            string
+	}{
+		{"get", "GET", get, "", "", "", 200, mimeJSON, `{"data":{"users":[]}}`},
+		{"post json", "POST", "/graphql", mimeJSON, mimeGraphQLResponse, `{"query":"{ users { id } }"}`, 200, mimeGraphQLResponse, `{"data":{"users":[]}}`},
+		{"post graphql", "POST", "/graphql?operationName=a", mimeGraphQL + "; charset=utf-8", "", `query a { users { id } }`, 200, mimeJSON, `"users"`},
+		{"get mutation", "GET", "/graphql?query=mutation+%7B+users+%7B+id+%7D+%7D", "", "", "", 405, mimeJSON, `only query operations`},
+		{"method", "PUT", "/graphql", mimeJSON, "", `{}`, 405, mimeJSON, `not allowed`},
+		{"media type", "POST", "/graphql", "text/plain", "", `{ users { id } }`, 415, mimeJSON, `unsupported content type`},
+		{"bad json", "POST", "/graphql", mimeJSON, "", `{"query":`, 400, mimeJSON, `json object`},
+		{"too large", "POST", "/graphql", mimeJSON, "", `{"query":"` + strings.Repeat(" ", maxBodySize) + `"}`, 413, mimeJSON, `request body is larger`},
+		{"no query", "POST", "/graphql", mimeJSON, "", `{}`, 400, mimeJSON, `must contain a query`},
+		{"invalid legacy", "POST", "/graphql", mimeJSON, mimeJSON, `{"query":"{ nope }"}`, 200, mimeJSON, `cannot query field`},
+		{"invalid", "POST", "/graphql", mimeJSON, "*/*", `{"query":"{ nope }"}`, 400, mimeGraphQLResponse, `cannot query field`},
+		{"not acceptable", "POST", "/graphql", mimeJSON, "text/html", `{"query":"{ users { id } }"}`, 406, "text/plain", `Not Acceptable`},
+		{"quality", "POST", "/graphql", mimeJSON, mimeGraphQLResponse + ";q=0.5, " + mimeJSON, `{"query":"{ users { id } }"}`, 200, mimeJSON, `"users"`},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			r := httptest.NewRequest(v.method, v.target, strings.NewReader(v.body))
+			if v.ctype != "" {
+				r.Header.Set("Content-Type", v.ctype)
+			}
+			if v.accept != "" {
+				r.Header.Set("Accept", v.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != v.status {
+				t.Errorf("expected status %d but got %d: %s", v.status, w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, v.mime) {
+				t.Errorf("expected content type %s but got %s", v.mime, ct)
+			}
+			if !strings.Contains(w.Body.String(), v.contains) {
+				t.Errorf("expected %s in %s", v.contains, w.Body)
+			}
+		})
+	}
+
+	if query, args := f.last(); !strings.Contains(query, "LIMIT") || len(args) != 0 {
+		t.Errorf("unexpected statement %s %v", query, args)
+	}
+}
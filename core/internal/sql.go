@@ -10,3 +10,6 @@ var PostgresColumns string
 
 //go:embed sql/postgres_functions.sql
 var PostgresFunctions string
+
+//go:embed sql/postgres_notify.sql
+var PostgresNotify string
@@ -0,0 +1,8 @@
+CREATE SCHEMA IF NOT EXISTS "_gj_";
+
+CREATE OR REPLACE FUNCTION "_gj_"."notify"() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('_gj_changes', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
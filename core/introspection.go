@@ -0,0 +1,334 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ichaly/tiny-go/core/ast"
+	"sort"
+)
+
+//
+// Execution of the meta fields described in:
+// https://spec.graphql.org/draft/#sec-Schema-Introspection
+//
+
+type resolveFunc func(f *ast.Field) (interface{}, error)
+
+// introspect resolves the root `__schema` and `__type` fields against the generated schema
+func (my *builder) introspect(f *ast.Field) (json.RawMessage, error) {
+	switch f.Name {
+	case "__schema":
+		return my.schemaObject(f.SelectionSet, my.schema(my.session.role))
+	case "__type":
+		var name string
+		for _, a := range f.Arguments {
+			if a.Name != "name" {
+				continue
+			}
+			v, err := my.valueOf(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			name, _ = v.(string)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("argument 'name' of '__type' is required")
+		}
+		t, ok := my.schema(my.session.role).Types[name]
+		if !ok {
+			return json.RawMessage(`null`), nil
+		}
+		return my.typeObject(f.SelectionSet, &t)
+	}
+	return nil, fmt.Errorf("unknown meta field '%s'", f.Name)
+}
+
+// object writes the selected fields in the requested order
+func (my *builder) object(set []ast.Selection, typename string, resolve resolveFunc) (json.RawMessage, error) {
+	fields, err := my.collectFields(set)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		var val interface{} = typename
+		if f.Name != "__typename" {
+			if val, err = resolve(f); err != nil {
+				return nil, err
+			}
+		}
+		key, err := sonic.Marshal(responseKey(f))
+		if err != nil {
+			return nil, err
+		}
+		data, err := sonic.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (my *builder) schemaObject(set []ast.Selection, s *__Schema) (json.RawMessage, error) {
+	return my.object(set, "__Schema", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "description":
+			return nullable(s.Description), nil
+		case "types":
+			names := make([]string, 0, len(s.Types))
+			for k := range s.Types {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			list := make([]json.RawMessage, 0, len(names))
+			for _, n := range names {
+				t := s.Types[n]
+				v, err := my.typeObject(f.SelectionSet, &t)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "queryType":
+			return my.typeRef(f.SelectionSet, &s.QueryType)
+		case "mutationType":
+			return my.typeRef(f.SelectionSet, s.MutationType)
+		case "subscriptionType":
+			return my.typeRef(f.SelectionSet, s.SubscriptionType)
+		case "directives":
+			names := make([]string, 0, len(s.Directives))
+			for k := range s.Directives {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			list := make([]json.RawMessage, 0, len(names))
+			for _, n := range names {
+				v, err := my.directiveObject(f.SelectionSet, s.Directives[n])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__Schema'", f.Name)
+	})
+}
+
+// typeRef expands the references used inside the schema, they only carry the name of the type
+func (my *builder) typeRef(set []ast.Selection, t *__Type) (interface{}, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.Kind == "" {
+		v, ok := my.schema(my.session.role).Types[t.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown type '%s'", t.Name)
+		}
+		t = &v
+	}
+	return my.typeObject(set, t)
+}
+
+func (my *builder) typeObject(set []ast.Selection, t *__Type) (json.RawMessage, error) {
+	return my.object(set, "__Type", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "kind":
+			return t.Kind, nil
+		case "name":
+			return nullable(t.Name), nil
+		case "description":
+			return nullable(t.Description), nil
+		case "specifiedByURL", "specifiedByUrl":
+			return nullable(t.SpecifiedByURL), nil
+		case "ofType":
+			return my.typeRef(f.SelectionSet, t.OfType)
+		case "fields":
+			if t.Kind != TK_OBJECT && t.Kind != TK_INTERFACE {
+				return nil, nil
+			}
+			deprecated, err := my.includeDeprecated(f)
+			if err != nil {
+				return nil, err
+			}
+			list := make([]json.RawMessage, 0, len(t.Fields))
+			for _, v := range t.Fields {
+				if v.IsDeprecated && !deprecated {
+					continue
+				}
+				data, err := my.fieldObject(f.SelectionSet, v)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, data)
+			}
+			return list, nil
+		case "interfaces", "possibleTypes":
+			types := t.Interfaces
+			if f.Name == "possibleTypes" {
+				if t.Kind != TK_INTERFACE && t.Kind != TK_UNION {
+					return nil, nil
+				}
+				types = t.PossibleTypes
+			} else if t.Kind != TK_OBJECT && t.Kind != TK_INTERFACE {
+				return nil, nil
+			}
+			list := make([]interface{}, 0, len(types))
+			for i := range types {
+				data, err := my.typeRef(f.SelectionSet, &types[i])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, data)
+			}
+			return list, nil
+		case "enumValues":
+			if t.Kind != TK_ENUM {
+				return nil, nil
+			}
+			deprecated, err := my.includeDeprecated(f)
+			if err != nil {
+				return nil, err
+			}
+			list := make([]json.RawMessage, 0, len(t.EnumValues))
+			for _, v := range t.EnumValues {
+				if v.IsDeprecated && !deprecated {
+					continue
+				}
+				data, err := my.enumValueObject(f.SelectionSet, v)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, data)
+			}
+			return list, nil
+		case "inputFields":
+			if t.Kind != TK_INPUT_OBJECT {
+				return nil, nil
+			}
+			return my.inputValues(f.SelectionSet, t.InputFields)
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__Type'", f.Name)
+	})
+}
+
+func (my *builder) fieldObject(set []ast.Selection, v __Field) (json.RawMessage, error) {
+	return my.object(set, "__Field", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "name":
+			return v.Name, nil
+		case "description":
+			return nullable(v.Description), nil
+		case "args":
+			return my.inputValues(f.SelectionSet, v.Args)
+		case "type":
+			return my.typeRef(f.SelectionSet, v.Type)
+		case "isDeprecated":
+			return v.IsDeprecated, nil
+		case "deprecationReason":
+			return nullable(v.DeprecationReason), nil
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__Field'", f.Name)
+	})
+}
+
+func (my *builder) inputValues(set []ast.Selection, values []__InputValue) (interface{}, error) {
+	list := make([]json.RawMessage, 0, len(values))
+	for _, v := range values {
+		data, err := my.inputValueObject(set, v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, data)
+	}
+	return list, nil
+}
+
+func (my *builder) inputValueObject(set []ast.Selection, v __InputValue) (json.RawMessage, error) {
+	return my.object(set, "__InputValue", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "name":
+			return v.Name, nil
+		case "description":
+			return nullable(v.Description), nil
+		case "type":
+			return my.typeRef(f.SelectionSet, v.Type)
+		case "defaultValue":
+			return nullable(v.DefaultValue), nil
+		case "isDeprecated":
+			return v.IsDeprecated, nil
+		case "deprecationReason":
+			return nullable(v.DeprecationReason), nil
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__InputValue'", f.Name)
+	})
+}
+
+func (my *builder) enumValueObject(set []ast.Selection, v __EnumValue) (json.RawMessage, error) {
+	return my.object(set, "__EnumValue", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "name":
+			return v.Name, nil
+		case "description":
+			return nullable(v.Description), nil
+		case "isDeprecated":
+			return v.IsDeprecated, nil
+		case "deprecationReason":
+			return nullable(v.DeprecationReason), nil
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__EnumValue'", f.Name)
+	})
+}
+
+func (my *builder) directiveObject(set []ast.Selection, v __Directive) (json.RawMessage, error) {
+	return my.object(set, "__Directive", func(f *ast.Field) (interface{}, error) {
+		switch f.Name {
+		case "name":
+			return v.Name, nil
+		case "description":
+			return nullable(v.Description), nil
+		case "locations":
+			return append([]__DirectiveLocation{}, v.Locations...), nil
+		case "args":
+			return my.inputValues(f.SelectionSet, v.Args)
+		case "isRepeatable":
+			return v.IsRepeatable, nil
+		}
+		return nil, fmt.Errorf("cannot query field '%s' on type '__Directive'", f.Name)
+	})
+}
+
+func (my *builder) includeDeprecated(f *ast.Field) (bool, error) {
+	for _, a := range f.Arguments {
+		if a.Name != "includeDeprecated" {
+			continue
+		}
+		v, err := my.valueOf(a.Value)
+		if err != nil {
+			return false, err
+		}
+		b, _ := v.(bool)
+		return b, nil
+	}
+	return false, nil
+}
+
+// nullable maps the empty strings of the schema to null
+func nullable(val string) interface{} {
+	if val == "" {
+		return nil
+	}
+	return val
+}
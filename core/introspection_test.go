@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"github.com/bytedance/sonic"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{}`))
+	e := newTestEngine(t, &Config{}, db)
+
+	res, err := e.GraphQL(context.Background(), `
+	query IntrospectionQuery {
+		__typename
+		__schema {
+			queryType { name kind }
+			mutationType { name }
+			directives { name locations args { name type { kind ofType { name kind } } } }
+			types { ...TypeRef }
+		}
+		users: __type(name: "users") {
+			kind
+			name
+			fields { name type { ...TypeRef } }
+			inputFields { name }
+		}
+		missing: __type(name: "missing") { name }
+	}
+
+	fragment TypeRef on __Type { kind name ofType { kind name } }
+	`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := f.last(); q != "" {
+		t.Errorf("introspection should not query the database, got %s", q)
+	}
+
+	var data struct {
+		Typename string `json:"__typename"`
+		Schema   struct {
+			QueryType struct {
+				Name string
+				Kind string
+			} `json:"queryType"`
+			Directives []struct {
+				Name string
+				Args []struct {
+					Type struct {
+						Kind   string
+						OfType struct {
+							Name string
+							Kind string
+						} `json:"ofType"`
+					}
+				}
+			}
+			Types []map[string]interface{}
+		} `json:"__schema"`
+		Users struct {
+			Kind        string
+			Name        string
+			Fields      []map[string]interface{}
+			InputFields interface{} `json:"inputFields"`
+		}
+		Missing interface{}
+	}
+	if err = sonic.Unmarshal(res.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Typename != "Query" || data.Schema.QueryType.Name != "Query" || data.Schema.QueryType.Kind != "OBJECT" {
+		t.Errorf("unexpected root types %s", res.Data)
+	}
+	if len(data.Schema.Directives) != 2 || data.Schema.Directives[0].Args[0].Type.OfType.Kind != "SCALAR" {
+		t.Errorf("unexpected directives %+v", data.Schema.Directives)
+	}
+	if data.Users.Kind != "OBJECT" || data.Users.InputFields != nil || data.Missing != nil {
+		t.Errorf("unexpected users type %s", res.Data)
+	}
+	for _, v := range data.Users.Fields {
+		if v["name"] != "email" {
+			continue
+		}
+		typ, _ := v["type"].(map[string]interface{})
+		of, _ := typ["ofType"].(map[string]interface{})
+		if typ["kind"] != "NON_NULL" || typ["name"] != nil || of["kind"] != "SCALAR" || of["name"] != "String" {
+			t.Errorf("expected a non null string, got %v", typ)
+		}
+	}
+	for _, v := range data.Schema.Types {
+		if _, ok := v["kind"]; !ok || len(v) != 3 {
+			t.Errorf("unexpected type shape %v", v)
+		}
+	}
+}
+
+func TestIntrospectionMixed(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{"users":[],"__type":{"name":"posts"}}`))
+	e := newTestEngine(t, &Config{}, db)
+
+	if _, err := e.GraphQL(context.Background(), `{ users { id } __type(name: "posts") { name } }`, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	q, args := f.last()
+	if !strings.Contains(q, `'__type', $1::json`) || len(args) != 1 || args[0].Value != `{"name":"posts"}` {
+		t.Errorf("unexpected statement %s %v", q, args)
+	}
+}
+
+func TestIntrospectionRoles(t *testing.T) {
+	db, _ := openFakeDB(t, jsonReply(`{}`))
+	conf := newTestRoles()
+	conf.Roles = append(conf.Roles, RoleConfig{Name: "reader", Tables: []RoleTable{
+		{Name: "users", ReadOnly: true}, {Name: "posts", ReadOnly: true},
+	}})
+	e := newTestEngine(t, conf, db)
+
+	introspect := func(role string) map[string]interface{} {
+		ctx := context.WithValue(context.Background(), UserRoleKey, role)
+		res, err := e.GraphQL(ctx, `{
+			__schema {
+				queryType { fields { name } }
+				mutationType { fields { name args { name } } }
+			}
+			users: __type(name: "users") { fields { name } }
+			insert: __type(name: "usersInsertInput") { inputFields { name } }
+		}`, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data map[string]interface{}
+		if err = sonic.Unmarshal(res.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	names := func(v interface{}, key string) string {
+		var list []string
+		if m, ok := v.(map[string]interface{}); ok {
+			items, _ := m[key].([]interface{})
+			for _, item := range items {
+				list = append(list, item.(map[string]interface{})["name"].(string))
+			}
+		}
+		sort.Strings(list)
+		return strings.Join(list, ",")
+	}
+
+	anon := introspect(roleAnon)
+	schema := anon["__schema"].(map[string]interface{})
+	if v := names(schema["queryType"], "fields"); v != "posts" {
+		t.Errorf("expected only posts for anon, got %s", v)
+	}
+	if anon["users"] != nil {
+		t.Errorf("expected the users type to be hidden, got %v", anon["users"])
+	}
+
+	user := introspect(roleUser)
+	if v := names(user["users"], "fields"); v != "count_email,count_id,email,id,max_email,max_id,min_email,min_id,posts" {
+		t.Errorf("expected the allowed columns, got %s", v)
+	}
+	if v := names(user["insert"], "inputFields"); !strings.Contains(v, "full_name") {
+		t.Errorf("expected all insert columns, got %s", v)
+	}
+
+	viewer := introspect("viewer")
+	mutation := viewer["__schema"].(map[string]interface{})["mutationType"]
+	if v := names(mutation, "fields"); v != "users" {
+		t.Errorf("expected only users mutations for viewer, got %s", v)
+	}
+
+	reader := introspect("reader")
+	if v := reader["__schema"].(map[string]interface{})["mutationType"]; v != nil {
+		t.Errorf("expected no mutations for reader, got %v", v)
+	}
+
+	ke := e.Load().(*kernel)
+	if ke.compiler.schema("reader") != ke.compiler.schema("reader") {
+		t.Error("expected the schema of the role to be cached")
+	}
+	if ke.compiler.schema("nope") != ke.compiler.schema("") || len(ke.compiler.schemas) != 5 {
+		t.Errorf("expected unknown roles to share the default schema, got %d schemas", len(ke.compiler.schemas))
+	}
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const auth0Prefix = "auth0|"
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token is expired")
+)
+
+// jwtAuth verifies HS256 tokens with the secret and RS256 or ES256 tokens with the public key
+type jwtAuth struct {
+	provider  string
+	cookie    string
+	roleClaim string
+	secret    []byte
+	key       crypto.PublicKey
+	now       func() time.Time
+}
+
+func newJWTAuth(conf *Config) (*jwtAuth, error) {
+	c := conf.Auth.JWT
+	a := &jwtAuth{
+		provider:  c.Provider,
+		cookie:    conf.Auth.Cookie,
+		roleClaim: c.RoleClaim,
+		secret:    []byte(c.Secret),
+		now:       time.Now,
+	}
+	if a.roleClaim == "" {
+		a.roleClaim = "role"
+	}
+	if c.PublicKeyFile != "" {
+		path := c.PublicKeyFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(conf.ConfigPath, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading jwt public key: %w", err)
+		}
+		if a.key, err = parsePublicKey(data, c.PublicKeyType); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.secret) == 0 && a.key == nil {
+		return nil, errors.New("jwt auth requires a secret or a public key file")
+	}
+	return a, nil
+}
+
+// parsePublicKey reads a pem encoded public key or certificate
+func parsePublicKey(data []byte, typ string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt public key is not pem encoded")
+	}
+
+	var (
+		key crypto.PublicKey
+		err error
+	)
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jwt public key: %w", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if typ != "" && typ != "rsa" {
+			return nil, fmt.Errorf("jwt public key is not of type '%s'", typ)
+		}
+	case *ecdsa.PublicKey:
+		if typ != "" && typ != "ecdsa" {
+			return nil, fmt.Errorf("jwt public key is not of type '%s'", typ)
+		}
+	default:
+		return nil, errors.New("jwt public key must be a rsa or ecdsa key")
+	}
+	return key, nil
+}
+
+func (my *jwtAuth) Authenticate(r *http.Request) (string, string, error) {
+	var token string
+	if v := r.Header.Get("Authorization"); len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+		token = strings.TrimSpace(v[7:])
+	} else if my.cookie != "" {
+		if c, err := r.Cookie(my.cookie); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		return "", "", nil
+	}
+
+	claims, err := my.verify(token)
+	if err != nil {
+		return "", "", err
+	}
+	var uid, role string
+	if v, ok := claims["sub"]; ok && v != nil {
+		uid = fmt.Sprint(v)
+	}
+	if my.provider == "auth0" {
+		uid = strings.TrimPrefix(uid, auth0Prefix)
+	}
+	if v, ok := claims[my.roleClaim].(string); ok {
+		role = v
+	}
+	return uid, role, nil
+}
+
+// verify checks the signature and the time claims of the token and returns its claims
+func (my *jwtAuth) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	// the algorithm of the header must match the kind of key, `none` is never accepted
+	signed := []byte(parts[0] + "." + parts[1])
+	sum := sha256.Sum256(signed)
+	switch header.Alg {
+	case "HS256":
+		if len(my.secret) == 0 {
+			return nil, errInvalidToken
+		}
+		mac := hmac.New(sha256.New, my.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errInvalidToken
+		}
+	case "RS256":
+		key, ok := my.key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) != nil {
+			return nil, errInvalidToken
+		}
+	case "ES256":
+		key, ok := my.key.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() || len(sig) != 64 {
+			return nil, errInvalidToken
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, sum[:], r, s) {
+			return nil, errInvalidToken
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm '%s'", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := my.now().Unix()
+	if v, ok := claims["exp"].(json.Number); ok {
+		if exp, err := v.Int64(); err != nil || now >= exp {
+			return nil, errExpiredToken
+		}
+	}
+	if v, ok := claims["nbf"].(json.Number); ok {
+		if nbf, err := v.Int64(); err != nil || now < nbf {
+			return nil, errInvalidToken
+		}
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errInvalidToken
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(v); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
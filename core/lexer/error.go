@@ -12,6 +12,7 @@ type Error struct {
 	err        error                  `json:"-"`
 	Message    string                 `json:"message"`
 	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
 	Extensions map[string]interface{} `json:"extensions,omitempty"`
 	Rule       string                 `json:"-"`
 }
@@ -94,6 +95,14 @@ func Errorf(message string, args ...interface{}) *Error {
 	}
 }
 
+// WrapError keeps err as the cause so errors.Is and errors.As still match it
+func WrapError(err error) *Error {
+	return &Error{
+		err:     err,
+		Message: err.Error(),
+	}
+}
+
 func ErrorPosf(pos *Position, message string, args ...interface{}) *Error {
 	return ErrorLocf(
 		pos.Src.Name,
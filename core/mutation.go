@@ -0,0 +1,771 @@
+package core
+
+import (
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ichaly/tiny-go/core/ast"
+	"sort"
+)
+
+type mutateKind int8
+
+const (
+	mutInsert mutateKind = iota + 1
+	mutUpdate
+	mutUpsert
+	mutDelete
+	mutConnect
+	mutDisconnect
+)
+
+func (my mutateKind) String() string {
+	switch my {
+	case mutInsert:
+		return "insert"
+	case mutUpdate:
+		return "update"
+	case mutUpsert:
+		return "upsert"
+	case mutDelete:
+		return "delete"
+	case mutConnect:
+		return "connect"
+	case mutDisconnect:
+		return "disconnect"
+	}
+	return ""
+}
+
+// mutation changes the rows of a table, the returned rows feed the selection it belongs to.
+// Nested mutations of related tables run before it when it references them and after it otherwise,
+// an insert from a mutation inserts its rows once for every row returned by that mutation.
+type mutation struct {
+	kind    mutateKind
+	target  *selection
+	columns []DBColumn
+	rows    [][]interface{}
+	where   *exp
+	link    *mutationLink
+	from    *mutation
+	before  []*mutation
+	after   []*mutation
+}
+
+// mutationRef reads a column of the rows returned by another mutation
+type mutationRef struct {
+	m      *mutation
+	column DBColumn
+}
+
+// connectRef reads a column of the existing row matched by the expression
+type connectRef struct {
+	target *selection
+	where  *exp
+	column DBColumn
+}
+
+// mutationLink limits a nested mutation to the rows related to the rows of its parent
+type mutationLink struct {
+	column DBColumn
+	ref    mutationRef
+}
+
+// parseMutation reads the insert, update, upsert and delete arguments of a mutation field
+func (my *builder) parseMutation(s *selection, name string, v interface{}) error {
+	if my.kind != ast.Mutation || s.parent != nil {
+		return fmt.Errorf("argument '%s' is only allowed on mutation fields", name)
+	}
+	if s.mutation != nil {
+		return fmt.Errorf("only one of insert, update, upsert or delete is allowed on '%s'", s.name)
+	}
+
+	m := &mutation{target: s}
+	switch name {
+	case "insert":
+		m.kind = mutInsert
+	case "update":
+		m.kind = mutUpdate
+	case "upsert":
+		m.kind = mutUpsert
+	case "delete":
+		m.kind = mutDelete
+	}
+	if my.rule(s.table, m.kind.rule()).block {
+		return fmt.Errorf("%s is blocked on table '%s'", m.kind, s.table.Name)
+	}
+	s.mutation = m
+
+	if m.kind == mutDelete {
+		if ok, _ := v.(bool); !ok {
+			return fmt.Errorf("argument 'delete' must be true")
+		}
+		return nil
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	if m.kind == mutUpdate && len(list) != 1 {
+		return fmt.Errorf("argument 'update' expects a single object")
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("argument '%s' on '%s' has no rows", name, s.name)
+	}
+	return my.parseRows(m, list)
+}
+
+// parseRows reads the input objects of a mutation, keys that name a related table are nested mutations
+func (my *builder) parseRows(m *mutation, list []interface{}) error {
+	t := m.target.table
+	conf := my.rule(t, m.kind.rule())
+
+	// collect the columns of all rows first so that every row binds the same column list
+	var rows []map[string]interface{}
+	index := map[string]int{}
+	put := func(row map[string]interface{}, c DBColumn, val interface{}) {
+		if _, ok := index[c.Name]; !ok {
+			index[c.Name] = len(m.columns)
+			m.columns = append(m.columns, c)
+		}
+		row[c.Name] = val
+	}
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s on '%s' expects an object", m.kind, t.Name)
+		}
+		row := map[string]interface{}{}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if c, ok := my.column(t, k); ok {
+				if !conf.allows(c) {
+					return fmt.Errorf("column '%s' is not allowed in %s on '%s'", c.Name, m.kind, t.Name)
+				}
+				put(row, c, obj[k])
+				continue
+			}
+			if rt, ok := my.tables[k]; ok {
+				err := my.parseNested(m, rt, obj[k], len(list), func(c DBColumn, val interface{}) {
+					put(row, c, val)
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("unknown column '%s' in %s on '%s'", k, m.kind, t.Name)
+		}
+		rows = append(rows, row)
+	}
+
+	// presets always win over the values sent by the client
+	presets := make([]string, 0, len(conf.presets))
+	for k := range conf.presets {
+		presets = append(presets, k)
+	}
+	sort.Strings(presets)
+	for _, k := range presets {
+		c, ok := t.GetColumn(k)
+		if !ok {
+			return fmt.Errorf("unknown preset column '%s' on '%s'", k, t.Name)
+		}
+		val, err := my.presetValue(conf.presets[k])
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			put(row, c, val)
+		}
+	}
+
+	if len(m.columns) == 0 && len(m.before) == 0 && len(m.after) == 0 {
+		return fmt.Errorf("%s on '%s' has no columns", m.kind, t.Name)
+	}
+	for _, row := range rows {
+		values := make([]interface{}, len(m.columns))
+		for i, c := range m.columns {
+			if val, ok := row[c.Name]; ok {
+				values[i] = val
+			} else {
+				values[i] = defaultValue{}
+			}
+		}
+		m.rows = append(m.rows, values)
+	}
+	return nil
+}
+
+// parseNested reads the nested input of a related table. When the related table holds the foreign key
+// its rows are changed after the parent, otherwise they are changed first and the parent row points to them.
+func (my *builder) parseNested(m *mutation, rt *DBTable, v interface{}, count int, set func(DBColumn, interface{})) error {
+	t := m.target.table
+	rel, err := my.info.GetRelation(rt, t)
+	if err != nil {
+		return err
+	}
+	if rel.Type == RelEmbedded {
+		return fmt.Errorf("nested '%s' on '%s' is not supported for json tables", rt.Name, t.Name)
+	}
+	if rel.Left.Array || rel.Right.Array {
+		return fmt.Errorf("nested '%s' on '%s' is not supported for array foreign keys", rt.Name, t.Name)
+	}
+	if rel.Type == RelManyToMany {
+		return fmt.Errorf("nested '%s' on '%s' is not supported through the join table '%s'", rt.Name, t.Name, rel.Through.Name)
+	}
+	// owned means the related table holds the foreign key pointing to the parent
+	owned := isReference(rel.Left, t) && rel.Left.FKeyCol == rel.Right.Name
+	if owned && count != 1 {
+		return fmt.Errorf("nested '%s' requires a single '%s' row", rt.Name, t.Name)
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	if !owned && len(list) != 1 {
+		return fmt.Errorf("nested '%s' on '%s' expects a single object", rt.Name, t.Name)
+	}
+
+	var inserts []interface{}
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("nested '%s' on '%s' expects an object", rt.Name, t.Name)
+		}
+		rest := make(map[string]interface{}, len(obj))
+		for k, val := range obj {
+			rest[k] = val
+		}
+		connect, disconnect, where := rest["connect"], rest["disconnect"], rest["where"]
+		delete(rest, "connect")
+		delete(rest, "disconnect")
+		delete(rest, "where")
+
+		if connect != nil {
+			e, err := my.parseWhere(rt, connect)
+			if err != nil {
+				return err
+			}
+			if e == nil {
+				return fmt.Errorf("connect on '%s' requires an expression", rt.Name)
+			}
+			if owned {
+				child, err := my.nestedMutation(mutConnect, rt)
+				if err != nil {
+					return err
+				}
+				if child.where, err = my.filter(rt, ruleUpdate); err != nil {
+					return err
+				}
+				child.columns = []DBColumn{rel.Left}
+				child.rows = [][]interface{}{{mutationRef{m: m, column: rel.Right}}}
+				child.where = and(e, child.where)
+				m.after = append(m.after, child)
+			} else {
+				if my.rule(rt, ruleQuery).block {
+					return fmt.Errorf("%s is blocked on table '%s'", mutConnect, rt.Name)
+				}
+				f, err := my.filter(rt, ruleQuery)
+				if err != nil {
+					return err
+				}
+				set(rel.Right, connectRef{target: my.nestedSelection(rt), where: and(e, f), column: rel.Left})
+			}
+		}
+
+		if disconnect != nil {
+			if m.kind != mutUpdate {
+				return fmt.Errorf("disconnect on '%s' is only allowed in updates", rt.Name)
+			}
+			if owned {
+				e, err := my.parseWhere(rt, disconnect)
+				if err != nil {
+					return err
+				}
+				child, err := my.nestedMutation(mutDisconnect, rt)
+				if err != nil {
+					return err
+				}
+				if child.where, err = my.filter(rt, ruleUpdate); err != nil {
+					return err
+				}
+				child.columns = []DBColumn{rel.Left}
+				child.rows = [][]interface{}{{nil}}
+				child.where = and(e, child.where)
+				child.link = &mutationLink{column: rel.Left, ref: mutationRef{m: m, column: rel.Right}}
+				m.after = append(m.after, child)
+			} else {
+				set(rel.Right, nil)
+			}
+		}
+
+		if len(rest) == 0 {
+			continue
+		}
+
+		// an expression or an update of the parent changes the related rows in place
+		if where != nil || m.kind == mutUpdate {
+			child, err := my.nestedMutation(mutUpdate, rt)
+			if err != nil {
+				return err
+			}
+			if child.where, err = my.parseWhere(rt, where); err != nil {
+				return err
+			}
+			f, err := my.filter(rt, ruleUpdate)
+			if err != nil {
+				return err
+			}
+			child.where = and(child.where, f)
+			child.link = &mutationLink{column: rel.Left, ref: mutationRef{m: m, column: rel.Right}}
+			if err = my.parseRows(child, []interface{}{rest}); err != nil {
+				return err
+			}
+			m.after = append(m.after, child)
+			continue
+		}
+		inserts = append(inserts, rest)
+	}
+
+	if len(inserts) == 0 {
+		return nil
+	}
+	child, err := my.nestedMutation(mutInsert, rt)
+	if err != nil {
+		return err
+	}
+	if err = my.parseRows(child, inserts); err != nil {
+		return err
+	}
+	if owned {
+		// the rows are selected from the parent rows, there is no way to select the default of a column
+		for _, row := range child.rows {
+			for i, v := range row {
+				if _, ok := v.(defaultValue); ok {
+					return fmt.Errorf("nested '%s' on '%s' requires column '%s' in every row", rt.Name, t.Name, child.columns[i].Name)
+				}
+			}
+		}
+		child.from = m
+		child.set(rel.Left, mutationRef{m: m, column: rel.Right})
+		m.after = append(m.after, child)
+	} else {
+		set(rel.Right, mutationRef{m: child, column: rel.Left})
+		m.before = append(m.before, child)
+	}
+	return nil
+}
+
+func (my *builder) nestedMutation(kind mutateKind, t *DBTable) (*mutation, error) {
+	if my.rule(t, kind.rule()).block {
+		return nil, fmt.Errorf("%s is blocked on table '%s'", kind, t.Name)
+	}
+	return &mutation{kind: kind, target: my.nestedSelection(t)}, nil
+}
+
+// nestedSelection only reserves the alias of a table used inside a mutation
+func (my *builder) nestedSelection(t *DBTable) *selection {
+	s := &selection{id: my.seq, name: t.Name, table: t}
+	my.seq++
+	return s
+}
+
+// set assigns the value to the column of every row, replacing the value sent by the client
+func (my *mutation) set(c DBColumn, val interface{}) {
+	for i, v := range my.columns {
+		if v.Name == c.Name {
+			for _, row := range my.rows {
+				row[i] = val
+			}
+			return
+		}
+	}
+	my.columns = append(my.columns, c)
+	for i := range my.rows {
+		my.rows[i] = append(my.rows[i], val)
+	}
+}
+
+// defaultValue marks a column that was not set in one of the rows of a multi row insert
+type defaultValue struct{}
+
+// presetValue resolves `$name` from the session variables, everything else is used as is
+func (my *builder) presetValue(val string) (interface{}, error) {
+	if len(val) > 1 && val[0] == '$' {
+		v, ok := my.session.vars[val[1:]]
+		if !ok {
+			return nil, fmt.Errorf("preset variable '%s' is not defined", val)
+		}
+		return v, nil
+	}
+	return val, nil
+}
+
+// finishMutation moves the filters onto the mutation, the selection reads the returned rows as they are
+func (my *builder) finishMutation(s *selection) error {
+	m := s.mutation
+	if m == nil {
+		if my.kind == ast.Mutation && s.parent == nil {
+			return fmt.Errorf("field '%s' requires one of the insert, update, upsert or delete arguments", s.name)
+		}
+		return nil
+	}
+	switch m.kind {
+	case mutUpdate, mutDelete:
+		if s.where.matchesAll() {
+			return fmt.Errorf("%s on '%s' requires a where or id argument", m.kind, s.name)
+		}
+		m.where, s.where = s.where, nil
+	case mutUpsert:
+		if s.table.PrimaryCol.Name == "" {
+			return fmt.Errorf("upsert on '%s' requires a primary key", s.table.Name)
+		}
+		if s.where != nil {
+			return fmt.Errorf("upsert on '%s' does not support a where argument", s.name)
+		}
+	case mutInsert:
+		if s.where != nil {
+			return fmt.Errorf("insert on '%s' does not support a where argument", s.name)
+		}
+	}
+	// the filters of the role limit the rows that are changed, an upsert only skips the conflicting update
+	if m.kind != mutInsert {
+		e, err := my.filter(s.table, m.kind.rule())
+		if err != nil {
+			return err
+		}
+		m.where = and(m.where, e)
+	}
+	s.limit, s.offset = 0, 0
+	return nil
+}
+
+// renderMutations writes the data modifying ctes of the mutation fields in the order they depend on each other:
+// WITH "__mu_0" AS (INSERT INTO ... RETURNING *), ...
+func (my *renderer) renderMutations(q *query) error {
+	first := true
+	for _, f := range q.fields {
+		if f.kind != kindSelect || f.child.mutation == nil {
+			continue
+		}
+		if err := my.renderCTE(f.child.mutation, &first); err != nil {
+			return err
+		}
+	}
+	if !first {
+		my.WriteString(` `)
+	}
+	return nil
+}
+
+func (my *renderer) renderCTE(m *mutation, first *bool) error {
+	for _, v := range m.before {
+		if err := my.renderCTE(v, first); err != nil {
+			return err
+		}
+	}
+	if *first {
+		my.WriteString(`WITH `)
+		*first = false
+	} else {
+		my.WriteString(`, `)
+	}
+	my.quote(muAlias(m.target))
+	my.WriteString(` AS (`)
+	if err := my.renderMutation(m); err != nil {
+		return err
+	}
+	my.WriteString(`)`)
+	if my.mutated == nil {
+		my.mutated = make(map[*DBTable][]*mutation)
+	}
+	my.mutated[m.target.table] = append(my.mutated[m.target.table], m)
+	for _, v := range m.after {
+		if err := my.renderCTE(v, first); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *renderer) renderMutation(m *mutation) error {
+	s := m.target
+	switch m.kind {
+	case mutInsert, mutUpsert:
+		my.WriteString(`INSERT INTO `)
+		my.table(s)
+		if len(m.columns) == 0 {
+			my.WriteString(` DEFAULT VALUES`)
+			break
+		}
+		my.WriteString(` (`)
+		for i, c := range m.columns {
+			if i != 0 {
+				my.WriteString(`, `)
+			}
+			my.quote(c.Name)
+		}
+		if m.from != nil {
+			my.WriteString(`) `)
+			if err := my.renderInsertFrom(m); err != nil {
+				return err
+			}
+			break
+		}
+		my.WriteString(`) VALUES `)
+		for i, row := range m.rows {
+			if i != 0 {
+				my.WriteString(`, `)
+			}
+			my.WriteString(`(`)
+			for j, v := range row {
+				if j != 0 {
+					my.WriteString(`, `)
+				}
+				if err := my.bindValue(m.columns[j], v); err != nil {
+					return err
+				}
+			}
+			my.WriteString(`)`)
+		}
+		if m.kind == mutUpsert {
+			my.WriteString(` ON CONFLICT (`)
+			my.quote(s.table.PrimaryCol.Name)
+			my.WriteString(`) DO UPDATE SET `)
+			first := true
+			for _, c := range m.columns {
+				if c.Name == s.table.PrimaryCol.Name {
+					continue
+				}
+				if !first {
+					my.WriteString(`, `)
+				}
+				first = false
+				my.quote(c.Name)
+				my.WriteString(` = EXCLUDED.`)
+				my.quote(c.Name)
+			}
+			if first {
+				my.quote(s.table.PrimaryCol.Name)
+				my.WriteString(` = EXCLUDED.`)
+				my.quote(s.table.PrimaryCol.Name)
+			}
+			if m.where != nil {
+				my.WriteString(` WHERE `)
+				if err := my.renderExp(s, m.where); err != nil {
+					return err
+				}
+			}
+		}
+	case mutUpdate, mutConnect, mutDisconnect:
+		// an update that only changes related rows still has to return the rows it matched
+		if len(m.columns) == 0 {
+			my.WriteString(`SELECT `)
+			my.quote(tableAlias(s))
+			my.WriteString(`.* FROM `)
+			my.table(s)
+			return my.renderMutationWhere(m)
+		}
+		my.WriteString(`UPDATE `)
+		my.table(s)
+		my.WriteString(` SET `)
+		for i, c := range m.columns {
+			if i != 0 {
+				my.WriteString(`, `)
+			}
+			my.quote(c.Name)
+			my.WriteString(` = `)
+			if err := my.bindValue(c, m.rows[0][i]); err != nil {
+				return err
+			}
+		}
+		if err := my.renderMutationWhere(m); err != nil {
+			return err
+		}
+	case mutDelete:
+		my.WriteString(`DELETE FROM `)
+		my.table(s)
+		if err := my.renderMutationWhere(m); err != nil {
+			return err
+		}
+	}
+	my.WriteString(` RETURNING `)
+	my.quote(tableAlias(s))
+	my.WriteString(`.*`)
+	return nil
+}
+
+// renderInsertFrom inserts the rows for every row of the parent mutation, the values are cast
+// to the column types as the rows are combined with a union:
+// SELECT $1::text, "__mu_0"."id" FROM "__mu_0" UNION ALL SELECT $2::text, "__mu_0"."id" FROM "__mu_0"
+func (my *renderer) renderInsertFrom(m *mutation) error {
+	for i, row := range m.rows {
+		if i != 0 {
+			my.WriteString(` UNION ALL `)
+		}
+		my.WriteString(`SELECT `)
+		for j, v := range row {
+			if j != 0 {
+				my.WriteString(`, `)
+			}
+			if ref, ok := v.(mutationRef); ok && ref.m == m.from {
+				my.quote(muAlias(ref.m.target))
+				my.WriteString(`.`)
+				my.quote(ref.column.Name)
+				continue
+			}
+			c := m.columns[j]
+			if err := my.bindValue(c, v); err != nil {
+				return err
+			}
+			// array columns are already cast
+			if _, ok := v.([]interface{}); !ok || !c.Array {
+				my.WriteString(`::`)
+				my.WriteString(c.Type)
+			}
+		}
+		my.WriteString(` FROM `)
+		my.quote(muAlias(m.from.target))
+	}
+	return nil
+}
+
+// renderMutated reads a table changed by the statement, the ctes hold the changed rows as the statement
+// does not see its own changes. The changed rows replace the rows of the table by their primary key:
+// (SELECT * FROM "public"."posts" WHERE "id" NOT IN (SELECT "id" FROM "__mu_1") UNION ALL SELECT * FROM "__mu_1") AS "posts_2"
+func (my *renderer) renderMutated(s *selection) {
+	list, pk := my.mutated[s.table], s.table.PrimaryCol.Name
+	my.WriteString(`(SELECT * FROM `)
+	my.quote(s.table.Schema)
+	my.WriteString(`.`)
+	my.quote(s.table.sourceName())
+	if pk != "" {
+		my.WriteString(` WHERE `)
+		my.quote(pk)
+		my.WriteString(` NOT IN (`)
+		for i, m := range list {
+			if i != 0 {
+				my.WriteString(` UNION ALL `)
+			}
+			my.WriteString(`SELECT `)
+			my.quote(pk)
+			my.WriteString(` FROM `)
+			my.quote(muAlias(m.target))
+		}
+		my.WriteString(`)`)
+	}
+	for _, m := range list {
+		// deleted rows are gone and without a primary key only the inserted rows can be told apart
+		if m.kind == mutDelete || (pk == "" && m.kind != mutInsert) {
+			continue
+		}
+		my.WriteString(` UNION ALL SELECT * FROM `)
+		my.quote(muAlias(m.target))
+	}
+	my.WriteString(`) AS `)
+	my.quote(tableAlias(s))
+}
+
+func (my *renderer) renderMutationWhere(m *mutation) error {
+	if m.where == nil && m.link == nil {
+		return nil
+	}
+	my.WriteString(` WHERE `)
+	if m.where != nil {
+		if err := my.renderExp(m.target, m.where); err != nil {
+			return err
+		}
+	}
+	if m.link != nil {
+		if m.where != nil {
+			my.WriteString(` AND `)
+		}
+		my.column(m.target, m.link.column)
+		my.WriteString(` IN (`)
+		my.renderRef(m.link.ref)
+		my.WriteString(`)`)
+	}
+	return nil
+}
+
+// renderRef selects the column from the rows returned by the mutation: SELECT "__mu_0"."id" FROM "__mu_0"
+func (my *renderer) renderRef(ref mutationRef) {
+	my.WriteString(`SELECT `)
+	my.quote(muAlias(ref.m.target))
+	my.WriteString(`.`)
+	my.quote(ref.column.Name)
+	my.WriteString(` FROM `)
+	my.quote(muAlias(ref.m.target))
+}
+
+// bindValue binds an input value for a column, json and arrays are sent as json text
+func (my *renderer) bindValue(c DBColumn, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		my.WriteString(`NULL`)
+		return nil
+	case defaultValue:
+		my.WriteString(`DEFAULT`)
+		return nil
+	case mutationRef:
+		my.WriteString(`(`)
+		my.renderRef(val)
+		my.WriteString(`)`)
+		return nil
+	case connectRef:
+		my.WriteString(`(SELECT `)
+		my.column(val.target, val.column)
+		my.WriteString(` FROM `)
+		my.table(val.target)
+		my.WriteString(` WHERE `)
+		if err := my.renderExp(val.target, val.where); err != nil {
+			return err
+		}
+		my.WriteString(` LIMIT 1)`)
+		return nil
+	case []interface{}:
+		data, err := sonic.MarshalString(val)
+		if err != nil {
+			return err
+		}
+		if !c.Array {
+			my.bind(data)
+			return nil
+		}
+		my.WriteString(`ARRAY(SELECT json_array_elements_text(`)
+		my.bind(data)
+		my.WriteString(`::json))::`)
+		my.WriteString(c.Type)
+		return nil
+	case map[string]interface{}:
+		data, err := sonic.MarshalString(val)
+		if err != nil {
+			return err
+		}
+		my.bind(data)
+		return nil
+	}
+	my.bind(v)
+	return nil
+}
+
+func (my *renderer) table(s *selection) {
+	if s.table.embedded() {
+		my.recordset(s)
+		return
+	}
+	my.quote(s.table.Schema)
+	my.WriteString(`.`)
+	my.quote(s.table.sourceName())
+	my.WriteString(` AS `)
+	my.quote(tableAlias(s))
+}
+
+func muAlias(s *selection) string {
+	return fmt.Sprintf("__mu_%d", s.id)
+}
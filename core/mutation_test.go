@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileInsert(t *testing.T) {
+	st := compileTest(t, &Config{}, `
+	mutation ($data: users_insert_input) {
+		users(insert: $data) { id email }
+	}`, map[string]interface{}{
+		"data": map[string]interface{}{"email": "a@x.io", "tags": []interface{}{"a", "b"}},
+	})
+
+	expected := `WITH "__mu_0" AS (INSERT INTO "public"."users" AS "users_0" ("email", "tags") ` +
+		`VALUES ($1, ARRAY(SELECT json_array_elements_text($2::json))::text[]) RETURNING "users_0".*) ` +
+		`SELECT json_build_object('users', "__sj_0"."json") AS "__root" FROM (SELECT true) AS "__root_x" ` +
+		`LEFT OUTER JOIN LATERAL (SELECT coalesce(json_agg("__sj_0"."json"), '[]') AS "json" FROM (` +
+		`SELECT json_build_object('id', "users_0"."id", 'email', "users_0"."email") AS "json" FROM (` +
+		`SELECT "users_0".* FROM "__mu_0" AS "users_0") AS "users_0") AS "__sj_0") AS "__sj_0" ON true`
+	if st.sql != expected {
+		t.Errorf("unexpected sql:\n%s\nexpected:\n%s", st.sql, expected)
+	}
+	if len(st.args) != 2 || st.args[0] != "a@x.io" || st.args[1] != `["a","b"]` {
+		t.Errorf("unexpected args %v", st.args)
+	}
+}
+
+func TestCompileMutations(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     *Config
+		gql      string
+		vars     map[string]interface{}
+		expected []string
+	}{
+		{
+			name: "bulk insert",
+			conf: &Config{},
+			gql:  `mutation { posts(insert: [{title: "a"}, {title: "b", meta: {x: 1}}]) { id } }`,
+			expected: []string{
+				`INSERT INTO "public"."posts" AS "posts_0" ("title", "meta") VALUES ($1, DEFAULT), ($2, $3) RETURNING "posts_0".*`,
+			},
+		},
+		{
+			name: "update",
+			conf: &Config{},
+			gql:  `mutation { users(id: 3, update: {full_name: "x"}) { id } }`,
+			expected: []string{
+				`UPDATE "public"."users" AS "users_0" SET "full_name" = $1 WHERE ("users_0"."id" = $2) RETURNING "users_0".*`,
+				`SELECT "users_0".* FROM "__mu_0" AS "users_0")`,
+			},
+		},
+		{
+			name: "upsert",
+			conf: &Config{},
+			gql:  `mutation { users(upsert: {id: 3, email: "a@x.io"}) { id } }`,
+			expected: []string{
+				`INSERT INTO "public"."users" AS "users_0" ("email", "id") VALUES ($1, $2) ` +
+					`ON CONFLICT ("id") DO UPDATE SET "email" = EXCLUDED."email" RETURNING "users_0".*`,
+			},
+		},
+		{
+			name: "delete",
+			conf: &Config{},
+			gql:  `mutation { users(delete: true, where: {email: {equals: "a@x.io"}}) { id } }`,
+			expected: []string{
+				`DELETE FROM "public"."users" AS "users_0" WHERE ("users_0"."email" = $1) RETURNING "users_0".*`,
+			},
+		},
+		{
+			name: "many fields",
+			conf: &Config{},
+			gql: `mutation {
+				users(insert: {email: "a@x.io"}) { id }
+				posts(delete: true, id: 1) { id }
+			}`,
+			expected: []string{
+				`WITH "__mu_0" AS (INSERT INTO "public"."users"`,
+				`, "__mu_1" AS (DELETE FROM "public"."posts" AS "posts_1" WHERE ("posts_1"."id" = $2)`,
+			},
+		},
+		{
+			name: "presets",
+			conf: &Config{Tables: []TableConfig{{Name: "posts", Insert: &InsertConfig{
+				Presets: map[string]string{"title": "draft"},
+			}}}},
+			gql: `mutation { posts(insert: {title: "a", user_id: 7}) { id } }`,
+			expected: []string{
+				`("title", "user_id") VALUES ($1, $2)`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := compileTest(t, tt.conf, tt.gql, tt.vars)
+			for _, v := range tt.expected {
+				if !strings.Contains(st.sql, v) {
+					t.Errorf("expected %s in:\n%s", v, st.sql)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileNestedMutations(t *testing.T) {
+	tests := []struct {
+		name     string
+		gql      string
+		expected []string
+	}{
+		{
+			name: "insert children",
+			gql:  `mutation { users(insert: {email: "a@x.io", posts: [{title: "a"}, {title: "b"}]}) { id } }`,
+			expected: []string{
+				`WITH "__mu_0" AS (INSERT INTO "public"."users" AS "users_0" ("email") VALUES ($1) RETURNING "users_0".*), ` +
+					`"__mu_1" AS (INSERT INTO "public"."posts" AS "posts_1" ("title", "user_id") ` +
+					`SELECT $2::text, "__mu_0"."id" FROM "__mu_0" UNION ALL SELECT $3::text, "__mu_0"."id" FROM "__mu_0" RETURNING "posts_1".*) SELECT`,
+			},
+		},
+		{
+			name: "select children",
+			gql:  `mutation { users(insert: {email: "a@x.io", posts: {title: "a"}}) { id posts { title } } }`,
+			expected: []string{
+				`FROM (SELECT * FROM "public"."posts" WHERE "id" NOT IN (SELECT "id" FROM "__mu_1") ` +
+					`UNION ALL SELECT * FROM "__mu_1") AS "posts_2" WHERE "posts_2"."user_id" = "users_0"."id"`,
+			},
+		},
+		{
+			name: "insert parent",
+			gql:  `mutation { posts(insert: {title: "a", users: {email: "a@x.io"}}) { id } }`,
+			expected: []string{
+				`WITH "__mu_1" AS (INSERT INTO "public"."users" AS "users_1" ("email") VALUES ($1) RETURNING "users_1".*), ` +
+					`"__mu_0" AS (INSERT INTO "public"."posts" AS "posts_0" ("title", "user_id") ` +
+					`VALUES ($2, (SELECT "__mu_1"."id" FROM "__mu_1")) RETURNING "posts_0".*) SELECT`,
+			},
+		},
+		{
+			name: "connect parent",
+			gql:  `mutation { posts(insert: {title: "a", users: {connect: {id: {equals: 3}}}}) { id } }`,
+			expected: []string{
+				`INSERT INTO "public"."posts" AS "posts_0" ("title", "user_id") VALUES ($1, ` +
+					`(SELECT "users_1"."id" FROM "public"."users" AS "users_1" WHERE ("users_1"."id" = $2) LIMIT 1)) RETURNING "posts_0".*`,
+			},
+		},
+		{
+			name: "connect and disconnect children",
+			gql: `mutation {
+				users(id: 3, update: {posts: {connect: {id: {in: [1, 2]}}, disconnect: {id: {equals: 4}}}}) { id }
+			}`,
+			expected: []string{
+				`WITH "__mu_0" AS (SELECT "users_0".* FROM "public"."users" AS "users_0" WHERE ("users_0"."id" = $1)), `,
+				`"__mu_1" AS (UPDATE "public"."posts" AS "posts_1" SET "user_id" = (SELECT "__mu_0"."id" FROM "__mu_0") ` +
+					`WHERE ("posts_1"."id" IN ($2, $3)) RETURNING "posts_1".*), `,
+				`"__mu_2" AS (UPDATE "public"."posts" AS "posts_2" SET "user_id" = NULL ` +
+					`WHERE ("posts_2"."id" = $4) AND "posts_2"."user_id" IN (SELECT "__mu_0"."id" FROM "__mu_0") RETURNING "posts_2".*)`,
+			},
+		},
+		{
+			name: "update children",
+			gql:  `mutation { users(id: 3, update: {email: "b@x.io", posts: {where: {title: {isNull: true}}, title: "x"}}) { id } }`,
+			expected: []string{
+				`UPDATE "public"."users" AS "users_0" SET "email" = $1 WHERE ("users_0"."id" = $2) RETURNING "users_0".*), `,
+				`"__mu_1" AS (UPDATE "public"."posts" AS "posts_1" SET "title" = $3 ` +
+					`WHERE ("posts_1"."title" IS NULL) AND "posts_1"."user_id" IN (SELECT "__mu_0"."id" FROM "__mu_0")`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := compileTest(t, &Config{}, tt.gql, nil)
+			for _, v := range tt.expected {
+				if !strings.Contains(st.sql, v) {
+					t.Errorf("expected %s in:\n%s", v, st.sql)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileMutationErrors(t *testing.T) {
+	conf := &Config{Tables: []TableConfig{
+		{Name: "users", Delete: &DeleteConfig{Block: true}, Update: &UpdateConfig{Columns: []string{"full_name"}}},
+	}}
+	c := newTestCompiler(t, conf, nil)
+	for _, gql := range []string{
+		`mutation { users { id } }`,
+		`mutation { users(update: {full_name: "x"}) { id } }`,
+		`mutation { users(id: 1, update: {email: "x"}) { id } }`,
+		`mutation { users(id: 1, delete: true) { id } }`,
+		`mutation { users(insert: {password: "x"}) { id } }`,
+		`mutation { users(insert: {email: "x"}, delete: true) { id } }`,
+		`query { users(insert: {email: "x"}) { id } }`,
+		`mutation { posts(insert: {title: "x"}) { id users(insert: {email: "x"}) { id } } }`,
+		`mutation { users(insert: [{email: "a"}, {email: "b", posts: {title: "x"}}]) { id } }`,
+		`mutation { posts(insert: {title: "x", users: {disconnect: {id: {equals: 1}}}}) { id } }`,
+		`mutation { posts(insert: {title: "x", users: [{email: "a"}, {email: "b"}]}) { id } }`,
+		`mutation { users(insert: {email: "a", posts: [{title: "x"}, {meta: {x: 1}}]}) { id } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+	// an empty list is not a filter, so it neither changes every row nor reads all of them
+	for _, gql := range []string{
+		`mutation ($l: [postsWhereInput!]) { posts(delete: true, where: {and: $l}) { id } }`,
+		`mutation ($l: [postsWhereInput!]) { posts(update: {title: "x"}, where: {or: $l}) { id } }`,
+		`mutation ($e: postsWhereInput) { posts(delete: true, where: {and: [$e]}) { id } }`,
+		`query ($l: [usersWhereInput!]) { users(where: {or: $l}) { id } }`,
+		`query ($e: usersWhereInput) { users(where: {not: $e}) { id } }`,
+	} {
+		vars := map[string]interface{}{"l": []interface{}{}, "e": map[string]interface{}{}}
+		if _, err := compileSession(t, c, gql, vars, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+}
+
+func TestGraphQLMutation(t *testing.T) {
+	db, f := openFakeDB(t, jsonReply(`{"users":[{"id":1}]}`))
+	e := newTestEngine(t, &Config{}, db)
+
+	res, err := e.GraphQL(context.Background(), `mutation { users(insert: {email: "a@x.io"}) { id } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `{"users":[{"id":1}]}` {
+		t.Errorf("unexpected data %s", res.Data)
+	}
+	if f.commits != 1 || f.rollbacks != 0 {
+		t.Errorf("expected a committed transaction, got %d commits and %d rollbacks", f.commits, f.rollbacks)
+	}
+
+	f.reply = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, errors.New("duplicate key")
+	}
+	if _, err = e.GraphQL(context.Background(), `mutation { users(insert: {email: "a@x.io"}) { id } }`, nil, ""); err == nil {
+		t.Fatal("expected the database error")
+	}
+	if f.commits != 1 || f.rollbacks != 1 {
+		t.Errorf("expected a rolled back transaction, got %d commits and %d rollbacks", f.commits, f.rollbacks)
+	}
+}
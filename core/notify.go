@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/ichaly/tiny-go/core/internal"
+	"sort"
+	"time"
+)
+
+const (
+	subsPoll   = "poll"
+	subsNotify = "notify"
+
+	// notifySchema holds the trigger function, it is skipped like the `_gj_` tables
+	notifySchema  = "_gj_"
+	notifyChannel = "_gj_changes"
+	notifyTrigger = "_gj_notify"
+)
+
+// queryTables lists the tables read by the query, the join tables of many-to-many relations included
+// since linking or unlinking rows only changes them
+func queryTables(q *query) []*DBTable {
+	seen := make(map[string]*DBTable)
+	add := func(s *selection) {
+		seen[notifyKey(s.table)] = s.table
+		if s.rel != nil && s.rel.Through != nil {
+			seen[notifyKey(s.rel.Through)] = s.rel.Through
+		}
+	}
+	var walk func(fields []*field)
+	walk = func(fields []*field) {
+		for _, f := range fields {
+			switch f.kind {
+			case kindSelect:
+				add(f.child)
+				walk(f.child.fields)
+			case kindUnion:
+				for _, m := range f.child.members {
+					add(m)
+					walk(m.fields)
+				}
+			}
+		}
+	}
+	walk(q.fields)
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]*DBTable, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, seen[k])
+	}
+	return list
+}
+
+// installTriggers adds the statement triggers that notify the changes of the tables,
+// every table is only installed once per engine
+func (my *Engine) installTriggers(ctx context.Context, ke *kernel, tables []*DBTable) error {
+	my.subs.install.Lock()
+	defer my.subs.install.Unlock()
+
+	if my.subs.triggers == nil {
+		if _, err := ke.db.ExecContext(ctx, internal.PostgresNotify); err != nil {
+			return fmt.Errorf("error installing notify function: %w", err)
+		}
+		my.subs.triggers = make(map[string]bool)
+	}
+
+	for _, t := range tables {
+		key := notifyKey(t)
+		if my.subs.triggers[key] {
+			continue
+		}
+		r := &renderer{}
+		r.WriteString(`DROP TRIGGER IF EXISTS `)
+		r.quote(notifyTrigger)
+		r.WriteString(` ON `)
+		r.quote(t.Schema)
+		r.WriteString(`.`)
+		r.quote(t.sourceName())
+		r.WriteString(`; CREATE TRIGGER `)
+		r.quote(notifyTrigger)
+		r.WriteString(` AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON `)
+		r.quote(t.Schema)
+		r.WriteString(`.`)
+		r.quote(t.sourceName())
+		r.WriteString(` FOR EACH STATEMENT EXECUTE PROCEDURE `)
+		r.quote(notifySchema)
+		r.WriteString(`.`)
+		r.quote("notify")
+		r.WriteString(`()`)
+		if _, err := ke.db.ExecContext(ctx, r.String()); err != nil {
+			return fmt.Errorf("error installing notify trigger on '%s': %w", key, err)
+		}
+		my.subs.triggers[key] = true
+	}
+	return nil
+}
+
+// listen starts the listener once, it keeps listening until the engine is done
+func (my *Engine) listen(ke *kernel) {
+	my.subs.Lock()
+	defer my.subs.Unlock()
+	if my.subs.listening {
+		return
+	}
+	my.subs.listening = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-my.done
+		cancel()
+	}()
+
+	go func() {
+		for {
+			err := ke.listener.Listen(ctx, notifyChannel, my.notify)
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if err != nil {
+				ke.log.Println(err)
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+// notify wakes the streams that read the changed table
+func (my *Engine) notify(table string) {
+	my.subs.Lock()
+	defer my.subs.Unlock()
+	for _, s := range my.subs.streams {
+		s.Lock()
+		ok := s.tables[table]
+		s.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyKey is the payload sent by the trigger of the table
+func notifyKey(t *DBTable) string {
+	return t.Schema + "." + t.sourceName()
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeListener stands in for a postgres connection waiting on LISTEN
+type fakeListener struct {
+	channel chan string
+	name    string
+	ready   sync.WaitGroup
+}
+
+func (my *fakeListener) Listen(ctx context.Context, channel string, fn func(payload string)) error {
+	my.name = channel
+	my.ready.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case v := <-my.channel:
+			fn(v)
+		}
+	}
+}
+
+func TestSubscribeNotify(t *testing.T) {
+	var mu sync.Mutex
+	version := "a"
+	db, f := openFakeDB(t, subscriptionReply(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return `{"users":[{"v":"` + version + `"}]}`
+	}))
+	l := &fakeListener{channel: make(chan string)}
+	l.ready.Add(1)
+
+	e := &Engine{done: make(chan bool)}
+	defer close(e.done)
+	if err := e.newKernel(&Config{SubsMode: subsNotify, PollEvery: 1}, db, newTestInfo(), nil, WithListener(l)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := e.Subscribe(context.Background(), `subscription { users { id posts { id } } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Unsubscribe()
+	receive(t, m)
+	l.ready.Wait()
+	if l.name != notifyChannel {
+		t.Errorf("expected to listen on %s, got %s", notifyChannel, l.name)
+	}
+
+	f.Lock()
+	statements := strings.Join(f.queries, "\n")
+	f.Unlock()
+	for _, v := range []string{
+		`CREATE OR REPLACE FUNCTION "_gj_"."notify"()`,
+		`CREATE TRIGGER "_gj_notify" AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON "public"."users"`,
+		`CREATE TRIGGER "_gj_notify" AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON "public"."posts"`,
+	} {
+		if !strings.Contains(statements, v) {
+			t.Errorf("expected %s in:\n%s", v, statements)
+		}
+	}
+
+	// the stream does not poll on its own
+	count := func() int {
+		f.Lock()
+		defer f.Unlock()
+		return len(f.queries)
+	}
+	before := count()
+	time.Sleep(1500 * time.Millisecond)
+	if count() != before {
+		t.Errorf("expected no polling in notify mode")
+	}
+
+	mu.Lock()
+	version = "b"
+	mu.Unlock()
+	l.channel <- "public.comments"
+	l.channel <- "public.posts"
+	if res := receive(t, m); !strings.Contains(string(res.Data), `"v":"b"`) {
+		t.Errorf("expected the changed data, got %s", res.Data)
+	}
+	if count() != before+1 {
+		t.Errorf("expected only the changed table to wake the stream, got %d statements", count()-before)
+	}
+
+	// triggers are installed once
+	if _, err = e.Subscribe(context.Background(), `subscription { users { email } }`, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if n := strings.Count(strings.Join(f.queries, "\n"), `CREATE TRIGGER`); n != 2 {
+		t.Errorf("expected 2 triggers, got %d", n)
+	}
+}
+
+func TestSubscribeNotifyThrough(t *testing.T) {
+	var mu sync.Mutex
+	version := "a"
+	db, f := openFakeDB(t, subscriptionReply(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return `{"products":[{"v":"` + version + `"}]}`
+	}))
+	l := &fakeListener{channel: make(chan string)}
+	l.ready.Add(1)
+
+	e := &Engine{done: make(chan bool)}
+	defer close(e.done)
+	if err := e.newKernel(&Config{SubsMode: subsNotify}, db, newThroughInfo(), nil, WithListener(l)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := e.Subscribe(context.Background(), `subscription { products { name categories { name } } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Unsubscribe()
+	receive(t, m)
+	l.ready.Wait()
+
+	f.Lock()
+	statements := strings.Join(f.queries, "\n")
+	f.Unlock()
+	if v := `CREATE TRIGGER "_gj_notify" AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON "public"."product_categories"`; !strings.Contains(statements, v) {
+		t.Errorf("expected %s in:\n%s", v, statements)
+	}
+
+	// linking a product to a category only changes the join table
+	mu.Lock()
+	version = "b"
+	mu.Unlock()
+	l.channel <- "public.product_categories"
+	if res := receive(t, m); !strings.Contains(string(res.Data), `"v":"b"`) {
+		t.Errorf("expected the changed data, got %s", res.Data)
+	}
+}
+
+func TestSubscribeNotifyConfig(t *testing.T) {
+	db, _ := openFakeDB(t, jsonReply(`{}`))
+	for _, mode := range []string{subsNotify, "push"} {
+		e := &Engine{done: make(chan bool)}
+		if err := e.newKernel(&Config{SubsMode: mode}, db, newTestInfo(), nil); err == nil {
+			t.Errorf("expected an error for mode %s", mode)
+		}
+	}
+}
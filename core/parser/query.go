@@ -26,6 +26,16 @@ func ParseQuery(src *lexer.Input) (*ast.QueryDocument, error) {
 	return &doc, p.err
 }
 
+// ParseValue parses a single value literal, variables are allowed and resolved by the caller
+func ParseValue(src *lexer.Input) (*ast.Value, error) {
+	p := parser{lexer: lexer.NewLexer(src)}
+	v := p.parseValueLiteral(false)
+	if p.peek().Kind != lexer.EOF {
+		p.unexpectedError()
+	}
+	return v, p.err
+}
+
 func (p *parser) parseOperationDefinition() *ast.OperationDefinition {
 	if p.peek().Kind == lexer.BraceL {
 		return &ast.OperationDefinition{
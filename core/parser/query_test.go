@@ -28,3 +28,19 @@ query ($orderStr: String, $targetType: Int) {
 	}
 	println(string(output))
 }
+
+func TestParseValue(t *testing.T) {
+	v, err := ParseValue(&lexer.Input{Content: `{ id: { _eq: $user_id }, tags: ["a", 1] }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Children) != 2 || v.Children[0].Name != "id" {
+		t.Errorf("unexpected value %+v", v)
+	}
+	if eq := v.Children[0].Children[0].Children[0]; eq.Name != "_eq" || eq.Children[0].Raw != "user_id" {
+		t.Errorf("unexpected value %+v", v)
+	}
+	if _, err = ParseValue(&lexer.Input{Content: `{ id: 1 } extra`}); err == nil {
+		t.Error("expected an error for trailing tokens")
+	}
+}
@@ -42,7 +42,7 @@ func (p *parser) parseSchemaDocument() *ast.SchemaDocument {
 			doc.Directives = append(doc.Directives, p.parseDirectiveDefinition(description))
 		case "extend":
 			if description != "" {
-				p.unexpectedToken(p.prev)
+				p.unexpectedError()
 			}
 			p.parseTypeSystemExtension(&doc)
 		default:
@@ -63,7 +63,7 @@ func (p *parser) parseDescription() string {
 	return p.next().Value
 }
 
-func (p *parser) parseTypeSystemDefinition(description string) *Definition {
+func (p *parser) parseTypeSystemDefinition(description string) *ast.Definition {
 	tok := p.peek()
 	if tok.Kind != lexer.Name {
 		p.unexpectedError()
@@ -89,11 +89,10 @@ func (p *parser) parseTypeSystemDefinition(description string) *Definition {
 	}
 }
 
-func (p *parser) parseSchemaDefinition(description string) *SchemaDefinition {
+func (p *parser) parseSchemaDefinition(description string) *ast.SchemaDefinition {
 	p.expectKeyword("schema")
 
-	def := SchemaDefinition{Description: description}
-	def.Position = p.peekPos()
+	def := ast.SchemaDefinition{Description: description}
 	def.Description = description
 	def.Directives = p.parseDirectives(true)
 
@@ -103,33 +102,30 @@ func (p *parser) parseSchemaDefinition(description string) *SchemaDefinition {
 	return &def
 }
 
-func (p *parser) parseOperationTypeDefinition() *OperationTypeDefinition {
-	var op OperationTypeDefinition
-	op.Position = p.peekPos()
+func (p *parser) parseOperationTypeDefinition() *ast.OperationTypeDefinition {
+	var op ast.OperationTypeDefinition
 	op.Operation = p.parseOperationType()
 	p.expect(lexer.Colon)
 	op.Type = p.parseName()
 	return &op
 }
 
-func (p *parser) parseScalarTypeDefinition(description string) *Definition {
+func (p *parser) parseScalarTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("scalar")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Scalar
+	var def ast.Definition
+	def.Kind = ast.SCALAR
 	def.Description = description
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
 	return &def
 }
 
-func (p *parser) parseObjectTypeDefinition(description string) *Definition {
+func (p *parser) parseObjectTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("type")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Object
+	var def ast.Definition
+	def.Kind = ast.OBJECT
 	def.Description = description
 	def.Name = p.parseName()
 	def.Interfaces = p.parseImplementsInterfaces()
@@ -153,17 +149,16 @@ func (p *parser) parseImplementsInterfaces() []string {
 	return types
 }
 
-func (p *parser) parseFieldsDefinition() FieldList {
-	var defs FieldList
+func (p *parser) parseFieldsDefinition() []*ast.FieldDefinition {
+	var defs []*ast.FieldDefinition
 	p.some(lexer.BraceL, lexer.BraceR, func() {
 		defs = append(defs, p.parseFieldDefinition())
 	})
 	return defs
 }
 
-func (p *parser) parseFieldDefinition() *FieldDefinition {
-	var def FieldDefinition
-	def.Position = p.peekPos()
+func (p *parser) parseFieldDefinition() *ast.FieldDefinition {
+	var def ast.FieldDefinition
 	def.Description = p.parseDescription()
 	def.Name = p.parseName()
 	def.Arguments = p.parseArgumentDefs()
@@ -174,17 +169,16 @@ func (p *parser) parseFieldDefinition() *FieldDefinition {
 	return &def
 }
 
-func (p *parser) parseArgumentDefs() ArgumentDefinitionList {
-	var args ArgumentDefinitionList
+func (p *parser) parseArgumentDefs() []*ast.ArgumentDefinition {
+	var args []*ast.ArgumentDefinition
 	p.some(lexer.ParenL, lexer.ParenR, func() {
 		args = append(args, p.parseArgumentDef())
 	})
 	return args
 }
 
-func (p *parser) parseArgumentDef() *ArgumentDefinition {
-	var def ArgumentDefinition
-	def.Position = p.peekPos()
+func (p *parser) parseArgumentDef() *ast.ArgumentDefinition {
+	var def ast.ArgumentDefinition
 	def.Description = p.parseDescription()
 	def.Name = p.parseName()
 	p.expect(lexer.Colon)
@@ -196,9 +190,8 @@ func (p *parser) parseArgumentDef() *ArgumentDefinition {
 	return &def
 }
 
-func (p *parser) parseInputValueDef() *FieldDefinition {
-	var def FieldDefinition
-	def.Position = p.peekPos()
+func (p *parser) parseInputValueDef() *ast.FieldDefinition {
+	var def ast.FieldDefinition
 	def.Description = p.parseDescription()
 	def.Name = p.parseName()
 	p.expect(lexer.Colon)
@@ -210,12 +203,11 @@ func (p *parser) parseInputValueDef() *FieldDefinition {
 	return &def
 }
 
-func (p *parser) parseInterfaceTypeDefinition(description string) *Definition {
+func (p *parser) parseInterfaceTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("interface")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Interface
+	var def ast.Definition
+	def.Kind = ast.INTERFACE
 	def.Description = description
 	def.Name = p.parseName()
 	def.Interfaces = p.parseImplementsInterfaces()
@@ -224,12 +216,11 @@ func (p *parser) parseInterfaceTypeDefinition(description string) *Definition {
 	return &def
 }
 
-func (p *parser) parseUnionTypeDefinition(description string) *Definition {
+func (p *parser) parseUnionTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("union")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Union
+	var def ast.Definition
+	def.Kind = ast.UNION
 	def.Description = description
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
@@ -251,12 +242,11 @@ func (p *parser) parseUnionMemberTypes() []string {
 	return types
 }
 
-func (p *parser) parseEnumTypeDefinition(description string) *Definition {
+func (p *parser) parseEnumTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("enum")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Enum
+	var def ast.Definition
+	def.Kind = ast.ENUM
 	def.Description = description
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
@@ -264,29 +254,27 @@ func (p *parser) parseEnumTypeDefinition(description string) *Definition {
 	return &def
 }
 
-func (p *parser) parseEnumValuesDefinition() EnumValueList {
-	var values EnumValueList
+func (p *parser) parseEnumValuesDefinition() []*ast.EnumValueDefinition {
+	var values []*ast.EnumValueDefinition
 	p.some(lexer.BraceL, lexer.BraceR, func() {
 		values = append(values, p.parseEnumValueDefinition())
 	})
 	return values
 }
 
-func (p *parser) parseEnumValueDefinition() *EnumValueDefinition {
-	return &EnumValueDefinition{
-		Position:    p.peekPos(),
+func (p *parser) parseEnumValueDefinition() *ast.EnumValueDefinition {
+	return &ast.EnumValueDefinition{
 		Description: p.parseDescription(),
 		Name:        p.parseName(),
 		Directives:  p.parseDirectives(true),
 	}
 }
 
-func (p *parser) parseInputObjectTypeDefinition(description string) *Definition {
+func (p *parser) parseInputObjectTypeDefinition(description string) *ast.Definition {
 	p.expectKeyword("input")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = InputObject
+	var def ast.Definition
+	def.Kind = ast.INPUT_OBJECT
 	def.Description = description
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
@@ -294,15 +282,15 @@ func (p *parser) parseInputObjectTypeDefinition(description string) *Definition
 	return &def
 }
 
-func (p *parser) parseInputFieldsDefinition() FieldList {
-	var values FieldList
+func (p *parser) parseInputFieldsDefinition() []*ast.FieldDefinition {
+	var values []*ast.FieldDefinition
 	p.some(lexer.BraceL, lexer.BraceR, func() {
 		values = append(values, p.parseInputValueDef())
 	})
 	return values
 }
 
-func (p *parser) parseTypeSystemExtension(doc *SchemaDocument) {
+func (p *parser) parseTypeSystemExtension(doc *ast.SchemaDocument) {
 	p.expectKeyword("extend")
 
 	switch p.peek().Value {
@@ -325,11 +313,10 @@ func (p *parser) parseTypeSystemExtension(doc *SchemaDocument) {
 	}
 }
 
-func (p *parser) parseSchemaExtension() *SchemaDefinition {
+func (p *parser) parseSchemaExtension() *ast.SchemaDefinition {
 	p.expectKeyword("schema")
 
-	var def SchemaDefinition
-	def.Position = p.peekPos()
+	var def ast.SchemaDefinition
 	def.Directives = p.parseDirectives(true)
 	p.some(lexer.BraceL, lexer.BraceR, func() {
 		def.OperationTypes = append(def.OperationTypes, p.parseOperationTypeDefinition())
@@ -340,12 +327,11 @@ func (p *parser) parseSchemaExtension() *SchemaDefinition {
 	return &def
 }
 
-func (p *parser) parseScalarTypeExtension() *Definition {
+func (p *parser) parseScalarTypeExtension() *ast.Definition {
 	p.expectKeyword("scalar")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Scalar
+	var def ast.Definition
+	def.Kind = ast.SCALAR
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
 	if len(def.Directives) == 0 {
@@ -354,12 +340,11 @@ func (p *parser) parseScalarTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseObjectTypeExtension() *Definition {
+func (p *parser) parseObjectTypeExtension() *ast.Definition {
 	p.expectKeyword("type")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Object
+	var def ast.Definition
+	def.Kind = ast.OBJECT
 	def.Name = p.parseName()
 	def.Interfaces = p.parseImplementsInterfaces()
 	def.Directives = p.parseDirectives(true)
@@ -370,12 +355,11 @@ func (p *parser) parseObjectTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseInterfaceTypeExtension() *Definition {
+func (p *parser) parseInterfaceTypeExtension() *ast.Definition {
 	p.expectKeyword("interface")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Interface
+	var def ast.Definition
+	def.Kind = ast.INTERFACE
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
 	def.Fields = p.parseFieldsDefinition()
@@ -385,12 +369,11 @@ func (p *parser) parseInterfaceTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseUnionTypeExtension() *Definition {
+func (p *parser) parseUnionTypeExtension() *ast.Definition {
 	p.expectKeyword("union")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Union
+	var def ast.Definition
+	def.Kind = ast.UNION
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
 	def.Types = p.parseUnionMemberTypes()
@@ -401,12 +384,11 @@ func (p *parser) parseUnionTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseEnumTypeExtension() *Definition {
+func (p *parser) parseEnumTypeExtension() *ast.Definition {
 	p.expectKeyword("enum")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = Enum
+	var def ast.Definition
+	def.Kind = ast.ENUM
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(true)
 	def.EnumValues = p.parseEnumValuesDefinition()
@@ -416,12 +398,11 @@ func (p *parser) parseEnumTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseInputObjectTypeExtension() *Definition {
+func (p *parser) parseInputObjectTypeExtension() *ast.Definition {
 	p.expectKeyword("input")
 
-	var def Definition
-	def.Position = p.peekPos()
-	def.Kind = InputObject
+	var def ast.Definition
+	def.Kind = ast.INPUT_OBJECT
 	def.Name = p.parseName()
 	def.Directives = p.parseDirectives(false)
 	def.Fields = p.parseInputFieldsDefinition()
@@ -431,12 +412,11 @@ func (p *parser) parseInputObjectTypeExtension() *Definition {
 	return &def
 }
 
-func (p *parser) parseDirectiveDefinition(description string) *DirectiveDefinition {
+func (p *parser) parseDirectiveDefinition(description string) *ast.DirectiveDefinition {
 	p.expectKeyword("directive")
 	p.expect(lexer.At)
 
-	var def DirectiveDefinition
-	def.Position = p.peekPos()
+	var def ast.DirectiveDefinition
 	def.Description = description
 	def.Name = p.parseName()
 	def.Arguments = p.parseArgumentDefs()
@@ -451,10 +431,10 @@ func (p *parser) parseDirectiveDefinition(description string) *DirectiveDefiniti
 	return &def
 }
 
-func (p *parser) parseDirectiveLocations() []DirectiveLocation {
+func (p *parser) parseDirectiveLocations() []ast.DirectiveLocation {
 	p.skip(lexer.Pipe)
 
-	locations := []DirectiveLocation{p.parseDirectiveLocation()}
+	locations := []ast.DirectiveLocation{p.parseDirectiveLocation()}
 
 	for p.skip(lexer.Pipe) && p.err == nil {
 		locations = append(locations, p.parseDirectiveLocation())
@@ -463,48 +443,48 @@ func (p *parser) parseDirectiveLocations() []DirectiveLocation {
 	return locations
 }
 
-func (p *parser) parseDirectiveLocation() DirectiveLocation {
+func (p *parser) parseDirectiveLocation() ast.DirectiveLocation {
 	name := p.expect(lexer.Name)
 
 	switch name.Value {
 	case `QUERY`:
-		return LocationQuery
+		return ast.LocationQuery
 	case `MUTATION`:
-		return LocationMutation
+		return ast.LocationMutation
 	case `SUBSCRIPTION`:
-		return LocationSubscription
+		return ast.LocationSubscription
 	case `FIELD`:
-		return LocationField
+		return ast.LocationField
 	case `FRAGMENT_DEFINITION`:
-		return LocationFragmentDefinition
+		return ast.LocationFragmentDefinition
 	case `FRAGMENT_SPREAD`:
-		return LocationFragmentSpread
+		return ast.LocationFragmentSpread
 	case `INLINE_FRAGMENT`:
-		return LocationInlineFragment
+		return ast.LocationInlineFragment
 	case `VARIABLE_DEFINITION`:
-		return LocationVariableDefinition
+		return ast.LocationVariableDefinition
 	case `SCHEMA`:
-		return LocationSchema
+		return ast.LocationSchema
 	case `SCALAR`:
-		return LocationScalar
+		return ast.LocationScalar
 	case `OBJECT`:
-		return LocationObject
+		return ast.LocationObject
 	case `FIELD_DEFINITION`:
-		return LocationFieldDefinition
+		return ast.LocationFieldDefinition
 	case `ARGUMENT_DEFINITION`:
-		return LocationArgumentDefinition
+		return ast.LocationArgumentDefinition
 	case `INTERFACE`:
-		return LocationInterface
+		return ast.LocationInterface
 	case `UNION`:
-		return LocationUnion
+		return ast.LocationUnion
 	case `ENUM`:
-		return LocationEnum
+		return ast.LocationEnum
 	case `ENUM_VALUE`:
-		return LocationEnumValue
+		return ast.LocationEnumValue
 	case `INPUT_OBJECT`:
-		return LocationInputObject
+		return ast.LocationInputObject
 	case `INPUT_FIELD_DEFINITION`:
-		return LocationInputFieldDefinition
+		return ast.LocationInputFieldDefinition
 	}
 
 	p.unexpectedToken(name)
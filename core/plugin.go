@@ -1,7 +1,15 @@
 package core
 
+import "context"
+
 type FS interface {
 	Get(path string) (data []byte, err error)
 	Put(path string, data []byte) error
 	Exists(path string) (exists bool, err error)
 }
+
+// Listener receives the notifications sent with pg_notify on a channel
+type Listener interface {
+	// Listen blocks and calls fn with the payload of every notification until the context is done
+	Listen(ctx context.Context, channel string, fn func(payload string)) error
+}
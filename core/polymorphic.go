@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ichaly/tiny-go/core/ast"
+	"strconv"
+)
+
+// virtualTable returns the polymorphic relationship of the table selected by the field name
+func (my *compiler) virtualTable(t *DBTable, name string) (*VirtualTable, bool) {
+	for _, vt := range my.info.VTables {
+		if my.conf.getName(vt.Name, true) == name {
+			return my.info.GetVirtualTable(t, vt.Name)
+		}
+	}
+	return nil, false
+}
+
+// typeTable returns the readable table of the graphql type name
+func (my *builder) typeTable(name string) (*DBTable, bool) {
+	for k, t := range my.tables {
+		if my.conf.getName(t.Name) == name {
+			return my.table(k, true)
+		}
+	}
+	return nil, false
+}
+
+// newUnion builds the polymorphic selection `subject { ... on users { id } ... on posts { id } }`,
+// every inline fragment becomes a member that is joined when the type column names its table
+func (my *builder) newUnion(f *ast.Field, vt *VirtualTable, parent *selection) (*selection, error) {
+	if len(f.Arguments) != 0 {
+		return nil, fmt.Errorf("arguments are not supported on the union '%s'", f.Name)
+	}
+	u := &selection{id: my.seq, name: responseKey(f), parent: parent, singular: true}
+	my.seq++
+
+	var (
+		names  []string
+		shared []ast.Selection
+		sets   = make(map[string][]ast.Selection)
+	)
+	add := func(name string, v ast.Selection) {
+		if _, ok := sets[name]; !ok {
+			names = append(names, name)
+		}
+		sets[name] = append(sets[name], v)
+	}
+	for _, v := range f.SelectionSet {
+		switch v := v.(type) {
+		case *ast.Field:
+			if v.Name != "__typename" {
+				return nil, fmt.Errorf("cannot query field '%s' on the union '%s', use an inline fragment", v.Name, f.Name)
+			}
+			shared = append(shared, v)
+		case *ast.InlineFragment:
+			if v.TypeCondition == "" {
+				return nil, fmt.Errorf("inline fragments on the union '%s' require a type condition", f.Name)
+			}
+			add(v.TypeCondition, v)
+		case *ast.FragmentSpread:
+			fd := my.fragment(v.Name)
+			if fd == nil {
+				return nil, fmt.Errorf("unknown fragment '%s'", v.Name)
+			}
+			add(fd.TypeCondition, v)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("the union '%s' requires inline fragments on its types", f.Name)
+	}
+
+	for _, name := range names {
+		t, ok := my.typeTable(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown type '%s' on the union '%s'", name, f.Name)
+		}
+		rel, err := my.info.GetPolymorphic(vt, t, parent.table)
+		if err != nil {
+			return nil, err
+		}
+		s := &selection{id: my.seq, name: name, table: t, parent: parent, rel: rel, singular: true}
+		my.seq++
+		if err = my.buildSelection(s, nil, append(shared, sets[name]...)); err != nil {
+			return nil, err
+		}
+		u.members = append(u.members, s)
+	}
+	return u, nil
+}
+
+// renderUnion picks the object of the member whose table is named in the type column:
+// SELECT coalesce(__sj_2.json, __sj_3.json) AS json FROM (SELECT true) AS __un_1 LEFT OUTER JOIN LATERAL (...) AS __sj_2 ON true ...
+func (my *renderer) renderUnion(s *selection) error {
+	my.WriteString(`SELECT coalesce(`)
+	for i, m := range s.members {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		// a tagged object is the pair of the member index and the object: [1, {...}]
+		if my.tagUnions {
+			my.WriteString(`CASE WHEN `)
+			my.quote(sjAlias(m))
+			my.WriteString(`."json" IS NOT NULL THEN json_build_array(` + strconv.Itoa(i) + `, `)
+			my.quote(sjAlias(m))
+			my.WriteString(`."json") END`)
+			continue
+		}
+		my.quote(sjAlias(m))
+		my.WriteString(`."json"`)
+	}
+	my.WriteString(`) AS "json" FROM (SELECT true) AS `)
+	my.quote(fmt.Sprintf("__un_%d", s.id))
+	for _, m := range s.members {
+		if err := my.renderLateral(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// newPolymorphicInfo returns the config of a polymorphic table and the database info it applies to
+func newPolymorphicInfo() (*Config, *DBInfo) {
+	conf := &Config{Tables: []TableConfig{{
+		Name:    "subject",
+		Type:    tablePolymorphic,
+		Columns: []Column{{Name: "subject_id", ForeignKey: "subject_type.id"}},
+		Members: []string{"posts", "users"},
+	}}}
+	di := newTestInfo()
+	addTestTable(di, "notifications",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "subject_type", Type: "text"},
+		DBColumn{Name: "subject_id", Type: "bigint"},
+	)
+	return conf, di
+}
+
+func TestCompilePolymorphic(t *testing.T) {
+	conf, di := newPolymorphicInfo()
+	c := newTestCompiler(t, conf, di)
+	q, err := compileQuery(t, c, `
+	query {
+		notifications {
+			id
+			subject {
+				__typename
+				... on users { email }
+				...post
+			}
+		}
+	}
+	fragment post on posts { title }`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := c.render(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`'subject', "__sj_1"."json"`,
+		`LEFT OUTER JOIN LATERAL (SELECT coalesce("__sj_2"."json", "__sj_3"."json") AS "json" FROM (SELECT true) AS "__un_1"`,
+		`SELECT json_build_object('__typename', 'users', 'email', "users_2"."email") AS "json"`,
+		`WHERE "users_2"."id" = "notifications_0"."subject_id" AND "notifications_0"."subject_type" = 'users' LIMIT 1`,
+		`SELECT json_build_object('__typename', 'posts', 'title', "posts_3"."title") AS "json"`,
+		`WHERE "posts_3"."id" = "notifications_0"."subject_id" AND "notifications_0"."subject_type" = 'posts' LIMIT 1`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+	if tables := queryTables(q); len(tables) != 3 {
+		t.Errorf("expected the member tables to be watched, got %v", tables)
+	}
+
+	s := c.schema("")
+	union := s.Types["subject"]
+	// notifications has an id column too, but only the members are possible types
+	if union.Kind != TK_UNION || len(union.PossibleTypes) != 2 ||
+		union.PossibleTypes[0].Name != "posts" || union.PossibleTypes[1].Name != "users" {
+		t.Errorf("unexpected union %+v", union)
+	}
+	var found bool
+	for _, f := range s.Types["notifications"].Fields {
+		found = found || (f.Name == "subject" && f.Type.Name == "subject")
+	}
+	if !found {
+		t.Error("expected the subject field on notifications")
+	}
+}
+
+func TestCompilePolymorphicErrors(t *testing.T) {
+	conf, di := newPolymorphicInfo()
+	c := newTestCompiler(t, conf, di)
+	for _, gql := range []string{
+		`{ notifications { subject { id } } }`,
+		`{ notifications { subject { ... on unknown { id } } } }`,
+		`{ notifications { subject(id: 1) { ... on users { id } } } }`,
+		`{ notifications { subject } }`,
+		`{ users { subject { ... on posts { id } } } }`,
+		`{ notifications { subject { ... on notifications { id } } } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+
+	for _, tc := range []TableConfig{
+		{Name: "subject", Type: tablePolymorphic, Members: []string{"users"},
+			Columns: []Column{{Name: "subject_id", ForeignKey: "users.id.x"}}},
+		{Name: "subject", Type: tablePolymorphic, Columns: []Column{{Name: "subject_id", ForeignKey: "subject_type.id"}}},
+	} {
+		if err := newTestInfo().applyConfig(&Config{Tables: []TableConfig{tc}}); err == nil {
+			t.Errorf("expected an error for the polymorphic config %+v", tc)
+		}
+	}
+}
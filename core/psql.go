@@ -0,0 +1,586 @@
+package core
+
+import (
+	"fmt"
+	"github.com/bytedance/sonic"
+	"strconv"
+	"strings"
+)
+
+// statement is the rendered sql and its positional arguments
+type statement struct {
+	sql  string
+	args []interface{}
+}
+
+// renderer writes postgres sql, every value is bound as a positional argument
+type renderer struct {
+	strings.Builder
+	args []interface{}
+	// mutated are the mutations of every table changed by the statement
+	mutated map[*DBTable][]*mutation
+	// tagUnions adds the member index to the objects of the unions, the response is walked with it
+	tagUnions bool
+}
+
+func (my *compiler) render(q *query) (*statement, error) {
+	r := &renderer{}
+	if err := r.renderQuery(q); err != nil {
+		return nil, err
+	}
+	return &statement{sql: r.String(), args: r.args}, nil
+}
+
+// renderQuery builds the whole response in a single json row:
+// SELECT json_build_object('users', __sj_0.json, ...) AS __root FROM (SELECT true) AS __root_x LEFT OUTER JOIN LATERAL (...) AS __sj_0 ON true
+func (my *renderer) renderQuery(q *query) error {
+	my.tagUnions = q.cursor || q.resolve
+	if err := my.renderMutations(q); err != nil {
+		return err
+	}
+	my.WriteString(`SELECT json_build_object(`)
+	for i, f := range q.fields {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.literal(f.name)
+		my.WriteString(`, `)
+		if err := my.renderField(nil, f); err != nil {
+			return err
+		}
+	}
+	my.WriteString(`) AS "__root" FROM (SELECT true) AS "__root_x"`)
+	for _, f := range q.fields {
+		if f.kind != kindSelect {
+			continue
+		}
+		if err := my.renderLateral(f.child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *renderer) renderLateral(s *selection) error {
+	my.WriteString(` LEFT OUTER JOIN LATERAL (`)
+	if err := my.renderSelect(s); err != nil {
+		return err
+	}
+	my.WriteString(`) AS `)
+	my.quote(sjAlias(s))
+	my.WriteString(` ON true`)
+	return nil
+}
+
+// renderSelect produces a single `json` column, an object for singular selections and an array otherwise
+func (my *renderer) renderSelect(s *selection) error {
+	if s.table == nil {
+		return my.renderUnion(s)
+	}
+	if !s.singular {
+		my.WriteString(`SELECT coalesce(json_agg(`)
+		my.quote(sjAlias(s))
+		my.WriteString(`."json"`)
+		if s.paging != nil && s.paging.last {
+			my.renderCursorOrder(s, s.orders)
+		}
+		my.WriteString(`), '[]') AS "json"`)
+		if s.paging != nil && s.paging.cursor {
+			my.renderCursor(s)
+		}
+		my.WriteString(` FROM (`)
+	}
+
+	my.WriteString(`SELECT json_build_object(`)
+	for i, f := range s.fields {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.literal(f.name)
+		my.WriteString(`, `)
+		if err := my.renderField(s, f); err != nil {
+			return err
+		}
+	}
+	my.WriteString(`) AS "json"`)
+	if s.paging != nil {
+		for i, o := range s.orders {
+			my.WriteString(`, `)
+			my.column(s, o.column)
+			my.WriteString(` AS "__cur_` + strconv.Itoa(i) + `"`)
+		}
+	}
+	my.WriteString(` FROM (`)
+	if err := my.renderBase(s); err != nil {
+		return err
+	}
+	my.WriteString(`) AS `)
+	my.quote(tableAlias(s))
+
+	for _, f := range s.fields {
+		if f.kind != kindSelect && f.kind != kindUnion {
+			continue
+		}
+		if err := my.renderLateral(f.child); err != nil {
+			return err
+		}
+	}
+
+	if !s.singular {
+		my.WriteString(`) AS `)
+		my.quote(sjAlias(s))
+	}
+	return nil
+}
+
+// renderCursor selects the order values of the last fetched row as the cursor of the list,
+// the cursor field is encrypted before the response is sent
+func (my *renderer) renderCursor(s *selection) {
+	my.WriteString(`, (array_agg(json_build_array(`)
+	for i := range s.orders {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.quote(sjAlias(s))
+		my.WriteString(`."__cur_` + strconv.Itoa(i) + `"`)
+	}
+	my.WriteString(`)::text`)
+	orders := s.orders
+	if s.paging.last {
+		orders = reverseOrders(orders)
+	}
+	my.renderCursorOrder(s, orders)
+	my.WriteString(`))[count(*)] AS "cursor"`)
+}
+
+// renderCursorOrder orders an aggregate by the selected order values
+func (my *renderer) renderCursorOrder(s *selection, orders []*order) {
+	my.WriteString(` ORDER BY `)
+	for i, o := range orders {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.quote(sjAlias(s))
+		my.WriteString(`."__cur_` + strconv.Itoa(i) + `" ` + o.dir)
+	}
+}
+
+func (my *renderer) renderField(s *selection, f *field) error {
+	switch f.kind {
+	case kindTypename:
+		my.literal(f.value)
+		return nil
+	case kindSelect, kindUnion:
+		my.quote(sjAlias(f.child))
+		my.WriteString(`."json"`)
+		return nil
+	case kindFunc:
+		my.quote(tableAlias(s))
+		my.WriteString(`.`)
+		my.quote(funcAlias(f))
+		return nil
+	case kindSearch:
+		my.renderSearchField(s, f)
+		return nil
+	case kindResolver:
+		my.renderResolver(s, f)
+		return nil
+	case kindAgg:
+		my.quote(tableAlias(s))
+		my.WriteString(`.`)
+		my.quote(aggAlias(f))
+		return nil
+	case kindJSON:
+		my.bind(f.value)
+		my.WriteString(`::json`)
+		return nil
+	case kindCursor:
+		my.quote(sjAlias(f.child))
+		my.WriteString(`."cursor"`)
+		return nil
+	}
+
+	switch {
+	case f.includeIf != nil:
+		my.WriteString(`(CASE WHEN `)
+		if err := my.renderExp(s, f.includeIf); err != nil {
+			return err
+		}
+		my.WriteString(` THEN `)
+		my.column(s, f.column)
+		my.WriteString(` ELSE null END)`)
+	case f.skipIf != nil:
+		my.WriteString(`(CASE WHEN `)
+		if err := my.renderExp(s, f.skipIf); err != nil {
+			return err
+		}
+		my.WriteString(` THEN null ELSE `)
+		my.column(s, f.column)
+		my.WriteString(` END)`)
+	default:
+		my.column(s, f.column)
+	}
+	return nil
+}
+
+// renderBase selects the matching rows of the table, joined onto the parent row by the relation
+func (my *renderer) renderBase(s *selection) error {
+	my.WriteString(`SELECT `)
+	if len(s.distinct) != 0 {
+		my.WriteString(`DISTINCT ON (`)
+		for i, c := range s.distinct {
+			if i != 0 {
+				my.WriteString(`, `)
+			}
+			my.column(s, c)
+		}
+		my.WriteString(`) `)
+	}
+	if s.grouped {
+		my.renderAggregates(s)
+	} else {
+		my.quote(tableAlias(s))
+		my.WriteString(`.*`)
+		my.renderRowFunctions(s)
+	}
+	my.WriteString(` FROM `)
+	switch {
+	case s.mutation != nil:
+		my.quote(muAlias(s))
+		my.WriteString(` AS `)
+		my.quote(tableAlias(s))
+	case s.fn != nil:
+		if err := my.renderFunction(s); err != nil {
+			return err
+		}
+	case s.rel != nil && s.rel.Type == RelRecursive:
+		my.renderRecursive(s)
+	case len(my.mutated[s.table]) != 0:
+		my.renderMutated(s)
+	default:
+		my.table(s)
+	}
+	if s.rel != nil && s.rel.Type == RelManyToMany {
+		my.renderThrough(s)
+	}
+
+	join := s.rel != nil && s.rel.Type != RelEmbedded && s.rel.Type != RelRecursive
+	if join || s.where != nil {
+		my.WriteString(` WHERE `)
+	}
+	if join {
+		my.renderRel(s)
+		if s.where != nil {
+			my.WriteString(` AND `)
+		}
+	}
+	if s.where != nil {
+		if err := my.renderExp(s, s.where); err != nil {
+			return err
+		}
+	}
+	if s.grouped {
+		my.renderGroupBy(s)
+	}
+
+	orders := s.orders
+	if s.paging != nil && s.paging.last {
+		orders = reverseOrders(orders)
+	}
+	// the rows of a recursive relation are ordered from the nearest level unless sorted
+	if len(orders) == 0 && s.rel != nil && s.rel.Type == RelRecursive {
+		orders = []*order{{column: DBColumn{Name: "__rdepth"}, dir: "ASC"}}
+	}
+	for i := len(s.distinct) - 1; i >= 0; i-- {
+		if !hasOrder(orders, s.distinct[i]) {
+			orders = append([]*order{{column: s.distinct[i], dir: "ASC"}}, orders...)
+		}
+	}
+	// searched rows are ordered by their rank after the requested orders
+	rank := s.search != nil && !s.grouped
+	if len(orders) != 0 || rank {
+		my.WriteString(` ORDER BY `)
+		for i, o := range orders {
+			if i != 0 {
+				my.WriteString(`, `)
+			}
+			my.column(s, o.column)
+			my.WriteString(` `)
+			my.WriteString(o.dir)
+		}
+		if rank {
+			if len(orders) != 0 {
+				my.WriteString(`, `)
+			}
+			my.renderRank(s)
+			my.WriteString(` DESC`)
+		}
+	}
+
+	if s.limit > 0 {
+		my.WriteString(` LIMIT `)
+		my.WriteString(strconv.Itoa(s.limit))
+	}
+	if s.offset > 0 {
+		my.WriteString(` OFFSET `)
+		my.WriteString(strconv.Itoa(s.offset))
+	}
+	return nil
+}
+
+// recordset expands the json array of the parent row into the rows of the json table:
+// jsonb_to_recordset("users_0"."category_counts") AS "category_counts_1"("category_id" bigint, ...)
+func (my *renderer) recordset(s *selection) {
+	my.WriteString(s.table.Type)
+	my.WriteString(`_to_recordset(`)
+	my.quote(tableAlias(s.parent))
+	my.WriteString(`.`)
+	my.quote(s.rel.Right.Name)
+	my.WriteString(`) AS `)
+	my.quote(tableAlias(s))
+	my.WriteString(`(`)
+	for i, c := range s.table.SortedColumns() {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.quote(c.Name)
+		my.WriteString(` `)
+		my.WriteString(c.Type)
+		if c.Array && !strings.HasSuffix(c.Type, "]") {
+			my.WriteString(`[]`)
+		}
+	}
+	my.WriteString(`)`)
+}
+
+// renderRel joins the row onto the parent row, a foreign key of array type matches any of its keys
+func (my *renderer) renderRel(s *selection) {
+	switch {
+	case s.rel.Type == RelManyToMany:
+		my.quote(throughAlias(s))
+		my.WriteString(`.`)
+		my.quote(s.rel.ThroughRight.Name)
+		my.WriteString(` = `)
+		my.quote(tableAlias(s.parent))
+		my.WriteString(`.`)
+		my.quote(s.rel.Right.Name)
+	case s.rel.Left.Array:
+		my.quote(tableAlias(s.parent))
+		my.WriteString(`.`)
+		my.quote(s.rel.Right.Name)
+		my.WriteString(` = ANY(`)
+		my.column(s, s.rel.Left)
+		my.WriteString(`)`)
+	case s.rel.Right.Array:
+		my.column(s, s.rel.Left)
+		my.WriteString(` = ANY(`)
+		my.quote(tableAlias(s.parent))
+		my.WriteString(`.`)
+		my.quote(s.rel.Right.Name)
+		my.WriteString(`)`)
+	default:
+		my.column(s, s.rel.Left)
+		my.WriteString(` = `)
+		my.quote(tableAlias(s.parent))
+		my.WriteString(`.`)
+		my.quote(s.rel.Right.Name)
+	}
+	if s.rel.Type == RelPolymorphic {
+		my.WriteString(` AND `)
+		my.quote(tableAlias(s.parent))
+		my.WriteString(`.`)
+		my.quote(s.rel.Kind.Name)
+		my.WriteString(` = `)
+		my.literal(s.table.Name)
+	}
+}
+
+func (my *renderer) renderExp(s *selection, e *exp) error {
+	switch e.op {
+	case opAnd, opOr:
+		// no condition keeps every row of an and, and none of an or
+		if len(e.children) == 0 {
+			my.WriteString(strconv.FormatBool(e.op == opAnd))
+			return nil
+		}
+		sep := ` AND `
+		if e.op == opOr {
+			sep = ` OR `
+		}
+		my.WriteString(`(`)
+		for i, c := range e.children {
+			if i != 0 {
+				my.WriteString(sep)
+			}
+			if err := my.renderExp(s, c); err != nil {
+				return err
+			}
+		}
+		my.WriteString(`)`)
+		return nil
+	case opNot:
+		my.WriteString(`NOT `)
+		return my.renderExp(s, e.children[0])
+	case opIn, opNotIn:
+		// an empty list matches no row, and every row when it is negated
+		if list, ok := e.value.([]interface{}); ok && len(list) == 0 && !e.column.Array {
+			my.WriteString(strconv.FormatBool(e.op == opNotIn))
+			return nil
+		}
+		if e.op == opNotIn && e.column.Array {
+			my.WriteString(`NOT `)
+		}
+	}
+
+	my.WriteString(`(`)
+	my.column(s, e.column)
+	switch e.op {
+	case opEquals:
+		my.WriteString(` = `)
+	case opNotEquals:
+		my.WriteString(` <> `)
+	case opGreaterThan:
+		my.WriteString(` > `)
+	case opLesserThan:
+		my.WriteString(` < `)
+	case opGreaterOrEquals:
+		my.WriteString(` >= `)
+	case opLesserOrEquals:
+		my.WriteString(` <= `)
+	case opLike:
+		my.WriteString(` LIKE `)
+	case opNotLike:
+		my.WriteString(` NOT LIKE `)
+	case opILike:
+		my.WriteString(` ILIKE `)
+	case opNotILike:
+		my.WriteString(` NOT ILIKE `)
+	case opSimilar:
+		my.WriteString(` SIMILAR TO `)
+	case opNotSimilar:
+		my.WriteString(` NOT SIMILAR TO `)
+	case opRegex:
+		my.WriteString(` ~ `)
+	case opNotRegex:
+		my.WriteString(` !~ `)
+	case opIRegex:
+		my.WriteString(` ~* `)
+	case opNotIRegex:
+		my.WriteString(` !~* `)
+	case opIsNull:
+		if v, _ := e.value.(bool); v {
+			my.WriteString(` IS NULL)`)
+		} else {
+			my.WriteString(` IS NOT NULL)`)
+		}
+		return nil
+	case opIn, opNotIn:
+		// array columns match when they overlap with the list
+		switch {
+		case e.column.Array:
+			my.WriteString(` && ARRAY[`)
+		case e.op == opIn:
+			my.WriteString(` IN (`)
+		default:
+			my.WriteString(` NOT IN (`)
+		}
+		my.bindList(e.value)
+		if e.column.Array {
+			// the cast types the list when it is empty
+			my.WriteString(`]::`)
+			my.WriteString(e.column.Type)
+			my.WriteString(`)`)
+		} else {
+			my.WriteString(`))`)
+		}
+		return nil
+	case opHasKey:
+		my.WriteString(`::jsonb ? `)
+	case opHasKeyAny, opHasKeyAll:
+		if e.op == opHasKeyAny {
+			my.WriteString(`::jsonb ?| ARRAY[`)
+		} else {
+			my.WriteString(`::jsonb ?& ARRAY[`)
+		}
+		my.bindList(e.value)
+		my.WriteString(`]::text[])`)
+		return nil
+	case opSearch:
+		my.WriteString(` @@ websearch_to_tsquery(`)
+		my.bind(e.value)
+		my.WriteString(`))`)
+		return nil
+	case opContains, opContainedIn:
+		if e.op == opContains {
+			my.WriteString(`::jsonb @> `)
+		} else {
+			my.WriteString(`::jsonb <@ `)
+		}
+		data, err := sonic.MarshalString(e.value)
+		if err != nil {
+			return err
+		}
+		my.bind(data)
+		my.WriteString(`::jsonb)`)
+		return nil
+	default:
+		return fmt.Errorf("unsupported operator on column '%s'", e.column.Name)
+	}
+	my.bind(e.value)
+	my.WriteString(`)`)
+	return nil
+}
+
+func (my *renderer) bind(v interface{}) {
+	my.args = append(my.args, normalize(v))
+	my.WriteString(`$`)
+	my.WriteString(strconv.Itoa(len(my.args)))
+}
+
+func (my *renderer) bindList(v interface{}) {
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	for i, v := range list {
+		if i != 0 {
+			my.WriteString(`, `)
+		}
+		my.bind(v)
+	}
+}
+
+func (my *renderer) column(s *selection, c DBColumn) {
+	my.quote(tableAlias(s))
+	my.WriteString(`.`)
+	my.quote(c.Name)
+}
+
+func (my *renderer) quote(id string) {
+	my.WriteString(`"`)
+	my.WriteString(strings.ReplaceAll(id, `"`, `""`))
+	my.WriteString(`"`)
+}
+
+func (my *renderer) literal(val string) {
+	my.WriteString(`'`)
+	my.WriteString(strings.ReplaceAll(val, `'`, `''`))
+	my.WriteString(`'`)
+}
+
+func hasOrder(orders []*order, c DBColumn) bool {
+	for _, o := range orders {
+		if o.column.Name == c.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func tableAlias(s *selection) string {
+	return fmt.Sprintf("%s_%d", s.table.Name, s.id)
+}
+
+func sjAlias(s *selection) string {
+	return fmt.Sprintf("__sj_%d", s.id)
+}
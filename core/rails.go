@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	railsAuthSalt   = "authenticated encrypted cookie"
+	railsIterations = 1000
+	railsWardenKey  = "warden.user.user.key"
+)
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// railsAuth decrypts the AES-256-GCM session cookie written by rails 5.2 and later,
+// the user id is read from the devise session or the `user_id` of the session
+type railsAuth struct {
+	cookie string
+	aead   cipher.AEAD
+	now    func() time.Time
+}
+
+func newRailsAuth(conf *Config) (*railsAuth, error) {
+	c := conf.Auth.Rails
+	if conf.Auth.Cookie == "" {
+		return nil, errors.New("rails auth requires a cookie name")
+	}
+	if c.SecretKeyBase == "" {
+		return nil, errors.New("rails auth requires a secret_key_base")
+	}
+	if c.URL != "" {
+		return nil, errors.New("rails auth does not support remote cookie stores")
+	}
+
+	// rails 7 derives the keys with sha256, the earlier versions use sha1
+	digest := sha1.New
+	if c.Version != "" {
+		v, err := strconv.ParseFloat(c.Version, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rails version '%s'", c.Version)
+		}
+		if v < 5.2 {
+			return nil, fmt.Errorf("rails version '%s' is not supported, use 5.2 or later", c.Version)
+		}
+		if v >= 7 {
+			digest = sha256.New
+		}
+	}
+	salt := c.AuthSalt
+	if salt == "" {
+		salt = railsAuthSalt
+	}
+	return newRailsCipher(conf.Auth.Cookie, c.SecretKeyBase, salt, digest)
+}
+
+func newRailsCipher(cookie, secret, salt string, digest func() hash.Hash) (*railsAuth, error) {
+	key := pbkdf2.Key([]byte(secret), []byte(salt), railsIterations, 32, digest)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &railsAuth{cookie: cookie, aead: aead, now: time.Now}, nil
+}
+
+func (my *railsAuth) Authenticate(r *http.Request) (string, string, error) {
+	c, err := r.Cookie(my.cookie)
+	if err != nil || c.Value == "" {
+		return "", "", nil
+	}
+	session, err := my.decrypt(c.Value)
+	if err != nil {
+		return "", "", err
+	}
+	return railsUserID(session), "", nil
+}
+
+// decrypt opens the cookie `base64(data)--base64(iv)--base64(tag)` and returns the session
+func (my *railsAuth) decrypt(value string) (map[string]interface{}, error) {
+	if v, err := url.QueryUnescape(value); err == nil {
+		value = v
+	}
+	parts := strings.Split(value, "--")
+	if len(parts) != 3 {
+		return nil, errInvalidCookie
+	}
+	var raw [3][]byte
+	for i, p := range parts {
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			return nil, errInvalidCookie
+		}
+		raw[i] = b
+	}
+	data, iv, tag := raw[0], raw[1], raw[2]
+	if len(iv) != my.aead.NonceSize() || len(tag) != my.aead.Overhead() {
+		return nil, errInvalidCookie
+	}
+	plain, err := my.aead.Open(nil, iv, append(data, tag...), nil)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+
+	var msg map[string]json.RawMessage
+	if err = json.Unmarshal(plain, &msg); err != nil {
+		return nil, errInvalidCookie
+	}
+	// the metadata envelope carries the purpose and the expiry of the message
+	if env, ok := msg["_rails"]; ok {
+		if plain, err = my.unwrap(env); err != nil {
+			return nil, err
+		}
+	}
+	var session map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(plain))
+	dec.UseNumber()
+	if err = dec.Decode(&session); err != nil {
+		return nil, errInvalidCookie
+	}
+	return session, nil
+}
+
+// unwrap checks the envelope of the message, rails 5.2 encodes the message as base64 and rails 7.1 embeds it
+func (my *railsAuth) unwrap(data json.RawMessage) ([]byte, error) {
+	var env struct {
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+		Exp     *time.Time      `json:"exp"`
+		Pur     string          `json:"pur"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, errInvalidCookie
+	}
+	if env.Pur != "" && env.Pur != "cookie."+my.cookie {
+		return nil, errInvalidCookie
+	}
+	if env.Exp != nil && !my.now().Before(*env.Exp) {
+		return nil, errors.New("session cookie is expired")
+	}
+	if len(env.Data) != 0 {
+		return env.Data, nil
+	}
+	msg, err := base64.StdEncoding.DecodeString(env.Message)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return msg, nil
+}
+
+// railsUserID reads the devise key `[[id], salt]` and falls back to the `user_id` of the session
+func railsUserID(session map[string]interface{}) string {
+	if v, ok := session[railsWardenKey].([]interface{}); ok && len(v) != 0 {
+		if ids, ok := v[0].([]interface{}); ok && len(ids) != 0 {
+			return fmt.Sprint(ids[0])
+		}
+	}
+	if v, ok := session["user_id"]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
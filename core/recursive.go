@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	findChildren = "children"
+	findParents  = "parents"
+)
+
+// parseFind reads the `find` argument of a selection of the table of its parent
+func (my *builder) parseFind(s *selection, v interface{}) error {
+	if s.rel == nil || s.rel.Type != RelRecursive {
+		return fmt.Errorf("unknown argument 'find' on field '%s'", s.name)
+	}
+	switch v {
+	case findChildren, findParents:
+		s.find = v.(string)
+	default:
+		return fmt.Errorf("argument 'find': unknown value '%v'", v)
+	}
+	return nil
+}
+
+// parseDepth reads the `depth` argument that stops walking the tree after as many levels
+func (my *builder) parseDepth(s *selection, v interface{}) (err error) {
+	if s.rel == nil || s.rel.Type != RelRecursive {
+		return fmt.Errorf("unknown argument 'depth' on field '%s'", s.name)
+	}
+	if s.depth, err = toInt(v); err != nil {
+		return fmt.Errorf("argument 'depth': %w", err)
+	}
+	if s.depth < 1 {
+		return fmt.Errorf("argument 'depth': expected a positive number")
+	}
+	return nil
+}
+
+// finishFind requires the direction of a recursive selection
+func (my *builder) finishFind(s *selection) error {
+	if s.rel != nil && s.rel.Type == RelRecursive && s.find == "" {
+		return fmt.Errorf("field '%s' requires the argument 'find'", s.name)
+	}
+	return nil
+}
+
+// renderRecursive walks the self-referencing foreign key from the parent row, the keys on the path
+// of every row stop the walk at cycles, the path starts with the parent row so a cycle never returns it:
+// (WITH RECURSIVE "__rcte_1" AS (SELECT ... UNION ALL SELECT ...) SELECT * FROM "__rcte_1") AS "comments_1"
+func (my *renderer) renderRecursive(s *selection) {
+	fk, key := s.rel.Left, s.rel.Right
+	cte, row := fmt.Sprintf("__rcte_%d", s.id), fmt.Sprintf("__rt_%d", s.id)
+	col := func(alias string, c DBColumn) {
+		my.quote(alias)
+		my.WriteString(`.`)
+		my.quote(c.Name)
+	}
+	// children match the key of the row above them, parents match the foreign key of the row below them
+	step := func(above string) {
+		if s.find == findChildren {
+			col(row, fk)
+			my.WriteString(` = `)
+			col(above, key)
+		} else {
+			col(row, key)
+			my.WriteString(` = `)
+			col(above, fk)
+		}
+	}
+	from := func() {
+		my.quote(s.table.Schema)
+		my.WriteString(`.`)
+		my.quote(s.table.sourceName())
+		my.WriteString(` AS `)
+		my.quote(row)
+	}
+
+	parent := tableAlias(s.parent)
+
+	my.WriteString(`(WITH RECURSIVE `)
+	my.quote(cte)
+	my.WriteString(` AS (SELECT `)
+	my.quote(row)
+	my.WriteString(`.*, ARRAY[`)
+	col(parent, key)
+	my.WriteString(`, `)
+	col(row, key)
+	my.WriteString(`] AS "__rpath", 1 AS "__rdepth" FROM `)
+	from()
+	my.WriteString(` WHERE `)
+	step(parent)
+	my.WriteString(` AND `)
+	col(row, key)
+	my.WriteString(` <> `)
+	col(parent, key)
+	my.WriteString(` UNION ALL SELECT `)
+	my.quote(row)
+	my.WriteString(`.*, `)
+	my.quote(cte)
+	my.WriteString(`."__rpath" || `)
+	col(row, key)
+	my.WriteString(`, `)
+	my.quote(cte)
+	my.WriteString(`."__rdepth" + 1 FROM `)
+	from()
+	my.WriteString(` INNER JOIN `)
+	my.quote(cte)
+	my.WriteString(` ON `)
+	step(cte)
+	my.WriteString(` WHERE NOT `)
+	col(row, key)
+	my.WriteString(` = ANY(`)
+	my.quote(cte)
+	my.WriteString(`."__rpath")`)
+	if s.depth > 0 {
+		my.WriteString(` AND `)
+		my.quote(cte)
+		my.WriteString(`."__rdepth" < `)
+		my.WriteString(strconv.Itoa(s.depth))
+	}
+	my.WriteString(`) SELECT * FROM `)
+	my.quote(cte)
+	my.WriteString(`) AS `)
+	my.quote(tableAlias(s))
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func newRecursiveInfo() *DBInfo {
+	di := newTestInfo()
+	addTestTable(di, "comments",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "body", Type: "text"},
+		DBColumn{Name: "reply_to_id", Type: "bigint", FKeyTable: "comments", FKeyCol: "id"},
+	)
+	return di
+}
+
+func TestCompileRecursive(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newRecursiveInfo())
+	st, err := compileSession(t, c, `{
+		comments(id: 1) {
+			id
+			replies: comments(find: children, depth: 3) { id body }
+			thread: comments(find: parents, sort: {id: asc}) { id }
+		}
+	}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`FROM (WITH RECURSIVE "__rcte_1" AS (SELECT "__rt_1".*, ARRAY["comments_0"."id", "__rt_1"."id"] AS "__rpath", 1 AS "__rdepth" FROM "public"."comments" AS "__rt_1" ` +
+			`WHERE "__rt_1"."reply_to_id" = "comments_0"."id" AND "__rt_1"."id" <> "comments_0"."id"`,
+		`UNION ALL SELECT "__rt_1".*, "__rcte_1"."__rpath" || "__rt_1"."id", "__rcte_1"."__rdepth" + 1 FROM "public"."comments" AS "__rt_1" INNER JOIN "__rcte_1" ON "__rt_1"."reply_to_id" = "__rcte_1"."id" WHERE NOT "__rt_1"."id" = ANY("__rcte_1"."__rpath") AND "__rcte_1"."__rdepth" < 3) SELECT * FROM "__rcte_1") AS "comments_1" ORDER BY "comments_1"."__rdepth" ASC`,
+		`WHERE "__rt_2"."id" = "comments_0"."reply_to_id" AND "__rt_2"."id" <> "comments_0"."id" UNION ALL`,
+		`ON "__rt_2"."id" = "__rcte_2"."reply_to_id" WHERE NOT "__rt_2"."id" = ANY("__rcte_2"."__rpath")) SELECT * FROM "__rcte_2") AS "comments_2" ORDER BY "comments_2"."id" ASC`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+
+	var found bool
+	for _, f := range c.schema("").Types["comments"].Fields {
+		if f.Name == "comments" {
+			found = f.Type.Kind == TK_LIST
+		}
+	}
+	if !found {
+		t.Error("expected the recursive field on comments")
+	}
+}
+
+func TestCompileRecursiveCycle(t *testing.T) {
+	// 1 replies to 2 and 2 replies to 1, or 3 replies to itself: the walk from a row never returns that row
+	c := newTestCompiler(t, &Config{}, newRecursiveInfo())
+	st, err := compileSession(t, c, `{ comments(id: 1) { id comments(find: parents) { id } } }`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`ARRAY["comments_0"."id", "__rt_1"."id"] AS "__rpath"`,
+		`WHERE "__rt_1"."id" = "comments_0"."reply_to_id" AND "__rt_1"."id" <> "comments_0"."id" UNION ALL`,
+		`WHERE NOT "__rt_1"."id" = ANY("__rcte_1"."__rpath")`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+}
+
+func TestCompileRecursiveErrors(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newRecursiveInfo())
+	for _, gql := range []string{
+		`{ comments { comments { id } } }`,
+		`{ comments { comments(find: siblings) { id } } }`,
+		`{ comments { comments(find: children, depth: 0) { id } } }`,
+		`{ comments(find: children) { id } }`,
+		`{ users { posts(depth: 2) { id } } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type RelType int8
+
+const (
+	RelNone RelType = iota
+	// RelOneToOne the parent row holds the foreign key, so there is at most one child row
+	RelOneToOne
+	// RelOneToMany the child rows hold the foreign key pointing back to the parent row
+	RelOneToMany
+	// RelPolymorphic the parent row holds the foreign key and the name of the table it points to
+	RelPolymorphic
+	// RelEmbedded the child rows are the json array in a column of the parent row
+	RelEmbedded
+	// RelRecursive the rows of the table are walked through its self-referencing foreign key
+	RelRecursive
+	// RelManyToMany the rows are joined through a join table holding a foreign key to each table
+	RelManyToMany
+)
+
+func (my RelType) String() string {
+	switch my {
+	case RelOneToOne:
+		return "one-to-one"
+	case RelOneToMany:
+		return "one-to-many"
+	case RelPolymorphic:
+		return "polymorphic"
+	case RelEmbedded:
+		return "embedded"
+	case RelRecursive:
+		return "recursive"
+	case RelManyToMany:
+		return "many-to-many"
+	}
+	return "none"
+}
+
+// DBRel describes how a child table joins onto its parent table
+type DBRel struct {
+	Type  RelType
+	Left  DBColumn // column on the child table
+	Right DBColumn // column on the parent table
+	// Kind is the column of the parent table that holds the name of the child table of a polymorphic relation
+	Kind DBColumn
+	// Through is the join table of a many-to-many relation, its foreign keys point to Left and Right
+	Through      *DBTable
+	ThroughLeft  DBColumn
+	ThroughRight DBColumn
+}
+
+func (my *DBRel) String() string {
+	return fmt.Sprintf("%s.%s = %s.%s (%s)", my.Left.Table, my.Left.Name, my.Right.Table, my.Right.Name, my.Type)
+}
+
+// GetPolymorphic joins the child table onto the parent row of the polymorphic relation
+func (my *DBInfo) GetPolymorphic(vt *VirtualTable, child, parent *DBTable) (*DBRel, error) {
+	if !vt.hasMember(child.Name) {
+		return nil, fmt.Errorf("table '%s' is not a member of '%s'", child.Name, vt.Name)
+	}
+	id, ok := child.GetColumn(vt.IDColumn)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' has no column '%s' for '%s'", child.Name, vt.IDColumn, vt.Name)
+	}
+	fk, _ := parent.GetColumn(vt.FKeyColumn)
+	kind, _ := parent.GetColumn(vt.TypeColumn)
+	return &DBRel{Type: RelPolymorphic, Left: id, Right: fk, Kind: kind}, nil
+}
+
+// GetRecursive finds the self-referencing foreign key of the table, Left holds the foreign key and Right the key
+func (my *DBInfo) GetRecursive(t *DBTable) (*DBRel, error) {
+	for _, c := range t.SortedColumns() {
+		if !c.FKRecursive || c.Array {
+			continue
+		}
+		if key, ok := t.GetColumn(c.FKeyCol); ok {
+			return &DBRel{Type: RelRecursive, Left: c, Right: key}, nil
+		}
+	}
+	return nil, fmt.Errorf("no recursive relationship found on '%s'", t.Name)
+}
+
+// GetRelation finds the foreign key that joins the child table onto the parent table,
+// the child is looked up first so that `users { posts }` prefers posts.user_id
+func (my *DBInfo) GetRelation(child, parent *DBTable) (*DBRel, error) {
+	// the rows of a json table only exist inside the row of its table
+	if child.embedded() {
+		if c, ok := parent.GetColumn(child.Name); ok && !parent.embedded() && child.Source == parent.sourceName() {
+			return &DBRel{Type: RelEmbedded, Right: c}, nil
+		}
+		return nil, fmt.Errorf("no relationship found between '%s' and '%s'", child.Name, parent.Name)
+	}
+	if _, ok := my.relation.Get(child.sourceName()); ok {
+		var rels []*DBRel
+		for _, c := range child.SortedColumns() {
+			if c.FKRecursive || !isReference(c, parent) {
+				continue
+			}
+			if pc, ok := parent.GetColumn(c.FKeyCol); ok {
+				rel := &DBRel{Type: RelOneToMany, Left: c, Right: pc}
+				if c.UniqueKey && !c.Array {
+					rel.Type = RelOneToOne
+				}
+				rels = append(rels, rel)
+			}
+		}
+		if len(rels) == 0 {
+			for _, c := range parent.SortedColumns() {
+				if c.FKRecursive || !isReference(c, child) {
+					continue
+				}
+				if cc, ok := child.GetColumn(c.FKeyCol); ok {
+					// an array of keys on the parent row points to many child rows
+					rel := &DBRel{Type: RelOneToOne, Left: cc, Right: c}
+					if c.Array {
+						rel.Type = RelOneToMany
+					}
+					rels = append(rels, rel)
+				}
+			}
+		}
+		// with several foreign keys only the relationship fields tell which one is meant
+		if len(rels) > 1 {
+			return nil, fmt.Errorf("relationship between '%s' and '%s' is ambiguous, select one of its relationship fields", child.Name, parent.Name)
+		}
+		if len(rels) == 1 {
+			return rels[0], nil
+		}
+	}
+	return my.GetThrough(child, parent)
+}
+
+func isReference(c DBColumn, t *DBTable) bool {
+	return c.FKeyTable == t.sourceName() && (c.FKeySchema == "" || c.FKeySchema == t.Schema)
+}
+
+// DBRelField is a relationship field of a table, an object for its foreign keys
+// and a list for the foreign keys of other tables pointing to it
+type DBRelField struct {
+	Name  string
+	Table *DBTable
+	Rel   *DBRel
+}
+
+// GetRelFields returns the relationship fields of the table. The field of a foreign key is named after the column
+// without `_id`, `author_id` becomes `author`, and the list of the rows pointing to the table after their table,
+// followed by `_by_<field>` when that table points to it more than once. Names taken by columns are skipped.
+func (my *DBInfo) GetRelFields(t *DBTable) []DBRelField {
+	return my.relFields[t]
+}
+
+// addRelFields computes the relationship fields of every table once the config is applied
+func (my *DBInfo) addRelFields() {
+	my.relFields = make(map[*DBTable][]DBRelField, len(my.Tables))
+	for _, t := range my.Tables {
+		my.relFields[t] = my.findRelFields(t)
+	}
+}
+
+func (my *DBInfo) findRelFields(t *DBTable) []DBRelField {
+	var list []DBRelField
+	add := func(name string, rt *DBTable, rel *DBRel) {
+		if _, ok := t.GetColumn(name); ok || name == "" {
+			return
+		}
+		for _, f := range list {
+			if f.Name == name {
+				return
+			}
+		}
+		list = append(list, DBRelField{Name: name, Table: rt, Rel: rel})
+	}
+
+	for _, c := range t.SortedColumns() {
+		if c.Blocked || c.FKRecursive || c.FKeyTable == "" {
+			continue
+		}
+		rt, ok := my.findTable(c.FKeySchema, c.FKeyTable)
+		if !ok {
+			continue
+		}
+		rc, ok := rt.GetColumn(c.FKeyCol)
+		if !ok {
+			continue
+		}
+		rel := &DBRel{Type: RelOneToOne, Left: rc, Right: c}
+		if c.Array {
+			rel.Type = RelOneToMany
+		}
+		add(fkField(c, rt), rt, rel)
+	}
+
+	keys := make([]string, 0, len(my.Tables))
+	for k := range my.Tables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ct := my.Tables[k]
+		if ct == t || ct.Source != "" || ct.embedded() {
+			continue
+		}
+		var fks []DBColumn
+		for _, c := range ct.SortedColumns() {
+			if !c.Blocked && !c.FKRecursive && isReference(c, t) {
+				fks = append(fks, c)
+			}
+		}
+		for _, c := range fks {
+			pc, ok := t.GetColumn(c.FKeyCol)
+			if !ok {
+				continue
+			}
+			rel := &DBRel{Type: RelOneToMany, Left: c, Right: pc}
+			if c.UniqueKey && !c.Array {
+				rel.Type = RelOneToOne
+			}
+			name := c.FKeyRelated
+			switch {
+			case name != "":
+			case len(fks) == 1:
+				name = ct.Name
+			default:
+				name = ct.Name + "_by_" + fkField(c, t)
+			}
+			add(name, ct, rel)
+		}
+	}
+	return list
+}
+
+// fkField names the field of the foreign key, `author_id` becomes `author` and `user_ids` becomes `users`
+func fkField(c DBColumn, rt *DBTable) string {
+	switch {
+	case c.FKeyField != "":
+		return c.FKeyField
+	case strings.HasSuffix(c.Name, "_id") && len(c.Name) > 3:
+		return strings.TrimSuffix(c.Name, "_id")
+	}
+	return rt.Name
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// newRelFieldInfo returns the config of the relationship field names and the database info it applies to
+func newRelFieldInfo() (*Config, *DBInfo) {
+	di := newTestInfo()
+	addTestTable(di, "messages",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "sender_id", Type: "bigint", FKeyTable: "users", FKeyCol: "id"},
+		DBColumn{Name: "receiver_id", Type: "bigint", FKeyTable: "users", FKeyCol: "id"},
+	)
+	conf := &Config{Tables: []TableConfig{{Name: "messages", Columns: []Column{
+		{Name: "receiver_id", Field: "recipient", RelatedField: "inbox"},
+	}}}}
+	return conf, di
+}
+
+func TestRelFields(t *testing.T) {
+	conf, di := newRelFieldInfo()
+	c := newTestCompiler(t, conf, di)
+	users, _ := c.info.GetTable("public", "users")
+	var names []string
+	for _, f := range c.info.GetRelFields(users) {
+		names = append(names, f.Name+":"+f.Rel.Type.String())
+	}
+	if v := strings.Join(names, ","); v != "inbox:one-to-many,messages_by_sender:one-to-many,posts:one-to-many" {
+		t.Errorf("unexpected fields of users %s", v)
+	}
+
+	st, err := compileSession(t, c, `{
+		messages { sender { full_name } recipient { id } }
+		users { inbox(limit: 2, where: {id: {greaterThan: 1}}) { id } }
+	}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`SELECT json_build_object('full_name', "users_1"."full_name") AS "json" FROM (SELECT "users_1".* FROM "public"."users" AS "users_1" WHERE "users_1"."id" = "messages_0"."sender_id" LIMIT 1)`,
+		`WHERE "users_2"."id" = "messages_0"."receiver_id" LIMIT 1`,
+		`FROM "public"."messages" AS "messages_4" WHERE "messages_4"."receiver_id" = "users_3"."id" AND ("messages_4"."id" > $1) LIMIT 2`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+
+	fields := make(map[string]*__Type)
+	s := c.schema("")
+	for _, op := range []string{"messages", "users"} {
+		for _, f := range s.Types[op].Fields {
+			fields[op+"."+f.Name] = f.Type
+		}
+	}
+	if v := fields["messages.sender"]; v == nil || v.Name != "users" {
+		t.Errorf("expected the object field of the foreign key, got %+v", v)
+	}
+	if v := fields["users.messages_by_sender"]; v == nil || v.Kind != TK_LIST {
+		t.Errorf("expected the list field of the foreign key, got %+v", v)
+	}
+}
+
+func TestRelFieldErrors(t *testing.T) {
+	conf, di := newRelFieldInfo()
+	c := newTestCompiler(t, conf, di)
+	for _, gql := range []string{
+		`{ users { messages { id } } }`,
+		`{ messages { users { id } } }`,
+		`mutation { users(insert: {email: "a", messages: {id: 1}}) { id } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("expected an ambiguous relationship for %s, got %v", gql, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	_log "log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const resolverRemoteAPI = "remote_api"
+
+// remoteMaxBodySize bounds the response body of a remote api unless `max_body_size` is set
+const remoteMaxBodySize = 1 << 20
+
+// remoteAPI calls a url with the value of the column of the row:
+// `{ name: payments, type: remote_api, table: users, column: stripe_id, url: http://api/payments/$id }`
+type remoteAPI struct {
+	url         string
+	jsonPath    []string
+	passHeaders []string
+	setHeaders  map[string]string
+	maxBodySize int64
+	client      *http.Client
+	// log prints every call and its status when the prop `debug` is set
+	log *_log.Logger
+}
+
+// newRemoteAPI reads the props `url`, `json_path`, `pass_headers`, `set_headers`, `max_body_size` and `debug`,
+// the headers to set are a list of `{ name: Authorization, value: Bearer <key> }`
+func newRemoteAPI(props ResolverProps) (Resolver, error) {
+	r := &remoteAPI{
+		setHeaders:  make(map[string]string),
+		maxBodySize: remoteMaxBodySize,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	r.url, _ = props["url"].(string)
+	if r.url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if v, ok := props["json_path"]; ok {
+		path, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("json_path must be a string")
+		}
+		r.jsonPath = splitPath(path)
+	}
+	if v, ok := props["pass_headers"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pass_headers must be a list of header names")
+		}
+		for _, h := range list {
+			r.passHeaders = append(r.passHeaders, fmt.Sprint(h))
+		}
+	}
+	if v, ok := props["set_headers"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("set_headers must be a list of name and value")
+		}
+		for _, h := range list {
+			m, _ := h.(map[string]interface{})
+			name, _ := m["name"].(string)
+			value, ok := m["value"]
+			if name == "" || !ok || len(m) != 2 {
+				return nil, fmt.Errorf("set_headers must be a list of name and value, got %v", h)
+			}
+			r.setHeaders[name] = fmt.Sprint(value)
+		}
+	}
+	if v, ok := props["max_body_size"]; ok {
+		n, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("max_body_size must be a positive number of bytes")
+		}
+		r.maxBodySize = n
+	}
+	if v, ok := props["debug"]; ok {
+		debug, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("debug must be true or false")
+		}
+		if debug {
+			r.log = _log.New(os.Stdout, "", 0)
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the value at the json path of the response
+func (my *remoteAPI) Resolve(ctx context.Context, req ResolverReq) ([]byte, error) {
+	hr, err := http.NewRequestWithContext(ctx, http.MethodGet, my.requestURL(req.ID), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range my.passHeaders {
+		if v := req.Headers.Get(k); v != "" {
+			hr.Header.Set(k, v)
+		}
+	}
+	for k, v := range my.setHeaders {
+		// the host of a request is not sent from its headers
+		if strings.EqualFold(k, "Host") {
+			hr.Host = v
+		} else {
+			hr.Header.Set(k, v)
+		}
+	}
+	res, err := my.client.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if my.log != nil {
+		my.log.Printf("remote_api: GET %s %d", hr.URL, res.StatusCode)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, my.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > my.maxBodySize {
+		return nil, fmt.Errorf("response larger than %d bytes", my.maxBodySize)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return extractPath(body, my.jsonPath)
+}
+
+// requestURL puts the id into the url, escaped for the path or the query string it is in
+func (my *remoteAPI) requestURL(id string) string {
+	path, query, ok := strings.Cut(my.url, "?")
+	path = strings.ReplaceAll(path, "$id", url.PathEscape(id))
+	if !ok {
+		return path
+	}
+	return path + "?" + strings.ReplaceAll(query, "$id", url.QueryEscape(id))
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newRemoteConfig(url string) *Config {
+	return &Config{Resolvers: []ResolverConfig{{
+		Name:      "payments",
+		Type:      resolverRemoteAPI,
+		Table:     "users",
+		Column:    "email",
+		StripPath: "payments",
+		Props: ResolverProps{
+			"url":          url + "/payments/$id",
+			"json_path":    "data",
+			"pass_headers": []interface{}{"X-Token"},
+			"set_headers":  []interface{}{map[string]interface{}{"name": "X-Source", "value": "tiny"}},
+		},
+	}}}
+}
+
+func TestRemoteAPI(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("X-Token") != "secret" || r.Header.Get("X-Source") != "tiny" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/payments/")
+		_, _ = w.Write([]byte(`{"data":{"payments":[{"id":"` + id + `","amount":12345678901234567}]}}`))
+	}))
+	defer srv.Close()
+
+	db, f := openFakeDB(t, jsonReply(`{"users":[{"id":1,"payments":"a@b.c"},{"id":2,"payments":"a@b.c"},{"id":3,"payments":null}]}`))
+	e := newTestEngine(t, newRemoteConfig(srv.URL), db)
+
+	ctx := context.WithValue(context.Background(), HeadersKey, http.Header{"X-Token": {"secret"}})
+	res, err := e.GraphQL(ctx, `{ users { id payments } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := `[{"amount":12345678901234567,"id":"a@b.c"}]`
+	if v := `{"users":[{"id":1,"payments":` + payment + `},{"id":2,"payments":` + payment + `},{"id":3,"payments":null}]}`; string(res.Data) != v {
+		t.Errorf("unexpected data %s", res.Data)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single call for the same value, got %d", calls)
+	}
+	if query, _ := f.last(); !strings.Contains(query, `'payments', "users_0"."email"::text`) {
+		t.Errorf("expected the join value in:\n%s", query)
+	}
+
+	// a failed call only loses its fields, the rest of the response is kept
+	res, err = e.GraphQL(context.Background(), `{ users { id payments } }`, nil, "")
+	if err == nil {
+		t.Error("expected an error when the remote api fails")
+	}
+	if v := `{"users":[{"id":1,"payments":null},{"id":2,"payments":null},{"id":3,"payments":null}]}`; string(res.Data) != v {
+		t.Errorf("unexpected data %s", res.Data)
+	}
+	if len(res.Errors) != 2 || fmt.Sprint(res.Errors[0].Path) != "[users 0 payments]" || fmt.Sprint(res.Errors[1].Path) != "[users 1 payments]" {
+		t.Fatalf("expected an error for each failed field, got %v", res.Errors)
+	}
+	if !strings.Contains(res.Errors[0].Message, "unexpected status 401") {
+		t.Errorf("unexpected error %s", res.Errors[0].Message)
+	}
+
+	var found bool
+	for _, fd := range e.Load().(*kernel).compiler.schema("").Types["users"].Fields {
+		found = found || (fd.Name == "payments" && fd.Type.Name == JSON)
+	}
+	if !found {
+		t.Error("expected the remote field on users")
+	}
+}
+
+func TestRemoteAPIURL(t *testing.T) {
+	r, err := newRemoteAPI(ResolverProps{"url": "http://api/users/$id/payments?user=$id&kind=card"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := r.(*remoteAPI).requestURL("a&b=c d/e")
+	if v != "http://api/users/a&b=c%20d%2Fe/payments?user=a%26b%3Dc+d%2Fe&kind=card" {
+		t.Errorf("unexpected url %s", v)
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("user") != "a&b=c d/e" || u.Query().Get("kind") != "card" {
+		t.Errorf("expected the id in a single query parameter, got %v", u.Query())
+	}
+}
+
+func TestRemoteAPIBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"` + strings.Repeat("x", 20) + `"}`))
+	}))
+	defer srv.Close()
+
+	for size, fails := range map[interface{}]bool{16: true, "64": false} {
+		r, err := newRemoteAPI(ResolverProps{"url": srv.URL + "/$id", "max_body_size": size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Resolve(context.Background(), ResolverReq{ID: "1", Headers: http.Header{}})
+		if (err != nil) != fails {
+			t.Errorf("unexpected error %v for the size %v", err, size)
+		}
+	}
+	for _, size := range []interface{}{0, -1, "big"} {
+		if _, err := newRemoteAPI(ResolverProps{"url": srv.URL, "max_body_size": size}); err == nil {
+			t.Errorf("expected an error for the size %v", size)
+		}
+	}
+}
+
+func TestRemoteAPIConfig(t *testing.T) {
+	conf, err := ReadInConfig("../conf/dev.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Resolvers) != 1 {
+		t.Fatalf("unexpected resolvers %+v", conf.Resolvers)
+	}
+
+	var host, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, auth = r.Host, r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"data":{"id":1}}`))
+	}))
+	defer srv.Close()
+
+	props := conf.Resolvers[0].Props
+	props["url"] = srv.URL + "/payments/$id"
+	r, err := newRemoteAPI(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Resolve(context.Background(), ResolverReq{ID: "1", Headers: http.Header{}}); err != nil {
+		t.Fatal(err)
+	}
+	if host != "0.0.0.0" || auth != "Bearer <stripe_api_key>" {
+		t.Errorf("expected the configured headers, got host %s and authorization %s", host, auth)
+	}
+
+	for _, p := range []ResolverProps{
+		{"set_headers": map[string]interface{}{"X-Source": "tiny"}},
+		{"set_headers": []interface{}{"X-Source"}},
+		{"set_headers": []interface{}{map[string]interface{}{"name": "X-Source"}}},
+		{"pass_headers": "cookie"},
+		{"json_path": 1},
+		{"debug": "yes"},
+	} {
+		p["url"] = srv.URL
+		if _, err = newRemoteAPI(p); err == nil {
+			t.Errorf("expected an error for %v", p)
+		}
+	}
+}
@@ -1,5 +1,16 @@
 package core
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
 type ResolverConfig struct {
 	Name      string
 	Type      string
@@ -11,3 +22,235 @@ type ResolverConfig struct {
 }
 
 type ResolverProps map[string]interface{}
+
+// Resolver backs a field of a table with go code, it is called once for every distinct value
+// of the column of the field in a response and returns the json value of the field
+type Resolver interface {
+	Resolve(ctx context.Context, req ResolverReq) ([]byte, error)
+}
+
+// ResolverReq is the value of the column of the rows, the headers are the headers of the request
+type ResolverReq struct {
+	ID      string
+	Headers http.Header
+}
+
+// ResolverFactory creates the resolver of a resolver config from its props
+type ResolverFactory func(props ResolverProps) (Resolver, error)
+
+const (
+	// resolverConcurrency bounds the resolver calls of a single response running at once
+	resolverConcurrency = 10
+	// resolverMaxCalls bounds the distinct resolver calls of a single response
+	resolverMaxCalls = 100
+)
+
+// HeadersKey is the context key of the request headers, the resolvers receive them
+const HeadersKey contextKey = "headers"
+
+// builtinResolvers are the resolver types known without registering them
+var builtinResolvers = map[string]ResolverFactory{
+	resolverRemoteAPI: newRemoteAPI,
+}
+
+// WithResolver registers the factory of the resolvers of the type, it takes precedence over the builtin types
+func WithResolver(name string, fn ResolverFactory) Option {
+	return func(ke *kernel) error {
+		if ke.factories == nil {
+			ke.factories = make(map[string]ResolverFactory)
+		}
+		ke.factories[name] = fn
+		return nil
+	}
+}
+
+// resolver is a field of a table resolved with the value of a column of the row
+type resolver struct {
+	Resolver
+	name      string
+	table     *DBTable
+	column    DBColumn
+	stripPath []string
+}
+
+// newResolvers creates the resolvers of the configs, the tables and columns must exist
+func newResolvers(conf *Config, info *DBInfo, factories map[string]ResolverFactory) ([]*resolver, error) {
+	var list []*resolver
+	for _, rc := range conf.Resolvers {
+		fn, ok := factories[rc.Type]
+		if !ok {
+			fn, ok = builtinResolvers[rc.Type]
+		}
+		if !ok {
+			return nil, fmt.Errorf("resolver '%s': unknown type '%s'", rc.Name, rc.Type)
+		}
+		t, ok := info.findTable(rc.Schema, rc.Table)
+		if !ok {
+			return nil, fmt.Errorf("resolver '%s': unknown table '%s'", rc.Name, rc.Table)
+		}
+		c, ok := t.GetColumn(rc.Column)
+		if !ok {
+			return nil, fmt.Errorf("resolver '%s': unknown column '%s' on table '%s'", rc.Name, rc.Column, t.Name)
+		}
+		r, err := fn(rc.Props)
+		if err != nil {
+			return nil, fmt.Errorf("resolver '%s': %w", rc.Name, err)
+		}
+		list = append(list, &resolver{Resolver: r, name: rc.Name, table: t, column: c, stripPath: splitPath(rc.StripPath)})
+	}
+	return list, nil
+}
+
+func splitPath(v string) []string {
+	var list []string
+	for _, k := range strings.Split(v, ".") {
+		if k != "" {
+			list = append(list, k)
+		}
+	}
+	return list
+}
+
+// extractPath returns the value at the path of the json object, a missing key is null
+func extractPath(data []byte, path []string) ([]byte, error) {
+	if len(path) == 0 {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("invalid json value")
+		}
+		return bytes.TrimSpace(data), nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid json value: %w", err)
+	}
+	for _, k := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			v = nil
+			break
+		}
+		v = m[k]
+	}
+	return json.Marshal(v)
+}
+
+// resolver returns the index of the resolver of the field of the table
+func (my *compiler) resolver(t *DBTable, name string) (int, bool) {
+	for i, r := range my.resolvers {
+		if r.table == t && my.conf.getName(r.name, true) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// renderResolver renders the column value of the row as text, the response is walked for it afterwards:
+// "users_0"."stripe_id"::text
+func (my *renderer) renderResolver(s *selection, f *field) {
+	my.column(s, f.column)
+	my.WriteString(`::text`)
+}
+
+// resolverCall is a distinct call of a resolver, the id is the column value of the row
+type resolverCall struct {
+	index int
+	id    string
+}
+
+// resolverResult is the value of a resolver call, or the error when the call failed
+type resolverResult struct {
+	data []byte
+	err  error
+}
+
+// resolveFields calls the resolvers once for every distinct column value of the resolver fields of the response,
+// a failed call is kept in its result so that only its fields are lost
+func (my *compiler) resolveFields(ctx context.Context, q *query, data []byte) (map[resolverCall]resolverResult, error) {
+	headers := requestHeaders(ctx)
+	var (
+		calls   []resolverCall
+		results = make(map[resolverCall]resolverResult)
+	)
+	_, err := rewriteResponse(data, q.fields, func(f *field, _ []interface{}, v json.RawMessage) (json.RawMessage, error) {
+		if f.kind != kindResolver || isNullJSON(v) {
+			return v, nil
+		}
+		call, err := resolverCallOf(f, v)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := results[call]; ok {
+			return v, nil
+		}
+		if len(calls) == resolverMaxCalls {
+			return nil, fmt.Errorf("response requires more than %d resolver calls", resolverMaxCalls)
+		}
+		calls = append(calls, call)
+		results[call] = resolverResult{}
+		return v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		sema = make(chan struct{}, resolverConcurrency)
+	)
+	for _, call := range calls {
+		wg.Add(1)
+		sema <- struct{}{}
+		go func(call resolverCall) {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			res, e := my.callResolver(ctx, headers, call)
+			mu.Lock()
+			defer mu.Unlock()
+			results[call] = resolverResult{data: res, err: e}
+		}(call)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// resolverCallOf reads the call of the resolver field from its column value
+func resolverCallOf(f *field, v json.RawMessage) (resolverCall, error) {
+	i, err := strconv.Atoi(f.value)
+	if err != nil {
+		return resolverCall{}, err
+	}
+	var id string
+	if err = json.Unmarshal(v, &id); err != nil {
+		return resolverCall{}, errInvalidResponse
+	}
+	return resolverCall{index: i, id: id}, nil
+}
+
+// callResolver calls the resolver with the column value and strips its strip path
+func (my *compiler) callResolver(ctx context.Context, headers http.Header, call resolverCall) ([]byte, error) {
+	if call.index < 0 || call.index >= len(my.resolvers) {
+		return nil, fmt.Errorf("unknown resolver %d", call.index)
+	}
+	r := my.resolvers[call.index]
+	data, err := r.Resolve(ctx, ResolverReq{ID: call.id, Headers: headers})
+	if err != nil {
+		return nil, fmt.Errorf("resolver '%s': %w", r.name, err)
+	}
+	if data, err = extractPath(data, r.stripPath); err != nil {
+		return nil, fmt.Errorf("resolver '%s': %w", r.name, err)
+	}
+	return data, nil
+}
+
+// requestHeaders returns the request headers stored in the context
+func requestHeaders(ctx context.Context) http.Header {
+	if h, ok := ctx.Value(HeadersKey).(http.Header); ok {
+		return h
+	}
+	return http.Header{}
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cacheResolver answers from an in-memory map
+type cacheResolver struct {
+	prefix string
+	values map[string]string
+}
+
+func (my *cacheResolver) Resolve(_ context.Context, req ResolverReq) ([]byte, error) {
+	v, ok := my.values[req.ID]
+	if !ok {
+		return nil, errors.New("not cached")
+	}
+	return []byte(`{"value":"` + my.prefix + v + `"}`), nil
+}
+
+func TestCustomResolver(t *testing.T) {
+	conf := &Config{Resolvers: []ResolverConfig{{
+		Name: "profile", Type: "cache", Table: "users", Column: "email", StripPath: "value",
+		Props: ResolverProps{"prefix": "p:"},
+	}}}
+	db, _ := openFakeDB(t, jsonReply(`{"users":[{"id":1,"email":"__gj_rr:0:a@b.c","profile":"a@b.c"}]}`))
+	e := &Engine{done: make(chan bool)}
+	err := e.newKernel(conf, db, newTestInfo(), nil, WithResolver("cache", func(props ResolverProps) (Resolver, error) {
+		prefix, _ := props["prefix"].(string)
+		return &cacheResolver{prefix: prefix, values: map[string]string{"a@b.c": "alice"}}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the resolver field is resolved, a column value is never looked into
+	res, err := e.GraphQL(context.Background(), `{ users { id email profile } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `{"users":[{"id":1,"email":"__gj_rr:0:a@b.c","profile":"p:alice"}]}` {
+		t.Errorf("unexpected data %s", res.Data)
+	}
+
+	c := e.Load().(*kernel).compiler
+	q, err := compileQuery(t, c, `{ users { id profile } }`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for i := 0; i <= resolverMaxCalls; i++ {
+		rows = append(rows, `{"id":`+strconv.Itoa(i)+`,"profile":"`+strconv.Itoa(i)+`"}`)
+	}
+	data := []byte(`{"users":[` + strings.Join(rows, ",") + `]}`)
+	if _, _, err = c.finishResponse(context.Background(), q, data); err == nil || !strings.Contains(err.Error(), "resolver calls") {
+		t.Errorf("expected an error for too many resolver calls, got %v", err)
+	}
+}
+
+func TestResolverConfig(t *testing.T) {
+	failing := map[string]ResolverFactory{"failing": func(ResolverProps) (Resolver, error) {
+		return nil, errors.New("no connection")
+	}}
+	for _, rc := range []ResolverConfig{
+		{Name: "a", Type: "graphql", Table: "users", Column: "email"},
+		{Name: "a", Type: "failing", Table: "users", Column: "email"},
+		{Name: "a", Type: resolverRemoteAPI, Table: "missing", Column: "email", Props: ResolverProps{"url": "http://x"}},
+		{Name: "a", Type: resolverRemoteAPI, Table: "users", Column: "missing", Props: ResolverProps{"url": "http://x"}},
+		{Name: "a", Type: resolverRemoteAPI, Table: "users", Column: "email"},
+	} {
+		_, err := newResolvers(&Config{Resolvers: []ResolverConfig{rc}}, newTestInfo(), failing)
+		if err == nil || !strings.HasPrefix(err.Error(), "resolver 'a'") {
+			t.Errorf("expected an error for %+v, got %v", rc, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+)
+
+var errInvalidResponse = errors.New("invalid response")
+
+// finishResponse encrypts the cursors of the response and replaces the resolver fields with their values,
+// both are done in one walk since the walk removes the member tags of the unions.
+// A failed resolver call only sets its fields to null, their errors are returned with the path of each field.
+func (my *compiler) finishResponse(ctx context.Context, q *query, data []byte) ([]byte, _lexer.List, error) {
+	if q.cursor && my.cipher == nil {
+		return nil, nil, errCursorNoSecret
+	}
+	var resolved map[resolverCall]resolverResult
+	if q.resolve {
+		var err error
+		if resolved, err = my.resolveFields(ctx, q, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	var errs _lexer.List
+	data, err := rewriteResponse(data, q.fields, func(f *field, path []interface{}, v json.RawMessage) (json.RawMessage, error) {
+		if f.kind == kindCursor {
+			return my.encryptCursor(f.child, v)
+		}
+		if isNullJSON(v) {
+			return v, nil
+		}
+		call, err := resolverCallOf(f, v)
+		if err != nil {
+			return nil, err
+		}
+		res := resolved[call]
+		if res.err != nil {
+			e := _lexer.WrapError(res.err)
+			e.Path = append([]interface{}(nil), path...)
+			errs = append(errs, e)
+			return json.RawMessage(`null`), nil
+		}
+		return res.data, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, errs, nil
+}
+
+// rewriteFunc returns the new value of a cursor or resolver field of the response,
+// the path is the keys and list indexes leading to the field
+type rewriteFunc func(f *field, path []interface{}, v json.RawMessage) (json.RawMessage, error)
+
+// rewriteResponse walks the response along the fields of the query and replaces the values of the cursor
+// and resolver fields, the values of all other fields are copied as they are and never looked into
+func rewriteResponse(data []byte, fields []*field, fn rewriteFunc) ([]byte, error) {
+	return rewriteObject(data, fields, nil, fn)
+}
+
+func rewriteValue(f *field, path []interface{}, v json.RawMessage, fn rewriteFunc) (json.RawMessage, error) {
+	switch f.kind {
+	case kindCursor, kindResolver:
+		return fn(f, path, v)
+	case kindUnion:
+		return rewriteUnion(f.child, v, path, fn)
+	case kindSelect:
+		if f.child.singular {
+			return rewriteObject(v, f.child.fields, path, fn)
+		}
+		return rewriteList(v, f.child.fields, path, fn)
+	}
+	return v, nil
+}
+
+// rewriteObject keeps the order of the keys of the object
+func rewriteObject(data json.RawMessage, fields []*field, path []interface{}, fn rewriteFunc) (json.RawMessage, error) {
+	if isNullJSON(data) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errInvalidResponse
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; dec.More(); i++ {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var v json.RawMessage
+		if err = dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			if f.name == key {
+				if v, err = rewriteValue(f, append(path, key), v, fn); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func rewriteList(data json.RawMessage, fields []*field, path []interface{}, fn rewriteFunc) (json.RawMessage, error) {
+	if isNullJSON(data) {
+		return data, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errInvalidResponse
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, v := range list {
+		v, err := rewriteObject(v, fields, append(path, i), fn)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// rewriteUnion reads the object of a union tagged with the index of its member, `[1, {...}]`
+func rewriteUnion(u *selection, data json.RawMessage, path []interface{}, fn rewriteFunc) (json.RawMessage, error) {
+	if isNullJSON(data) {
+		return data, nil
+	}
+	var (
+		i   int
+		obj json.RawMessage
+	)
+	tagged := []interface{}{&i, &obj}
+	if err := json.Unmarshal(data, &tagged); err != nil || i < 0 || i >= len(u.members) {
+		return nil, errInvalidResponse
+	}
+	return rewriteObject(obj, u.members[i].fields, path, fn)
+}
+
+func isNullJSON(v json.RawMessage) bool {
+	return len(v) == 0 || bytes.Equal(bytes.TrimSpace(v), []byte("null"))
+}
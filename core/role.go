@@ -0,0 +1,328 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/ichaly/tiny-go/core/ast"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+	"github.com/ichaly/tiny-go/core/parser"
+	"golang.org/x/exp/slices"
+	"regexp"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	// UserIDKey is the context key of the id of the authenticated user
+	UserIDKey contextKey = "user_id"
+	// UserRoleKey is the context key of the role, it takes precedence over the roles query
+	UserRoleKey contextKey = "user_role"
+)
+
+const (
+	roleAnon = "anon"
+	roleUser = "user"
+)
+
+type ruleKind int8
+
+const (
+	ruleQuery ruleKind = iota
+	ruleInsert
+	ruleUpdate
+	ruleUpsert
+	ruleDelete
+)
+
+// session holds the trusted values of a request, filters and presets read their variables from it
+type session struct {
+	role string
+	vars map[string]interface{}
+}
+
+// rule is the config of an operation on a table merged with the config of the role
+type rule struct {
+	block   bool
+	limit   int
+	columns []string
+	filters []*ast.Value
+	presets map[string]string
+	// noFuncs hides the functions of the table and noAgg its aggregates
+	noFuncs bool
+	noAgg   bool
+}
+
+// filterOps maps the operators used in the filters of the config to the operators of the where input
+var filterOps = map[string]string{
+	"eq":           "equals",
+	"neq":          "notEquals",
+	"gt":           "greaterThan",
+	"lt":           "lesserThan",
+	"gte":          "greaterOrEquals",
+	"lte":          "lesserOrEquals",
+	"nlike":        "notLike",
+	"ilike":        "iLike",
+	"nilike":       "notILike",
+	"nsimilar":     "notSimilar",
+	"nregex":       "notRegex",
+	"iregex":       "iRegex",
+	"niregex":      "notIRegex",
+	"nin":          "notIn",
+	"is_null":      "isNull",
+	"has_key":      "hasKey",
+	"has_key_any":  "hasKeyAny",
+	"has_key_all":  "hasKeyAll",
+	"contained_in": "containedIn",
+}
+
+var userIDVar = regexp.MustCompile(`\$user_id(?:::?(\w+))?`)
+
+// newRules merges the table configs with the tables of every role, they are keyed by role, table and kind
+func newRules(conf *Config) (map[string]*rule, error) {
+	rules := make(map[string]*rule)
+	// with authentication the anonymous role only reaches the tables it lists
+	if t := conf.Auth.Type; t != "" && t != authNone {
+		for k := ruleQuery; k <= ruleDelete; k++ {
+			rules[ruleKey(roleAnon, "", k)] = &rule{block: true}
+		}
+	}
+	for _, tc := range conf.Tables {
+		for k := ruleQuery; k <= ruleDelete; k++ {
+			r, err := newRule(&rule{}, k, tc.Query, tc.Insert, tc.Update, tc.Upsert, tc.Delete)
+			if err != nil {
+				return nil, fmt.Errorf("table '%s': %w", tc.Name, err)
+			}
+			rules[ruleKey("", tc.Name, k)] = r
+		}
+	}
+	for _, rc := range conf.Roles {
+		for _, rt := range rc.Tables {
+			for k := ruleQuery; k <= ruleDelete; k++ {
+				base := &rule{}
+				if v, ok := rules[ruleKey("", rt.Name, k)]; ok {
+					base = v
+				}
+				r, err := newRule(base, k, rt.Query, rt.Insert, rt.Update, rt.Upsert, rt.Delete)
+				if err != nil {
+					return nil, fmt.Errorf("role '%s' table '%s': %w", rc.Name, rt.Name, err)
+				}
+				r.block = r.block || rt.Block || (rt.ReadOnly && k != ruleQuery)
+				rules[ruleKey(rc.Name, rt.Name, k)] = r
+			}
+		}
+	}
+	return rules, nil
+}
+
+func newRule(
+	base *rule, kind ruleKind, q *QueryConfig, i *InsertConfig, u *UpdateConfig, s *UpsertConfig, d *DeleteConfig,
+) (*rule, error) {
+	var (
+		block   bool
+		limit   int
+		columns []string
+		filters []string
+		presets map[string]string
+		noFuncs bool
+		noAgg   bool
+	)
+	switch kind {
+	case ruleQuery:
+		if q != nil {
+			block, limit, columns, filters = q.Block, q.Limit, q.Columns, q.Filters
+			noFuncs, noAgg = q.DisableFunctions, q.DisableAgg
+		}
+	case ruleInsert:
+		if i != nil {
+			block, columns, filters, presets = i.Block, i.Columns, i.Filters, i.Presets
+		}
+	case ruleUpdate:
+		if u != nil {
+			block, columns, filters, presets = u.Block, u.Columns, u.Filters, u.Presets
+		}
+	case ruleUpsert:
+		if s != nil {
+			block, columns, filters, presets = s.Block, s.Columns, s.Filters, s.Presets
+		}
+	case ruleDelete:
+		if d != nil {
+			block, columns, filters = d.Block, d.Columns, d.Filters
+		}
+	}
+
+	r := &rule{block: base.block || block, limit: base.limit, columns: base.columns, noFuncs: base.noFuncs || noFuncs}
+	r.noAgg = base.noAgg || noAgg
+	if limit > 0 {
+		r.limit = limit
+	}
+	if len(columns) != 0 {
+		r.columns = columns
+	}
+	r.filters = append(r.filters, base.filters...)
+	for _, f := range filters {
+		v, err := parser.ParseValue(&_lexer.Input{Content: f})
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter '%s': %w", f, err)
+		}
+		r.filters = append(r.filters, v)
+	}
+	r.presets = make(map[string]string, len(base.presets)+len(presets))
+	for k, v := range base.presets {
+		r.presets[k] = v
+	}
+	for k, v := range presets {
+		r.presets[k] = v
+	}
+	return r, nil
+}
+
+func ruleKey(role, table string, kind ruleKind) string {
+	return fmt.Sprintf("%s:%s:%d", role, table, kind)
+}
+
+func (my mutateKind) rule() ruleKind {
+	switch my {
+	case mutInsert:
+		return ruleInsert
+	case mutUpsert:
+		return ruleUpsert
+	case mutDelete:
+		return ruleDelete
+	}
+	return ruleUpdate
+}
+
+// allows reports whether the column is in the column list of the rule, an empty list allows all columns
+func (my *rule) allows(c DBColumn) bool {
+	return len(my.columns) == 0 || slices.Contains(my.columns, c.Name)
+}
+
+// lookupRule returns the rule of the role, tables not listed by the role use the default of the role
+// and then the table config
+func lookupRule(rules map[string]*rule, role string, t *DBTable, kind ruleKind) *rule {
+	if r, ok := rules[ruleKey(role, t.Name, kind)]; ok {
+		return r
+	}
+	if r, ok := rules[ruleKey(role, "", kind)]; ok {
+		return r
+	}
+	if r, ok := rules[ruleKey("", t.Name, kind)]; ok {
+		return r
+	}
+	return &rule{}
+}
+
+// rule returns the rule of the role of the request
+func (my *builder) rule(t *DBTable, kind ruleKind) *rule {
+	return lookupRule(my.rules, my.session.role, t, kind)
+}
+
+// filter resolves the filters of the rule against the session into a where expression
+func (my *builder) filter(t *DBTable, kind ruleKind) (*exp, error) {
+	var res *exp
+	for _, f := range my.rule(t, kind).filters {
+		v, err := valueOf(f, my.session.vars)
+		if err != nil {
+			return nil, fmt.Errorf("filter on '%s': %w", t.Name, err)
+		}
+		// the filters of the config may use every column
+		e, err := my.parseExp(t, my.filterValue(t, v), &rule{})
+		if err != nil {
+			return nil, fmt.Errorf("filter on '%s': %w", t.Name, err)
+		}
+		res = and(res, e)
+	}
+	return res, nil
+}
+
+// filterValue renames the columns and operators of a filter to the names used by the where input
+func (my *builder) filterValue(t *DBTable, v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			name := strings.TrimPrefix(k, "_")
+			if _, ok := my.column(t, k); ok {
+				name = k
+			} else if c, ok := t.GetColumn(name); ok && !c.Blocked {
+				name = my.conf.getName(c.Name, true)
+			} else if op, ok := filterOps[name]; ok {
+				name = op
+			}
+			res[name] = my.filterValue(t, item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, item := range val {
+			res[i] = my.filterValue(t, item)
+		}
+		return res
+	}
+	return v
+}
+
+// session reads the user and the role of the request from the context,
+// without a role the roles query decides between the roles that define a match
+func (my *kernel) session(ctx context.Context) (*session, error) {
+	s := &session{vars: make(map[string]interface{})}
+	uid := ctx.Value(UserIDKey)
+	if uid != nil {
+		s.vars["user_id"] = uid
+	}
+
+	if v, ok := ctx.Value(UserRoleKey).(string); ok && v != "" {
+		s.role = v
+	} else if uid == nil {
+		s.role = roleAnon
+	} else if st := my.rolesStatement(); st != "" {
+		err := my.db.QueryRowContext(ctx, st, uid).Scan(&s.role)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.role = roleAnon
+		} else if err != nil {
+			return nil, fmt.Errorf("error fetching the role: %w", err)
+		}
+	} else {
+		s.role = roleUser
+	}
+	s.vars["user_role"] = s.role
+	return s, nil
+}
+
+// rolesStatement evaluates the match of the roles against the row of the roles query:
+// SELECT (CASE WHEN <match> THEN '<role>' ... ELSE 'user' END) FROM (<roles_query>) AS "__roles" LIMIT 1
+func (my *kernel) rolesStatement() string {
+	if my.conf.RolesQuery == "" {
+		return ""
+	}
+	r := &renderer{}
+	r.WriteString(`SELECT (CASE`)
+	var matched bool
+	for _, rc := range my.conf.Roles {
+		if rc.Match == "" {
+			continue
+		}
+		matched = true
+		r.WriteString(` WHEN `)
+		r.WriteString(rc.Match)
+		r.WriteString(` THEN `)
+		r.literal(rc.Name)
+	}
+	if !matched {
+		return ""
+	}
+	r.WriteString(` ELSE `)
+	r.literal(roleUser)
+	r.WriteString(` END) FROM (`)
+	r.WriteString(userIDVar.ReplaceAllStringFunc(my.conf.RolesQuery, func(v string) string {
+		if m := userIDVar.FindStringSubmatch(v); m[1] != "" {
+			return "$1::" + m[1]
+		}
+		return "$1"
+	}))
+	r.WriteString(`) AS "__roles" LIMIT 1`)
+	return r.String()
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/spf13/afero"
+	"strings"
+	"testing"
+)
+
+func newTestRoles() *Config {
+	return &Config{
+		Tables: []TableConfig{{Name: "posts", Query: &QueryConfig{Limit: 5}}},
+		Roles: []RoleConfig{
+			{Name: "anon", Tables: []RoleTable{{Name: "users", Block: true}}},
+			{Name: "user", Tables: []RoleTable{
+				{Name: "users", Query: &QueryConfig{
+					Columns: []string{"id", "email"},
+					Filters: []string{`{ id: { _eq: $user_id } }`},
+				}},
+				{Name: "posts",
+					Insert: &InsertConfig{Presets: map[string]string{"user_id": "$user_id"}},
+					Update: &UpdateConfig{Filters: []string{`{ user_id: { eq: $user_id } }`}},
+				},
+			}},
+			{Name: "viewer", Tables: []RoleTable{{Name: "posts", ReadOnly: true}}},
+		},
+	}
+}
+
+func TestCompileRoles(t *testing.T) {
+	user := &session{role: "user", vars: map[string]interface{}{"user_id": 7}}
+	tests := []struct {
+		name     string
+		sess     *session
+		gql      string
+		expected []string
+		args     []interface{}
+	}{
+		{
+			name:     "filters",
+			sess:     user,
+			gql:      `{ users(where: {email: {equals: "a"}}) { id } }`,
+			expected: []string{`"users_0"."email" = $1`, `"users_0"."id" = $2`},
+			args:     []interface{}{"a", 7},
+		},
+		{
+			name:     "table config",
+			sess:     user,
+			gql:      `{ posts { id } }`,
+			expected: []string{`LIMIT 5`},
+		},
+		{
+			name:     "max limit",
+			sess:     user,
+			gql:      `{ posts(limit: 100) { id } users(limit: 100) { id } }`,
+			expected: []string{`"posts_0" LIMIT 5`, `LIMIT 20`},
+		},
+		{
+			name:     "presets",
+			sess:     user,
+			gql:      `mutation { posts(insert: {title: "a", user_id: 1}) { id } }`,
+			expected: []string{`("title", "user_id") VALUES ($1, $2)`},
+			args:     []interface{}{"a", 7},
+		},
+		{
+			name:     "update filters",
+			sess:     user,
+			gql:      `mutation { posts(id: 3, update: {title: "a"}) { id } }`,
+			expected: []string{`"posts_0"."id" = $2`, `"posts_0"."user_id" = $3`},
+			args:     []interface{}{"a", int64(3), 7},
+		},
+		{
+			name:     "other roles",
+			sess:     &session{role: "admin"},
+			gql:      `{ users { full_name } }`,
+			expected: []string{`'full_name', "users_0"."full_name"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := compileSession(t, newTestCompiler(t, newTestRoles(), nil), tt.gql, nil, tt.sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range tt.expected {
+				if !strings.Contains(st.sql, v) {
+					t.Errorf("expected %s in:\n%s", v, st.sql)
+				}
+			}
+			for i, v := range tt.args {
+				if i >= len(st.args) || st.args[i] != v {
+					t.Errorf("expected %v in args %v", v, st.args)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileRoleErrors(t *testing.T) {
+	c := newTestCompiler(t, newTestRoles(), nil)
+	for _, v := range []struct {
+		role, gql string
+	}{
+		{"anon", `{ users { id } }`},
+		{"anon", `{ posts { id users { id } } }`},
+		{"anon", `mutation { users(insert: {email: "x"}) { id } }`},
+		{"user", `{ users { full_name } }`},
+		{"user", `{ users(where: {full_name: {equals: "a"}}) { id } }`},
+		{"user", `{ users(sort: {full_name: asc}) { id } }`},
+		{"viewer", `mutation { posts(insert: {title: "x"}) { id } }`},
+		{"viewer", `mutation { posts(id: 1, delete: true) { id } }`},
+	} {
+		if _, err := compileSession(t, c, v.gql, nil, &session{role: v.role}); err == nil {
+			t.Errorf("expected an error for %s as %s", v.gql, v.role)
+		}
+	}
+
+	conf := &Config{Roles: []RoleConfig{{Name: "user", Tables: []RoleTable{
+		{Name: "users", Query: &QueryConfig{Filters: []string{`{ id: `}}},
+	}}}}
+	if _, err := newCompiler(conf, newTestInfo()); err == nil {
+		t.Error("expected an error for an invalid filter")
+	}
+}
+
+func TestSession(t *testing.T) {
+	var role string
+	db, f := openFakeDB(t, func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if role == "" {
+			return []string{"role"}, nil, nil
+		}
+		return []string{"role"}, [][]driver.Value{{role}}, nil
+	})
+	conf := &Config{
+		RolesQuery: `SELECT * FROM users WHERE id = $user_id:bigint`,
+		Roles:      []RoleConfig{{Name: "admin", Match: `id = 1`}, {Name: "user"}},
+	}
+	e := newTestEngine(t, conf, db)
+	ke := e.Load().(*kernel)
+
+	for _, v := range []struct {
+		ctx      context.Context
+		db, role string
+	}{
+		{context.Background(), "", roleAnon},
+		{context.WithValue(context.Background(), UserRoleKey, "viewer"), "", "viewer"},
+		{context.WithValue(context.Background(), UserIDKey, 1), "admin", "admin"},
+		{context.WithValue(context.Background(), UserIDKey, 2), "", roleAnon},
+	} {
+		role = v.db
+		s, err := ke.session(v.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.role != v.role || s.vars["user_role"] != v.role {
+			t.Errorf("expected role %s, got %s", v.role, s.role)
+		}
+	}
+
+	query, args := f.last()
+	expected := `SELECT (CASE WHEN id = 1 THEN 'admin' ELSE 'user' END) ` +
+		`FROM (SELECT * FROM users WHERE id = $1::bigint) AS "__roles" LIMIT 1`
+	if query != expected {
+		t.Errorf("unexpected sql:\n%s\nexpected:\n%s", query, expected)
+	}
+	if len(args) != 1 || args[0].Value != int64(2) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// without a roles query every user has the user role
+	ke.conf = &Config{}
+	s, err := ke.session(context.WithValue(context.Background(), UserIDKey, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.role != roleUser || s.vars["user_id"] != 3 {
+		t.Errorf("unexpected session %+v", s)
+	}
+}
+
+func TestReadRoles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/conf/dev.yml", []byte(`
+roles_query: "SELECT * FROM users WHERE id = $user_id"
+roles:
+  - name: user
+    tables:
+      - name: products
+        read_only: true
+        insert:
+          presets:
+            - user_id: "$user_id"
+            - created_at: "now"
+        update:
+          filters: ["{ user_id: { eq: $user_id } }"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := readInConfig("/conf/dev.yml", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RolesQuery == "" || len(conf.Roles) != 1 || len(conf.Roles[0].Tables) != 1 {
+		t.Fatalf("unexpected roles %+v", conf.Roles)
+	}
+	rt := conf.Roles[0].Tables[0]
+	if !rt.ReadOnly || rt.Insert.Presets["user_id"] != "$user_id" || rt.Insert.Presets["created_at"] != "now" {
+		t.Errorf("unexpected table %+v", rt)
+	}
+	if len(rt.Update.Filters) != 1 {
+		t.Errorf("unexpected filters %v", rt.Update.Filters)
+	}
+}
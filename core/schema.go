@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/bytedance/sonic"
-	"github.com/iancoleman/strcase"
+	"sort"
+	"strings"
 )
 
 //
@@ -30,8 +31,10 @@ type __Schema struct {
 	MutationType     *__Type               `json:"mutationType,omitempty"`
 	SubscriptionType *__Type               `json:"subscriptionType,omitempty"`
 
-	conf *Config
-	info *DBInfo
+	conf  *Config
+	info  *DBInfo
+	role  string
+	rules map[string]*rule
 }
 
 type __Type struct {
@@ -177,14 +180,7 @@ const (
 )
 
 func (my *__Schema) getName(name string, isField ...bool) string {
-	if my.conf.EnableCamelcase {
-		if len(isField) > 0 && isField[0] {
-			return strcase.ToLowerCamel(name)
-		}
-		return strcase.ToCamel(name)
-	} else {
-		return name
-	}
+	return my.conf.getName(name, isField...)
 }
 
 func (my *__Schema) addType(types ...__Type) {
@@ -198,12 +194,23 @@ func (my *__Schema) addTypeTo(op string, ot __Type, args []__InputValue) {
 	t.Fields = append(t.Fields, __Field{
 		Name:        my.getName(ot.Name, true),
 		Description: ot.Description,
-		Type:        &__Type{Name: ot.Name},
+		Type:        &__Type{Kind: TK_LIST, OfType: &__Type{Name: ot.Name}},
 		Args:        args,
 	})
 	my.Types[op] = t
 }
 
+// addCursorTo adds the field `<list>_cursor` that returns the cursor of the list selected next to it
+func (my *__Schema) addCursorTo(op string, ot __Type) {
+	t := my.Types[op]
+	t.Fields = append(t.Fields, __Field{
+		Name:        my.getName(ot.Name, true) + SUFFIX_CURSOR,
+		Description: "The cursor of the last row of " + my.getName(ot.Name, true),
+		Type:        &__Type{Name: Cursor},
+	})
+	my.Types[op] = t
+}
+
 func (my *__Schema) addExpression(exps []__InputValue, name string, sub __Type) {
 	t := __Type{
 		Kind:        TK_INPUT_OBJECT,
@@ -221,9 +228,25 @@ func (my *__Schema) addExpression(exps []__InputValue, name string, sub __Type)
 }
 
 func NewSchema(conf *Config, info *DBInfo) (res json.RawMessage, err error) {
-	s := __Schema{
+	return NewRoleSchema(conf, info, "")
+}
+
+// NewRoleSchema only describes the tables, columns and operations the role is allowed to use
+func NewRoleSchema(conf *Config, info *DBInfo, role string) (res json.RawMessage, err error) {
+	rules, err := newRules(conf)
+	if err != nil {
+		return nil, err
+	}
+	root := map[string]interface{}{"data": map[string]interface{}{"__schema": newSchema(conf, info, role, rules)}}
+	return sonic.Marshal(root)
+}
+
+func newSchema(conf *Config, info *DBInfo, role string, rules map[string]*rule) *__Schema {
+	s := &__Schema{
 		conf:             conf,
 		info:             info,
+		role:             role,
+		rules:            rules,
 		Types:            map[string]__Type{},
 		Directives:       map[string]__Directive{},
 		QueryType:        __Type{Name: "Query"},
@@ -234,6 +257,9 @@ func NewSchema(conf *Config, info *DBInfo) (res json.RawMessage, err error) {
 	for _, v := range stdTypes {
 		s.addType(v)
 	}
+	for _, v := range stdDirectives {
+		s.Directives[v.Name] = v
+	}
 
 	// Expression types
 	v := append(expAll, expScalar...)
@@ -258,17 +284,50 @@ func NewSchema(conf *Config, info *DBInfo) (res json.RawMessage, err error) {
 
 	s.addTablesType()
 
-	root := map[string]interface{}{"data": map[string]interface{}{"__schema": s}}
-	return sonic.Marshal(root)
+	// operations without fields are not available to the role
+	if len(s.Types["Mutation"].Fields) == 0 {
+		delete(s.Types, "Mutation")
+		s.MutationType = nil
+	}
+	if len(s.Types["Subscription"].Fields) == 0 {
+		delete(s.Types, "Subscription")
+		s.SubscriptionType = nil
+	}
+
+	return s
+}
+
+func (my *__Schema) rule(t *DBTable, kind ruleKind) *rule {
+	return lookupRule(my.rules, my.role, t, kind)
+}
+
+// hidden reports whether the role can neither read nor change the table
+func (my *__Schema) hidden(t *DBTable) bool {
+	if t.Blocked {
+		return true
+	}
+	for k := ruleQuery; k <= ruleDelete; k++ {
+		if !my.rule(t, k).block {
+			return false
+		}
+	}
+	return true
 }
 
 func (my *__Schema) addTablesType() {
 	var enumValues []__EnumValue
 
 	for _, t := range my.info.Tables {
-		if t.Blocked {
+		if my.hidden(t) {
 			continue
 		}
+		var (
+			canQuery  = my.rule(t, ruleQuery)
+			canInsert = my.rule(t, ruleInsert)
+			canUpdate = my.rule(t, ruleUpdate)
+			canUpsert = my.rule(t, ruleUpsert)
+			canDelete = my.rule(t, ruleDelete)
+		)
 		tableName := my.getName(t.Name)
 		// append tables enum value object type
 		enumValues = append(enumValues, __EnumValue{Name: tableName, Description: t.Comment})
@@ -300,12 +359,6 @@ func (my *__Schema) addTablesType() {
 			Kind: TK_INPUT_OBJECT,
 			Name: tableName + SUFFIX_INSERT,
 		}
-		for _, f := range my.info.relation[t.Name] {
-			name := my.getName(f) + SUFFIX_UPDATE
-			insert.InputFields = append(insert.InputFields, __InputValue{
-				Name: f, Type: &__Type{Name: name},
-			})
-		}
 		update := __Type{
 			Kind: TK_INPUT_OBJECT,
 			Name: tableName + SUFFIX_UPDATE,
@@ -321,6 +374,16 @@ func (my *__Schema) addTablesType() {
 			}},
 		}
 
+		// nested mutations of the related tables, named like the relation fields of the object
+		for _, f := range my.info.relation[t.Name] {
+			if rt, ok := my.info.GetTable(t.Schema, f); ok && my.hidden(rt) {
+				continue
+			}
+			iv := __InputValue{Name: my.getName(my.getName(f), true), Type: &__Type{Name: my.getName(f) + SUFFIX_UPDATE}}
+			insert.InputFields = append(insert.InputFields, iv)
+			update.InputFields = append(update.InputFields, iv)
+		}
+
 		// table object type
 		object := __Type{
 			Kind:        TK_OBJECT,
@@ -342,6 +405,40 @@ func (my *__Schema) addTablesType() {
 			cn, isList := my.getColumnType(c)
 			columnName := my.getName(c.Name, true)
 
+			// append table object field
+			ct := __Type{Name: cn}
+			if c.Array || isList {
+				ct = __Type{Kind: TK_LIST, OfType: &__Type{
+					Name: cn,
+				}}
+			}
+			if c.NotNull {
+				ct = __Type{Kind: TK_NON_NULL, OfType: &__Type{
+					Name: cn,
+				}}
+			}
+
+			if canUpsert.allows(c) {
+				upsert.InputFields = append(upsert.InputFields, __InputValue{
+					Name: columnName, Type: &ct,
+				})
+			}
+			if canInsert.allows(c) {
+				insert.InputFields = append(insert.InputFields, __InputValue{
+					Name: columnName, Type: &ct,
+				})
+			}
+			if canUpdate.allows(c) {
+				update.InputFields = append(update.InputFields, __InputValue{
+					Name: columnName, Type: &ct,
+				})
+			}
+
+			// columns the role cannot read are neither selected, filtered nor sorted
+			if !canQuery.allows(c) {
+				continue
+			}
+
 			// append sort by input fields
 			sort.InputFields = append(sort.InputFields, __InputValue{
 				Name:        columnName,
@@ -361,29 +458,20 @@ func (my *__Schema) addTablesType() {
 			}
 			where.InputFields = append(where.InputFields, iv)
 
-			// append table object field
-			ct := __Type{Name: cn}
-			if c.Array || isList {
-				ct = __Type{Kind: TK_LIST, OfType: &__Type{
-					Name: cn,
-				}}
-			}
-			if c.NotNull {
-				ct = __Type{Kind: TK_NON_NULL, OfType: &__Type{
-					Name: cn,
-				}}
+			// a json column with a json table is selected as the rows of the table
+			if et, ok := my.embeddedTable(t, c); ok {
+				en := my.getName(et.Name)
+				object.Fields = append(object.Fields, __Field{
+					Name:        columnName,
+					Description: c.Comment,
+					Type:        &__Type{Kind: TK_LIST, OfType: &__Type{Name: en}},
+					Args: append(argsList,
+						__InputValue{Name: "sort", Type: &__Type{Name: en + SUFFIX_SORT}},
+						__InputValue{Name: "where", Type: &__Type{Name: en + SUFFIX_WHERE}},
+					),
+				})
+				continue
 			}
-
-			upsert.InputFields = append(upsert.InputFields, __InputValue{
-				Name: columnName, Type: &ct,
-			})
-			insert.InputFields = append(insert.InputFields, __InputValue{
-				Name: columnName, Type: &ct,
-			})
-			update.InputFields = append(update.InputFields, __InputValue{
-				Name: columnName, Type: &ct,
-			})
-
 			object.Fields = append(object.Fields, __Field{
 				Name:        columnName,
 				Description: c.Comment,
@@ -393,20 +481,117 @@ func (my *__Schema) addTablesType() {
 					{Name: "skipIf", Type: &__Type{Name: where.Name}},
 				},
 			})
+
+			// aggregate fields like `count_id` and `sum_price` group the rows by the selected columns
+			if !my.conf.DisableAgg && !canQuery.noAgg {
+				for _, fn := range aggFunctions(c) {
+					object.Fields = append(object.Fields, __Field{
+						Name: my.getName(fn+"_"+c.Name, true),
+						Type: &__Type{Name: aggType(fn, cn)},
+					})
+				}
+			}
 		}
 
+		// the rows of the same table found by walking the self-referencing foreign key
 		if hasRecursive {
 			object.Fields = append(object.Fields, __Field{
-				Name: t.Name,
-				Type: &__Type{Name: tableName},
-				Args: []__InputValue{
-					{Name: "includeIf", Type: &__Type{Name: where.Name}},
-					{Name: "skipIf", Type: &__Type{Name: where.Name}},
-					{Name: "find", Type: &__Type{Name: Recursive}},
-				},
+				Name: my.getName(tableName, true),
+				Type: &__Type{Kind: TK_LIST, OfType: &__Type{Name: tableName}},
+				Args: append(argsList,
+					__InputValue{Name: "sort", Type: &__Type{Name: sort.Name}},
+					__InputValue{Name: "where", Type: &__Type{Name: where.Name}},
+					__InputValue{Name: "find", Type: &__Type{Kind: TK_NON_NULL, OfType: &__Type{Name: Recursive}}},
+					__InputValue{Name: "depth", Type: &__Type{Name: Int}},
+				),
+			})
+		}
+
+		// add the polymorphic relationships of the table
+		for _, vt := range my.info.VTables {
+			if _, ok := my.info.GetVirtualTable(t, vt.Name); ok {
+				object.Fields = append(object.Fields, __Field{
+					Name: my.getName(vt.Name, true),
+					Type: &__Type{Name: my.getName(vt.Name)},
+				})
+			}
+		}
+
+		// add an object for every foreign key of the table and a list for the foreign keys pointing to it
+		for _, rf := range my.info.GetRelFields(t) {
+			if my.rule(rf.Table, ruleQuery).block || my.hidden(rf.Table) {
+				continue
+			}
+			rn := my.getName(rf.Table.Name)
+			f := __Field{Name: my.getName(rf.Name, true), Type: &__Type{Name: rn}}
+			if rf.Rel.Type != RelOneToOne {
+				f.Type = &__Type{Kind: TK_LIST, OfType: &__Type{Name: rn}}
+				f.Args = append(argsList,
+					__InputValue{Name: "sort", Type: &__Type{Name: rn + SUFFIX_SORT}},
+					__InputValue{Name: "where", Type: &__Type{Name: rn + SUFFIX_WHERE}},
+				)
+			}
+			object.Fields = append(object.Fields, f)
+		}
+
+		// add the tables joined through a join table
+		for _, ot := range my.info.throughTables(t) {
+			if my.rule(ot, ruleQuery).block || my.hidden(ot) {
+				continue
+			}
+			on := my.getName(ot.Name)
+			if hasField(object.Fields, my.getName(on, true)) {
+				continue
+			}
+			object.Fields = append(object.Fields, __Field{
+				Name: my.getName(on, true),
+				Type: &__Type{Kind: TK_LIST, OfType: &__Type{Name: on}},
+				Args: append(argsList,
+					__InputValue{Name: "sort", Type: &__Type{Name: on + SUFFIX_SORT}},
+					__InputValue{Name: "where", Type: &__Type{Name: on + SUFFIX_WHERE}},
+				),
 			})
 		}
 
+		// add the fields resolved with the value of a column
+		for _, rc := range my.conf.Resolvers {
+			rt, ok := my.info.findTable(rc.Schema, rc.Table)
+			if !ok || rt != t {
+				continue
+			}
+			if c, ok := t.GetColumn(rc.Column); ok && canQuery.allows(c) {
+				object.Fields = append(object.Fields, __Field{Name: my.getName(rc.Name, true), Type: &__Type{Name: JSON}})
+			}
+		}
+
+		// add the functions that take the row of the table
+		if !canQuery.noFuncs {
+			for _, fn := range my.info.Functions {
+				if my.info.isRowFunction(fn, t) {
+					object.Fields = append(object.Fields, __Field{
+						Name: my.getName(fn.Name, true),
+						Type: my.scalarType(fn.Type),
+					})
+				}
+			}
+		}
+
+		// add the rank and the headlines of the full text search
+		if len(searchColumns(t, canQuery)) != 0 {
+			object.Fields = append(object.Fields, __Field{Name: my.getName(searchRank, true), Type: &__Type{Name: Float}})
+			for _, c := range t.SortedColumns() {
+				if !c.Blocked && isTextColumn(c) && canQuery.allows(c) {
+					object.Fields = append(object.Fields, __Field{Name: my.getName(searchHeadline+c.Name, true), Type: &__Type{Name: String}})
+				}
+			}
+		}
+
+		// the rows of a json table are only read through the column of its table
+		if t.embedded() {
+			my.addType(sort, where, object)
+			continue
+		}
+
 		// add to types
 		my.addType(sort, where, upsert, insert, update, object)
 
@@ -415,19 +600,46 @@ func (my *__Schema) addTablesType() {
 			__InputValue{Name: "sort", Type: &__Type{Name: sort.Name}},
 			__InputValue{Name: "where", Type: &__Type{Name: where.Name}},
 		)
-		my.addTypeTo("Query", object, args)
-		my.addTypeTo("Subscription", object, args)
-
-		// add object Mutation
-		args = append(args,
-			__InputValue{Name: "delete", Type: &__Type{Name: Boolean}},
-			__InputValue{Name: "upsert", Type: &__Type{Name: upsert.Name}},
-			__InputValue{Name: "insert", Type: &__Type{Name: insert.Name}},
-			__InputValue{Name: "update", Type: &__Type{Name: update.Name}},
-		)
-		my.addTypeTo("Mutation", object, args)
+		if !canQuery.block {
+			if ob, ok := my.orderByType(t, tableName); ok {
+				my.addType(ob)
+				args = append(args, __InputValue{Name: "orderBy", Type: &__Type{Name: ob.Name}})
+			}
+			// tables with tsvector columns are searched by rank, not in mutations
+			queryArgs := args
+			if len(searchColumns(t, canQuery)) != 0 {
+				queryArgs = append(queryArgs[:len(queryArgs):len(queryArgs)], __InputValue{Name: "search", Type: &__Type{Name: String}})
+			}
+			my.addTypeTo("Query", object, queryArgs)
+			my.addTypeTo("Subscription", object, queryArgs)
+			if my.conf.SecretKey != "" {
+				my.addCursorTo("Query", object)
+				my.addCursorTo("Subscription", object)
+			}
+		}
+
+		// add object Mutation with the operations allowed for the role
+		var ops []__InputValue
+		if !canDelete.block {
+			ops = append(ops, __InputValue{Name: "delete", Type: &__Type{Name: Boolean}})
+		}
+		if !canUpsert.block {
+			ops = append(ops, __InputValue{Name: "upsert", Type: &__Type{Name: upsert.Name}})
+		}
+		if !canInsert.block {
+			ops = append(ops, __InputValue{Name: "insert", Type: &__Type{Name: insert.Name}})
+		}
+		if !canUpdate.block {
+			ops = append(ops, __InputValue{Name: "update", Type: &__Type{Name: update.Name}})
+		}
+		if len(ops) != 0 {
+			my.addTypeTo("Mutation", object, append(args, ops...))
+		}
 	}
 
+	my.addUnionTypes()
+	my.addFunctions()
+
 	// add tables enum to types
 	my.addType(__Type{
 		Kind:        TK_ENUM,
@@ -437,6 +649,120 @@ func (my *__Schema) addTablesType() {
 	})
 }
 
+// embeddedTable returns the readable json table of the column
+func (my *__Schema) embeddedTable(t *DBTable, c DBColumn) (*DBTable, bool) {
+	for _, et := range my.info.Tables {
+		if et.embedded() && et.Name == c.Name && et.Source == t.sourceName() && !my.hidden(et) && !my.rule(et, ruleQuery).block {
+			return et, true
+		}
+	}
+	return nil, false
+}
+
+// scalarType returns the graphql type of the database type
+func (my *__Schema) scalarType(dbType string) *__Type {
+	name, isList := getType(dbType)
+	if isList {
+		return &__Type{Kind: TK_LIST, OfType: &__Type{Name: name}}
+	}
+	return &__Type{Name: name}
+}
+
+// addFunctions adds the functions returning the rows of a table as root fields, their inputs are the `args` argument
+func (my *__Schema) addFunctions() {
+	for _, fn := range my.info.Functions {
+		t, ok := my.info.setTable(fn)
+		if !ok || my.hidden(t) {
+			continue
+		}
+		if r := my.rule(t, ruleQuery); r.block || r.noFuncs {
+			continue
+		}
+		name := my.getName(fn.Name, true)
+		if _, ok := my.Types[my.getName(fn.Name)]; ok {
+			continue
+		}
+		tableName := my.getName(t.Name)
+		args := append(argsList,
+			__InputValue{Name: "sort", Type: &__Type{Name: tableName + SUFFIX_SORT}},
+			__InputValue{Name: "where", Type: &__Type{Name: tableName + SUFFIX_WHERE}},
+		)
+		if len(fn.Inputs) != 0 {
+			input := __Type{Kind: TK_INPUT_OBJECT, Name: my.getName(fn.Name) + SUFFIX_ARGS}
+			for _, p := range fn.Inputs {
+				input.InputFields = append(input.InputFields, __InputValue{
+					Name: my.getName(p.Name, true),
+					Type: my.scalarType(p.Type),
+				})
+			}
+			my.addType(input)
+			args = append(args, __InputValue{Name: "args", Type: &__Type{Name: input.Name}})
+		}
+		for _, op := range []string{"Query", "Subscription"} {
+			ot := my.Types[op]
+			ot.Fields = append(ot.Fields, __Field{
+				Name:        name,
+				Description: fmt.Sprintf("Rows of '%s' returned by the function '%s'", tableName, fn.Name),
+				Type:        &__Type{Kind: TK_LIST, OfType: &__Type{Name: tableName}},
+				Args:        args,
+			})
+			my.Types[op] = ot
+		}
+	}
+}
+
+// addUnionTypes adds a union for every polymorphic relationship, its possible types are the readable
+// member tables that have the id column of the relationship
+func (my *__Schema) addUnionTypes() {
+	for _, vt := range my.info.VTables {
+		var names []string
+		for _, name := range vt.Members {
+			t, ok := my.info.findTable("", name)
+			if !ok || t.embedded() || my.hidden(t) || my.rule(t, ruleQuery).block {
+				continue
+			}
+			if _, ok := t.GetColumn(vt.IDColumn); ok {
+				names = append(names, my.getName(t.Name))
+			}
+		}
+		sort.Strings(names)
+
+		union := __Type{Kind: TK_UNION, Name: my.getName(vt.Name), PossibleTypes: []__Type{}}
+		for _, n := range names {
+			union.PossibleTypes = append(union.PossibleTypes, __Type{Name: n})
+		}
+		my.addType(union)
+	}
+}
+
+// orderByType lists the order by options of the table config as an enum
+func (my *__Schema) orderByType(t *DBTable, tableName string) (__Type, bool) {
+	tc := my.conf.getTable(t.Name)
+	if tc == nil || len(tc.OrderBy) == 0 {
+		return __Type{}, false
+	}
+	names := make([]string, 0, len(tc.OrderBy))
+	for k := range tc.OrderBy {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	res := __Type{Kind: TK_ENUM, Name: tableName + SUFFIX_ORDER_BY}
+	for _, k := range names {
+		res.EnumValues = append(res.EnumValues, __EnumValue{Name: k, Description: strings.Join(tc.OrderBy[k], ", ")})
+	}
+	return res, true
+}
+
+func hasField(fields []__Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (my *__Schema) getColumnType(c DBColumn) (name string, isList bool) {
 	if c.PrimaryKey {
 		name = ID
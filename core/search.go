@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	searchRank     = "search_rank"
+	searchHeadline = "search_headline_"
+)
+
+// search is the full text query of a selection, matched against the tsvector columns of the table
+type search struct {
+	value   interface{}
+	columns []DBColumn
+}
+
+// searchColumns returns the tsvector columns of the table that the rule may read
+func searchColumns(t *DBTable, r *rule) []DBColumn {
+	var list []DBColumn
+	for _, c := range t.SortedColumns() {
+		if c.FullText && !c.Blocked && r.allows(c) {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// isTextColumn reports whether a headline can be cut from the column
+func isTextColumn(c DBColumn) bool {
+	if c.Array || c.FullText {
+		return false
+	}
+	for _, v := range []string{"text", "character varying", "varchar", "character", "char"} {
+		if c.Type == v || strings.HasPrefix(c.Type, v+"(") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearch reads the `search` argument, the rows match when any tsvector column matches the query
+func (my *builder) parseSearch(s *selection, v interface{}) error {
+	cols := searchColumns(s.table, my.rule(s.table, ruleQuery))
+	if len(cols) == 0 {
+		return fmt.Errorf("unknown argument 'search' on field '%s'", s.name)
+	}
+	if _, ok := v.(string); !ok {
+		return fmt.Errorf("argument 'search': expected a string")
+	}
+	s.search = &search{value: v, columns: cols}
+	e := &exp{op: opOr}
+	for _, c := range cols {
+		e.children = append(e.children, &exp{op: opSearch, column: c, value: v})
+	}
+	s.where = and(s.where, e)
+	return nil
+}
+
+// searchField resolves the fields `search_rank` and `search_headline_<column>`,
+// they are only known when the selection is searched
+func (my *builder) searchField(s *selection, name string) (*field, bool, error) {
+	var f *field
+	if name == my.conf.getName(searchRank, true) {
+		f = &field{kind: kindSearch, value: searchRank}
+	}
+	for _, c := range s.table.Columns {
+		if !c.Blocked && isTextColumn(c) && name == my.conf.getName(searchHeadline+c.Name, true) {
+			if !my.rule(s.table, ruleQuery).allows(c) {
+				return nil, false, fmt.Errorf("cannot query field '%s' on type '%s'", name, my.conf.getName(s.table.Name))
+			}
+			f = &field{kind: kindSearch, value: searchHeadline, column: c}
+		}
+	}
+	if f == nil {
+		return nil, false, nil
+	}
+	if s.search == nil {
+		return nil, false, fmt.Errorf("field '%s' requires the argument 'search'", name)
+	}
+	return f, true, nil
+}
+
+// renderRank sums the rank of the query in every tsvector column
+func (my *renderer) renderRank(s *selection) {
+	my.WriteString(`(`)
+	for i, c := range s.search.columns {
+		if i != 0 {
+			my.WriteString(` + `)
+		}
+		my.WriteString(`ts_rank(`)
+		my.column(s, c)
+		my.WriteString(`, websearch_to_tsquery(`)
+		my.bind(s.search.value)
+		my.WriteString(`))`)
+	}
+	my.WriteString(`)`)
+}
+
+// renderSearchField renders the rank or the headline of the searched row
+func (my *renderer) renderSearchField(s *selection, f *field) {
+	if f.value == searchRank {
+		my.renderRank(s)
+		return
+	}
+	my.WriteString(`ts_headline(`)
+	my.column(s, f.column)
+	my.WriteString(`, websearch_to_tsquery(`)
+	my.bind(s.search.value)
+	my.WriteString(`))`)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func newSearchInfo() *DBInfo {
+	di := newTestInfo()
+	addTestTable(di, "articles",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "title", Type: "character varying(255)"},
+		DBColumn{Name: "body", Type: "text"},
+		DBColumn{Name: "tsv", Type: "tsvector", FullText: true},
+	)
+	return di
+}
+
+func TestCompileSearch(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newSearchInfo())
+	st, err := compileSession(t, c, `{ articles(search: "go -java", sort: {id: desc}) { id search_rank search_headline_body } }`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`'search_rank', (ts_rank("articles_0"."tsv", websearch_to_tsquery($1)))`,
+		`'search_headline_body', ts_headline("articles_0"."body", websearch_to_tsquery($2))`,
+		`WHERE (("articles_0"."tsv" @@ websearch_to_tsquery($3)))`,
+		`ORDER BY "articles_0"."id" DESC, (ts_rank("articles_0"."tsv", websearch_to_tsquery($4))) DESC`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+	if len(st.args) != 4 || st.args[0] != "go -java" {
+		t.Errorf("unexpected args %v", st.args)
+	}
+
+	fields := make(map[string]bool)
+	s := c.schema("")
+	for _, f := range s.Types["articles"].Fields {
+		fields[f.Name] = true
+	}
+	if !fields["search_rank"] || !fields["search_headline_title"] || fields["search_headline_tsv"] {
+		t.Errorf("unexpected search fields %v", fields)
+	}
+	for _, f := range s.Types["Query"].Fields {
+		var found bool
+		for _, a := range f.Args {
+			found = found || a.Name == "search"
+		}
+		if found != (f.Name == "articles") {
+			t.Errorf("unexpected search argument on %s", f.Name)
+		}
+	}
+}
+
+func TestCompileSearchErrors(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newSearchInfo())
+	for _, gql := range []string{
+		`{ users(search: "go") { id } }`,
+		`{ articles(search: 1) { id } }`,
+		`{ articles { id search_rank } }`,
+		`{ articles(search: "go") { count_id search_rank } }`,
+		`mutation { articles(search: "go", delete: true) { id } }`,
+	} {
+		if _, err := compileSession(t, c, gql, nil, nil); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ichaly/tiny-go/core/ast"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollEvery = 5 * time.Second
+	// maxBatchSize limits the number of members polled by a single statement
+	maxBatchSize = 100
+)
+
+var errNotSubscription = errors.New("only subscription operations can be subscribed")
+
+// subscriptions groups the members of identical subscriptions into streams,
+// every stream polls the database for all of its members at once
+type subscriptions struct {
+	sync.Mutex
+	seq       uint64
+	streams   map[string]*stream
+	listening bool
+	// install guards the notify triggers, they are created at most once per table
+	install  sync.Mutex
+	triggers map[string]bool
+}
+
+// stream polls one subscription document for all members, the members only differ in their variables
+type stream struct {
+	sync.Mutex
+	key     string
+	engine  *Engine
+	members map[uint64]*Member
+	tables  map[string]bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// Member is a single subscriber, a new result is sent every time the data of the subscription changes
+type Member struct {
+	Result <-chan *Result
+
+	id     uint64
+	stream *stream
+	query  *query
+	// ke compiled the query, the operation is compiled again once the engine reloaded
+	ke     *kernel
+	doc    *ast.QueryDocument
+	op     *ast.OperationDefinition
+	values map[string]interface{}
+	sess   *session
+	result chan *Result
+	hash   string
+	// ctx is the context of the subscription, it is cancelled when the member stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+}
+
+// Subscribe starts a subscription operation, the first result is sent as soon as it is available.
+// The member stops when the context is done or Unsubscribe is called.
+func (my *Engine) Subscribe(
+	ctx context.Context, query string, vars json.RawMessage, opName string,
+) (*Member, error) {
+	ke := my.Load().(*kernel)
+	res := &Result{}
+
+	doc, op, err := parseOperation(query, opName)
+	if err != nil {
+		_, err = res.fail(err)
+		return nil, err
+	}
+	if op.OperationType != ast.Subscription {
+		_, err = res.fail(errNotSubscription)
+		return nil, err
+	}
+
+	values, err := bindVariables(op, vars)
+	if err != nil {
+		_, err = res.fail(err)
+		return nil, err
+	}
+
+	sess, err := ke.session(ctx)
+	if err != nil {
+		_, err = res.fail(err)
+		return nil, err
+	}
+
+	q, err := ke.compiler.compile(doc, op, values, sess)
+	if err != nil {
+		_, err = res.fail(err)
+		return nil, err
+	}
+	// https://spec.graphql.org/draft/#sec-Single-root-field
+	if len(q.fields) != 1 {
+		_, err = res.fail(errors.New("subscription must select only one top level field"))
+		return nil, err
+	}
+
+	tables := queryTables(q)
+	if ke.conf.SubsMode == subsNotify {
+		if err = my.installTriggers(ctx, ke, tables); err != nil {
+			_, err = res.fail(err)
+			return nil, err
+		}
+		my.listen(ke)
+	}
+
+	ch := make(chan *Result, 1)
+	m := &Member{
+		Result: ch, query: q, ke: ke, doc: doc, op: op, values: values, sess: sess, result: ch, done: make(chan struct{}),
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	my.join(hashKey(query, opName), m, tables)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.Unsubscribe()
+		case <-m.done:
+		}
+	}()
+	return m, nil
+}
+
+// Unsubscribe removes the member from its stream and closes the result channel
+func (my *Member) Unsubscribe() {
+	my.once.Do(func() {
+		my.cancel()
+		close(my.done)
+		my.stream.leave(my)
+	})
+}
+
+func (my *Engine) join(key string, m *Member, tables []*DBTable) {
+	my.subs.Lock()
+	defer my.subs.Unlock()
+
+	if my.subs.streams == nil {
+		my.subs.streams = make(map[string]*stream)
+	}
+	my.subs.seq++
+	m.id = my.subs.seq
+
+	s, ok := my.subs.streams[key]
+	if !ok {
+		s = &stream{
+			key:     key,
+			engine:  my,
+			members: make(map[uint64]*Member),
+			tables:  make(map[string]bool),
+			wake:    make(chan struct{}, 1),
+			done:    make(chan struct{}),
+		}
+		my.subs.streams[key] = s
+		go s.run()
+	}
+	m.stream = s
+
+	s.Lock()
+	s.members[m.id] = m
+	for _, t := range tables {
+		s.tables[notifyKey(t)] = true
+	}
+	s.Unlock()
+
+	// poll right away so that the new member gets its first result
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (my *stream) leave(m *Member) {
+	e := my.engine
+	e.subs.Lock()
+	defer e.subs.Unlock()
+
+	my.Lock()
+	delete(my.members, m.id)
+	close(m.result)
+	empty := len(my.members) == 0
+	my.Unlock()
+
+	if empty {
+		delete(e.subs.streams, my.key)
+		close(my.done)
+	}
+}
+
+func (my *stream) run() {
+	ke := my.engine.Load().(*kernel)
+	every := defaultPollEvery
+	if ke.conf.PollEvery > 0 {
+		every = time.Duration(ke.conf.PollEvery) * time.Second
+	}
+
+	// in notify mode the stream only wakes up when a table it reads changed
+	var tick <-chan time.Time
+	if ke.conf.SubsMode != subsNotify {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-my.done:
+			return
+		case <-my.engine.done:
+			return
+		case <-tick:
+		case <-my.wake:
+		}
+		my.poll(every)
+	}
+}
+
+// poll runs the queries of all members in batches and pushes the results that changed
+func (my *stream) poll(timeout time.Duration) {
+	my.Lock()
+	list := make([]*Member, 0, len(my.members))
+	for _, m := range my.members {
+		list = append(list, m)
+	}
+	my.Unlock()
+
+	for len(list) != 0 {
+		n := len(list)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		results := my.fetch(list[:n], timeout)
+		// the resolvers may be slow, so the results are finished before the members are locked
+		for i, m := range list[:n] {
+			results[i] = m.prepare(results[i])
+		}
+
+		my.Lock()
+		for i, m := range list[:n] {
+			if _, ok := my.members[m.id]; ok && results[i] != nil {
+				m.push(results[i])
+			}
+		}
+		my.Unlock()
+		list = list[n:]
+	}
+}
+
+// fetch executes the batch in one statement, every member is a row of the result:
+// SELECT 0 AS "__idx", (SELECT json_build_object(...) ...) AS "__root" UNION ALL SELECT 1, (...) ...
+func (my *stream) fetch(list []*Member, timeout time.Duration) []*Result {
+	ke := my.engine.Load().(*kernel)
+	results := make([]*Result, len(list))
+
+	r := &renderer{}
+	var pending []int
+	for i, m := range list {
+		// a member compiled before the engine reloaded is compiled against the current database info
+		if m.ke != ke {
+			if err := my.refresh(m, ke); err != nil {
+				results[i], _ = (&Result{}).fail(err)
+				continue
+			}
+		}
+		if data, ok := m.query.static(); ok {
+			results[i] = &Result{Data: data}
+			continue
+		}
+		// every member is rendered on its own, a failed one leaves no sql or args in the batch.
+		// The args of the batch are its first ones, so the placeholders continue their numbers.
+		mr := &renderer{args: r.args[:len(r.args):len(r.args)]}
+		if err := mr.renderQuery(m.query); err != nil {
+			results[i], _ = (&Result{}).fail(err)
+			continue
+		}
+		if len(pending) != 0 {
+			r.WriteString(` UNION ALL `)
+		}
+		r.WriteString(`SELECT `)
+		r.WriteString(strconv.Itoa(i))
+		r.WriteString(` AS "__idx", (`)
+		r.WriteString(mr.String())
+		r.WriteString(`) AS "__root"`)
+		r.args = mr.args
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return results
+	}
+
+	fail := func(err error) []*Result {
+		for _, i := range pending {
+			results[i], _ = (&Result{Data: json.RawMessage(`null`)}).fail(err)
+		}
+		return results
+	}
+
+	if ke.conf.Debug {
+		ke.log.Println(r.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rows, err := ke.db.QueryContext(ctx, r.String(), r.args...)
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			idx  int
+			data []byte
+		)
+		if err = rows.Scan(&idx, &data); err != nil {
+			return fail(err)
+		}
+		if idx >= 0 && idx < len(results) {
+			results[idx] = &Result{Data: data}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fail(err)
+	}
+	return results
+}
+
+// refresh compiles the operation of the member with the kernel of a reload,
+// the tables of the new query are watched as well
+func (my *stream) refresh(m *Member, ke *kernel) error {
+	q, err := ke.compiler.compile(m.doc, m.op, m.values, m.sess)
+	if err != nil {
+		return err
+	}
+	tables := queryTables(q)
+	if ke.conf.SubsMode == subsNotify {
+		if err = my.engine.installTriggers(m.ctx, ke, tables); err != nil {
+			return err
+		}
+	}
+	my.Lock()
+	for _, t := range tables {
+		my.tables[notifyKey(t)] = true
+	}
+	my.Unlock()
+	m.query, m.ke = q, ke
+	return nil
+}
+
+// prepare returns the result when it differs from the last one, nil otherwise.
+// The cursors are encrypted with a random nonce, so only the changed results are finished.
+func (my *Member) prepare(res *Result) *Result {
+	if res == nil || my.ctx.Err() != nil {
+		return nil
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if hash == my.hash {
+		return nil
+	}
+	my.hash = hash
+
+	if (my.query.cursor || my.query.resolve) && len(res.Errors) == 0 {
+		if res.Data, res.Errors, err = my.ke.compiler.finishResponse(my.ctx, my.query, res.Data); err != nil {
+			res, _ = (&Result{Data: json.RawMessage(`null`)}).fail(err)
+		}
+	}
+	return res
+}
+
+// push sends the result, a slow reader only gets the latest result
+func (my *Member) push(res *Result) {
+	for {
+		select {
+		case my.result <- res:
+			return
+		default:
+		}
+		select {
+		case <-my.result:
+		default:
+		}
+	}
+}
+
+func hashKey(query, opName string) string {
+	sum := sha256.Sum256([]byte(opName + "\x00" + query))
+	return hex.EncodeToString(sum[:])
+}
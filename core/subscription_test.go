@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subscriptionReply answers the batched statements with one row per member
+func subscriptionReply(data func() string) func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		var rows [][]driver.Value
+		for i := int64(0); strings.Contains(query, "SELECT "+strconv.FormatInt(i, 10)+` AS "__idx"`); i++ {
+			rows = append(rows, []driver.Value{i, []byte(data())})
+		}
+		return []string{"__idx", "__root"}, rows, nil
+	}
+}
+
+func receive(t *testing.T, m *Member) *Result {
+	t.Helper()
+	select {
+	case res := <-m.Result:
+		return res
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for a result")
+	}
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	var mu sync.Mutex
+	version := "a"
+	db, f := openFakeDB(t, subscriptionReply(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return `{"users":{"v":"` + version + `"}}`
+	}))
+	e := newTestEngine(t, &Config{PollEvery: 3600}, db)
+
+	const gql = `subscription ($id: ID!) { users(id: $id) { id } }`
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m1, err := e.Subscribe(ctx, gql, []byte(`{"id": 1}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := receive(t, m1); string(res.Data) != `{"users":{"v":"a"}}` {
+		t.Errorf("unexpected first result %s", res.Data)
+	}
+
+	m2, err := e.Subscribe(ctx, gql, []byte(`{"id": 2}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, m2)
+	e.subs.Lock()
+	if len(e.subs.streams) != 1 {
+		t.Errorf("expected identical subscriptions to share a stream, got %d", len(e.subs.streams))
+	}
+	e.subs.Unlock()
+
+	// both members are polled by one statement with their own variables
+	sql, args := f.last()
+	if !strings.Contains(sql, " UNION ALL ") || len(args) != 2 {
+		t.Errorf("expected a batched statement, got %s %v", sql, args)
+	}
+
+	// unchanged data is not sent again
+	s := m1.stream
+	s.poll(time.Second)
+	select {
+	case res := <-m1.Result:
+		t.Errorf("unexpected result %s", res.Data)
+	default:
+	}
+
+	mu.Lock()
+	version = "b"
+	mu.Unlock()
+	s.poll(time.Second)
+	if res := receive(t, m1); !strings.Contains(string(res.Data), `"v":"b"`) {
+		t.Errorf("expected the changed data, got %s", res.Data)
+	}
+	receive(t, m2)
+
+	m1.Unsubscribe()
+	if _, ok := <-m1.Result; ok {
+		t.Error("expected the result channel to be closed")
+	}
+	if m1.ctx.Err() == nil {
+		t.Error("expected the context of the member to be cancelled")
+	}
+	cancel()
+	if _, ok := <-m2.Result; ok {
+		t.Error("expected the result channel to be closed with the context")
+	}
+	e.subs.Lock()
+	defer e.subs.Unlock()
+	if len(e.subs.streams) != 0 {
+		t.Errorf("expected the stream to stop without members")
+	}
+}
+
+func TestSubscribeReload(t *testing.T) {
+	db, _ := openFakeDB(t, subscriptionReply(func() string { return `{"users":[{"id":1,"email":"a@x.io"}]}` }))
+	e := newTestEngine(t, &Config{PollEvery: 3600}, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := e.Subscribe(ctx, `subscription { users { id email } }`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, m)
+
+	// the column is gone after the database changed, the running stream compiles the operation again
+	di := newTestInfo()
+	delete(di.Tables["public:users"].Columns, "public:users:email")
+	if err = e.reload(di); err != nil {
+		t.Fatal(err)
+	}
+	m.stream.poll(time.Second)
+	if res := receive(t, m); len(res.Errors) == 0 || !strings.Contains(res.Errors[0].Message, "email") {
+		t.Errorf("expected an error for the removed column, got %+v", res)
+	}
+
+	if err = e.reload(newTestInfo()); err != nil {
+		t.Fatal(err)
+	}
+	m.stream.poll(time.Second)
+	if res := receive(t, m); len(res.Errors) != 0 || m.ke != e.Load().(*kernel) {
+		t.Errorf("expected the member to use the reloaded engine, got %+v", res)
+	}
+}
+
+func TestSubscribeRenderError(t *testing.T) {
+	db, f := openFakeDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		var rows [][]driver.Value
+		for i := int64(0); i < 2; i++ {
+			if strings.Contains(query, "SELECT "+strconv.FormatInt(i, 10)+` AS "__idx"`) {
+				rows = append(rows, []driver.Value{i, []byte(`{"users":{"id":` + strconv.FormatInt(i+1, 10) + `}}`)})
+			}
+		}
+		return []string{"__idx", "__root"}, rows, nil
+	})
+	e := newTestEngine(t, &Config{PollEvery: 3600}, db)
+
+	const gql = `subscription ($id: ID!) { users(id: $id) { id } }`
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m1, err := e.Subscribe(ctx, gql, []byte(`{"id": 1}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, m1)
+	m2, err := e.Subscribe(ctx, gql, []byte(`{"id": 2}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, m2)
+
+	// the first member can not be rendered any more, the second one is fetched alone
+	sel := m1.query.fields[0].child
+	sel.where = and(sel.where, &exp{op: expOp(-1), column: sel.table.PrimaryCol})
+	results := m1.stream.fetch([]*Member{m1, m2}, time.Second)
+	if len(results[0].Errors) == 0 {
+		t.Errorf("expected an error for the first member, got %s", results[0].Data)
+	}
+	if len(results[1].Errors) != 0 || string(results[1].Data) != `{"users":{"id":2}}` {
+		t.Errorf("unexpected result of the second member %+v", results[1])
+	}
+	sql, args := f.last()
+	if strings.Contains(sql, `SELECT 0 AS "__idx"`) || !strings.HasPrefix(sql, `SELECT 1 AS "__idx", (SELECT`) ||
+		!strings.Contains(sql, `= $1)`) || len(args) != 1 || fmt.Sprint(args[0].Value) != "2" {
+		t.Errorf("expected only the second member in the batch, got %s %v", sql, args)
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	db, _ := openFakeDB(t, jsonReply(`{}`))
+	e := newTestEngine(t, &Config{}, db)
+	for _, gql := range []string{
+		`query { users { id } }`,
+		`subscription { users { id } posts { id } }`,
+		`subscription { unknown { id } }`,
+	} {
+		if _, err := e.Subscribe(context.Background(), gql, nil, ""); err == nil {
+			t.Errorf("expected an error for %s", gql)
+		}
+	}
+}
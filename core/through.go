@@ -0,0 +1,99 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// joinKeys returns the foreign keys of a join table, a table whose key is made of
+// two foreign keys pointing to two other tables
+func joinKeys(t *DBTable) (DBColumn, DBColumn, bool) {
+	if t.Blocked || t.Source != "" || t.embedded() {
+		return DBColumn{}, DBColumn{}, false
+	}
+	var keys []DBColumn
+	for _, c := range t.SortedColumns() {
+		if c.FKeyTable == "" || !(c.PrimaryKey || c.UniqueKey) {
+			continue
+		}
+		if c.FKRecursive || c.Array {
+			return DBColumn{}, DBColumn{}, false
+		}
+		keys = append(keys, c)
+	}
+	if len(keys) != 2 || (keys[0].FKeySchema == keys[1].FKeySchema && keys[0].FKeyTable == keys[1].FKeyTable) {
+		return DBColumn{}, DBColumn{}, false
+	}
+	return keys[0], keys[1], true
+}
+
+// addThrough records the join tables, `product_categories(product_id, category_id)` joins products and categories
+func (my *DBInfo) addThrough() {
+	my.through = nil
+	for _, t := range my.Tables {
+		if _, _, ok := joinKeys(t); ok {
+			my.through = append(my.through, t)
+		}
+	}
+	sort.Slice(my.through, func(i, j int) bool {
+		return my.through[i].String() < my.through[j].String()
+	})
+}
+
+// GetThrough joins the child table onto the parent table through a join table, Left and Right are the keys
+// of the child and the parent that ThroughLeft and ThroughRight of the join table point to
+func (my *DBInfo) GetThrough(child, parent *DBTable) (*DBRel, error) {
+	for _, jt := range my.through {
+		a, b, _ := joinKeys(jt)
+		if isReference(b, child) && isReference(a, parent) {
+			a, b = b, a
+		}
+		if !isReference(a, child) || !isReference(b, parent) {
+			continue
+		}
+		cc, ok1 := child.GetColumn(a.FKeyCol)
+		pc, ok2 := parent.GetColumn(b.FKeyCol)
+		if ok1 && ok2 {
+			return &DBRel{Type: RelManyToMany, Left: cc, Right: pc, Through: jt, ThroughLeft: a, ThroughRight: b}, nil
+		}
+	}
+	return nil, fmt.Errorf("no relationship found between '%s' and '%s'", child.Name, parent.Name)
+}
+
+// throughTables returns the tables joined to the table through a join table
+func (my *DBInfo) throughTables(t *DBTable) []*DBTable {
+	var list []*DBTable
+	for _, ot := range my.Tables {
+		if ot == t || ot.embedded() {
+			continue
+		}
+		if _, err := my.GetThrough(ot, t); err == nil {
+			list = append(list, ot)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// renderThrough joins the join table onto the child rows, its other key is matched with the parent row:
+// "public"."categories" AS "categories_1" INNER JOIN "public"."product_categories" AS "__jt_1" ON ...
+func (my *renderer) renderThrough(s *selection) {
+	my.WriteString(` INNER JOIN `)
+	my.quote(s.rel.Through.Schema)
+	my.WriteString(`.`)
+	my.quote(s.rel.Through.Name)
+	my.WriteString(` AS `)
+	my.quote(throughAlias(s))
+	my.WriteString(` ON `)
+	my.quote(throughAlias(s))
+	my.WriteString(`.`)
+	my.quote(s.rel.ThroughLeft.Name)
+	my.WriteString(` = `)
+	my.column(s, s.rel.Left)
+}
+
+func throughAlias(s *selection) string {
+	return fmt.Sprintf("__jt_%d", s.id)
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func newThroughInfo() *DBInfo {
+	di := newTestInfo()
+	addTestTable(di, "products",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "name", Type: "text"},
+	)
+	addTestTable(di, "categories",
+		DBColumn{Name: "id", Type: "bigint", NotNull: true, PrimaryKey: true},
+		DBColumn{Name: "name", Type: "text"},
+	)
+	addTestTable(di, "product_categories",
+		DBColumn{Name: "product_id", Type: "bigint", NotNull: true, PrimaryKey: true, FKeyTable: "products", FKeyCol: "id"},
+		DBColumn{Name: "category_id", Type: "bigint", NotNull: true, PrimaryKey: true, FKeyTable: "categories", FKeyCol: "id"},
+		DBColumn{Name: "position", Type: "integer"},
+	)
+	return di
+}
+
+func TestCompileThrough(t *testing.T) {
+	c := newTestCompiler(t, &Config{}, newThroughInfo())
+	if len(c.info.through) != 1 || c.info.through[0].Name != "product_categories" {
+		t.Fatalf("expected the join table, got %v", c.info.through)
+	}
+	st, err := compileSession(t, c, `{
+		products { name categories(where: {name: {equals: "a"}}) { name products { id } } }
+	}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{
+		`SELECT "categories_1".* FROM "public"."categories" AS "categories_1" INNER JOIN "public"."product_categories" AS "__jt_1" ON "__jt_1"."category_id" = "categories_1"."id" WHERE "__jt_1"."product_id" = "products_0"."id" AND ("categories_1"."name" = $1)`,
+		`FROM "public"."products" AS "products_2" INNER JOIN "public"."product_categories" AS "__jt_2" ON "__jt_2"."product_id" = "products_2"."id" WHERE "__jt_2"."category_id" = "categories_1"."id"`,
+	} {
+		if !strings.Contains(st.sql, v) {
+			t.Errorf("expected %s in:\n%s", v, st.sql)
+		}
+	}
+
+	fields := make(map[string]bool)
+	s := c.schema("")
+	for _, op := range []string{"products", "categories"} {
+		for _, f := range s.Types[op].Fields {
+			fields[op+"."+f.Name] = f.Type.Kind == TK_LIST
+		}
+	}
+	if !fields["products.categories"] || !fields["categories.products"] {
+		t.Errorf("expected the many-to-many fields, got %v", fields)
+	}
+
+	if _, err = compileSession(t, c, `mutation { products(insert: {name: "a", categories: {name: "b"}}) { id } }`, nil, nil); err == nil {
+		t.Error("expected an error for a nested insert through the join table")
+	}
+}
@@ -46,6 +46,9 @@ const (
 	SUFFIX_UPSERT = "UpsertInput"
 	SUFFIX_INSERT = "InsertInput"
 	SUFFIX_UPDATE = "UpdateInput"
+	SUFFIX_CURSOR = "_cursor"
+
+	SUFFIX_ORDER_BY = "OrderBy" + SUFFIX_ENUM
 )
 
 var stdTypes = []__Type{
@@ -119,6 +122,26 @@ var stdTypes = []__Type{
 	},
 }
 
+var stdDirectives = []__Directive{
+	{
+		Name:        "include",
+		Description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		Locations:   []__DirectiveLocation{DL_FIELD, DL_FRAGMENT_SPREAD, DL_INLINE_FRAGMENT},
+		Args: []__InputValue{{
+			Name: "if", Description: "Included when true.",
+			Type: &__Type{Kind: TK_NON_NULL, OfType: &__Type{Name: Boolean}},
+		}},
+	}, {
+		Name:        "skip",
+		Description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		Locations:   []__DirectiveLocation{DL_FIELD, DL_FRAGMENT_SPREAD, DL_INLINE_FRAGMENT},
+		Args: []__InputValue{{
+			Name: "if", Description: "Skipped when true.",
+			Type: &__Type{Kind: TK_NON_NULL, OfType: &__Type{Name: Boolean}},
+		}},
+	},
+}
+
 var expAll = []__InputValue{
 	{
 		Name:        "isNull",
@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/ichaly/tiny-go/core/ast"
+	_lexer "github.com/ichaly/tiny-go/core/lexer"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//
+// Transport described in:
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+//
+
+const (
+	wsProtocol    = "graphql-transport-ws"
+	wsInitTimeout = 10 * time.Second
+
+	wsConnectionInit = "connection_init"
+	wsConnectionAck  = "connection_ack"
+	wsPing           = "ping"
+	wsPong           = "pong"
+	wsSubscribe      = "subscribe"
+	wsNext           = "next"
+	wsError          = "error"
+	wsComplete       = "complete"
+
+	wsInvalidMessage     = 4400
+	wsUnauthorized       = 4401
+	wsNotAcceptable      = 4406
+	wsInitTimedOut       = 4408
+	wsSubscriberExists   = 4409
+	wsTooManyInitRequest = 4429
+)
+
+var wsUpgrader = websocket.Upgrader{Subprotocols: []string{wsProtocol}}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsConn is a single websocket connection, it runs every subscribe message as its own operation
+type wsConn struct {
+	sync.Mutex
+	engine *Engine
+	conn   *websocket.Conn
+	ctx    context.Context
+	acked  bool
+	ops    map[string]*wsOperation
+}
+
+// wsOperation is a running operation, ids can be reused once the operation completed
+type wsOperation struct {
+	cancel context.CancelFunc
+}
+
+func (my *Engine) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), HeadersKey, r.Header))
+	defer cancel()
+
+	c := &wsConn{engine: my, conn: conn, ctx: ctx, ops: make(map[string]*wsOperation)}
+	if conn.Subprotocol() != wsProtocol {
+		c.close(wsNotAcceptable, "Subprotocol not acceptable")
+		return
+	}
+
+	timer := time.AfterFunc(wsInitTimeout, func() {
+		c.Lock()
+		acked := c.acked
+		c.Unlock()
+		if !acked {
+			c.close(wsInitTimedOut, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsMessage
+		if err = json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			c.close(wsInvalidMessage, "Invalid message received")
+			return
+		}
+		if !c.handle(&msg) {
+			return
+		}
+	}
+}
+
+// handle processes a client message, it returns false when the connection was closed
+func (my *wsConn) handle(msg *wsMessage) bool {
+	switch msg.Type {
+	case wsConnectionInit:
+		my.Lock()
+		if my.acked {
+			my.Unlock()
+			my.close(wsTooManyInitRequest, "Too many initialisation requests")
+			return false
+		}
+		ctx, err := my.initCreds(msg.Payload)
+		if err != nil {
+			my.Unlock()
+			my.close(wsInvalidMessage, "Invalid message received")
+			return false
+		}
+		my.ctx = ctx
+		my.acked = true
+		my.Unlock()
+		my.write(&wsMessage{Type: wsConnectionAck})
+	case wsPing:
+		my.write(&wsMessage{Type: wsPong, Payload: msg.Payload})
+	case wsPong:
+	case wsSubscribe:
+		my.Lock()
+		acked := my.acked
+		_, exists := my.ops[msg.ID]
+		my.Unlock()
+		if !acked {
+			my.close(wsUnauthorized, "Unauthorized")
+			return false
+		}
+		if exists {
+			my.close(wsSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+			return false
+		}
+		var req httpRequest
+		if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+			my.close(wsInvalidMessage, "Invalid message received")
+			return false
+		}
+		my.subscribe(msg.ID, &req)
+	case wsComplete:
+		my.Lock()
+		if op, ok := my.ops[msg.ID]; ok {
+			delete(my.ops, msg.ID)
+			op.cancel()
+		}
+		my.Unlock()
+	default:
+		my.close(wsInvalidMessage, "Invalid message received")
+		return false
+	}
+	return true
+}
+
+// initCreds reads the user_id and user_role of the connection_init payload when subs_creds_in_vars is set,
+// they never replace a user the auth middleware verified
+func (my *wsConn) initCreds(payload json.RawMessage) (context.Context, error) {
+	conf := my.engine.Load().(*kernel).conf
+	if !conf.Auth.SubsCredsInVars || isNullJSON(payload) || my.ctx.Value(UserIDKey) != nil {
+		return my.ctx, nil
+	}
+	var vars struct {
+		UserID   interface{} `json:"user_id"`
+		UserRole string      `json:"user_role"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&vars); err != nil {
+		return nil, err
+	}
+	switch v := vars.UserID.(type) {
+	case nil:
+		return my.ctx, nil
+	case string, json.Number:
+		return withUser(my.ctx, fmt.Sprint(v), vars.UserRole), nil
+	}
+	return nil, fmt.Errorf("invalid user_id %v", vars.UserID)
+}
+
+// subscribe starts the operation, queries and mutations complete after their only result
+func (my *wsConn) subscribe(id string, req *httpRequest) {
+	ctx, cancel := context.WithCancel(my.ctx)
+	o := &wsOperation{cancel: cancel}
+	my.Lock()
+	my.ops[id] = o
+	my.Unlock()
+
+	_, op, err := parseOperation(req.Query, req.OperationName)
+	if err != nil {
+		res, _ := (&Result{}).fail(err)
+		my.fail(id, o, res.Errors)
+		return
+	}
+
+	if op.OperationType != ast.Subscription {
+		go func() {
+			res, _ := my.engine.GraphQL(ctx, req.Query, req.Variables, req.OperationName)
+			// without data the request failed before execution started
+			if res.Data == nil {
+				my.fail(id, o, res.Errors)
+				return
+			}
+			my.write(&wsMessage{ID: id, Type: wsNext, Payload: marshal(res)})
+			my.complete(id, o)
+		}()
+		return
+	}
+
+	m, err := my.engine.Subscribe(ctx, req.Query, req.Variables, req.OperationName)
+	if err != nil {
+		list, _ := err.(_lexer.List)
+		my.fail(id, o, list)
+		return
+	}
+	go func() {
+		for res := range m.Result {
+			my.write(&wsMessage{ID: id, Type: wsNext, Payload: marshal(res)})
+		}
+		my.complete(id, o)
+	}()
+}
+
+// complete tells the client the operation is done unless the client completed it first
+func (my *wsConn) complete(id string, o *wsOperation) {
+	if my.remove(id, o) {
+		my.write(&wsMessage{ID: id, Type: wsComplete})
+	}
+}
+
+func (my *wsConn) fail(id string, o *wsOperation, errs _lexer.List) {
+	if my.remove(id, o) {
+		my.write(&wsMessage{ID: id, Type: wsError, Payload: marshal(errs)})
+	}
+}
+
+// remove stops the operation, it reports false when the client already completed it
+func (my *wsConn) remove(id string, o *wsOperation) bool {
+	o.cancel()
+	my.Lock()
+	defer my.Unlock()
+	if my.ops[id] != o {
+		return false
+	}
+	delete(my.ops, id)
+	return true
+}
+
+func (my *wsConn) write(msg *wsMessage) {
+	my.Lock()
+	defer my.Unlock()
+	_ = my.conn.WriteJSON(msg)
+}
+
+func (my *wsConn) close(code int, reason string) {
+	my.Lock()
+	defer my.Unlock()
+	data := websocket.FormatCloseMessage(code, reason)
+	_ = my.conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(time.Second))
+	_ = my.conn.Close()
+}
+
+func marshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(`null`)
+	}
+	return data
+}
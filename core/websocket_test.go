@@ -0,0 +1,177 @@
+package core
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialTest(t *testing.T, e *Engine, protocol string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(e.Handler())
+	t.Cleanup(srv.Close)
+
+	d := websocket.Dialer{Subprotocols: []string{protocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, conn *websocket.Conn) *wsMessage {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg := &wsMessage{}
+	if err := conn.ReadJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func closeCode(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(*websocket.CloseError); ok {
+			return e.Code
+		}
+		t.Fatal(err)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	batch := subscriptionReply(func() string { return `{"users":[{"id":1}]}` })
+	db, _ := openFakeDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, `"__idx"`) {
+			return batch(query, args)
+		}
+		return jsonReply(`{"posts":[]}`)(query, args)
+	})
+	conn := dialTest(t, newTestEngine(t, &Config{PollEvery: 3600}, db), wsProtocol)
+
+	send(t, conn, `{"type":"connection_init"}`)
+	if msg := read(t, conn); msg.Type != wsConnectionAck {
+		t.Fatalf("expected connection_ack, got %s", msg.Type)
+	}
+
+	send(t, conn, `{"type":"ping"}`)
+	if msg := read(t, conn); msg.Type != wsPong {
+		t.Fatalf("expected pong, got %s", msg.Type)
+	}
+
+	send(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"subscription { users { id } }"}}`)
+	msg := read(t, conn)
+	if msg.ID != "1" || msg.Type != wsNext || string(msg.Payload) != `{"data":{"users":[{"id":1}]}}` {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	send(t, conn, `{"id":"2","type":"subscribe","payload":{"query":"{ posts { id } }"}}`)
+	if msg = read(t, conn); msg.ID != "2" || msg.Type != wsNext {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if msg = read(t, conn); msg.ID != "2" || msg.Type != wsComplete {
+		t.Fatalf("expected the query to complete, got %+v", msg)
+	}
+
+	send(t, conn, `{"id":"3","type":"subscribe","payload":{"query":"subscription { unknown { id } }"}}`)
+	msg = read(t, conn)
+	var errs []map[string]interface{}
+	if msg.ID != "3" || msg.Type != wsError || json.Unmarshal(msg.Payload, &errs) != nil || len(errs) == 0 {
+		t.Fatalf("expected an error message, got %+v", msg)
+	}
+
+	send(t, conn, `{"id":"1","type":"complete"}`)
+	send(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"subscription { users { id } }"}}`)
+	if msg = read(t, conn); msg.ID != "1" || msg.Type != wsNext {
+		t.Fatalf("expected the id to be reusable after complete, got %+v", msg)
+	}
+
+	send(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"subscription { users { id } }"}}`)
+	if code := closeCode(t, conn); code != wsSubscriberExists {
+		t.Errorf("expected close code %d, got %d", wsSubscriberExists, code)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	db, _ := openFakeDB(t, jsonReply(`{}`))
+	e := newTestEngine(t, &Config{}, db)
+
+	tests := []struct {
+		name     string
+		protocol string
+		messages []string
+		code     int
+	}{
+		{name: "protocol", protocol: "graphql-ws", code: wsNotAcceptable},
+		{name: "unauthorized", protocol: wsProtocol, messages: []string{
+			`{"id":"1","type":"subscribe","payload":{"query":"{ users { id } }"}}`,
+		}, code: wsUnauthorized},
+		{name: "init twice", protocol: wsProtocol, messages: []string{
+			`{"type":"connection_init"}`, `{"type":"connection_init"}`,
+		}, code: wsTooManyInitRequest},
+		{name: "invalid", protocol: wsProtocol, messages: []string{`{"id":"1"}`}, code: wsInvalidMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialTest(t, e, tt.protocol)
+			for _, m := range tt.messages {
+				send(t, conn, m)
+			}
+			if code := closeCode(t, conn); code != tt.code {
+				t.Errorf("expected close code %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestWebSocketCreds(t *testing.T) {
+	for _, v := range []struct {
+		inVars bool
+		init   string
+		errors bool
+	}{
+		{true, `{"type":"connection_init","payload":{"user_id":3}}`, false},
+		{true, `{"type":"connection_init","payload":{"user_id":"3","user_role":"user"}}`, false},
+		{true, `{"type":"connection_init"}`, true},
+		{false, `{"type":"connection_init","payload":{"user_id":3}}`, true},
+	} {
+		db, _ := openFakeDB(t, jsonReply(`{"users":[]}`))
+		conf := &Config{
+			Auth:  AuthConfig{Type: authJWT, SubsCredsInVars: v.inVars, JWT: JWTConfig{Secret: "secret"}},
+			Roles: []RoleConfig{{Name: roleAnon, Tables: []RoleTable{{Name: "posts"}}}},
+		}
+		conn := dialTest(t, newTestEngine(t, conf, db), wsProtocol)
+		send(t, conn, v.init)
+		if msg := read(t, conn); msg.Type != wsConnectionAck {
+			t.Fatalf("expected connection_ack, got %s", msg.Type)
+		}
+		send(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"{ users { id } }"}}`)
+		if msg := read(t, conn); (msg.Type == wsError) != v.errors {
+			t.Errorf("unexpected message %+v for %s", msg, v.init)
+		}
+	}
+
+	db, _ := openFakeDB(t, jsonReply(`{}`))
+	conn := dialTest(t, newTestEngine(t, &Config{Auth: AuthConfig{SubsCredsInVars: true}}, db), wsProtocol)
+	send(t, conn, `{"type":"connection_init","payload":{"user_id":{"id":3}}}`)
+	if code := closeCode(t, conn); code != wsInvalidMessage {
+		t.Errorf("expected close code %d, got %d", wsInvalidMessage, code)
+	}
+}
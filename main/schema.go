@@ -36,18 +36,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	info, err := core.GetDBInfo(db, dialect, conf.Blocklist)
+	engine, err := core.NewEngine(conf, db, core.WithListener(&listener{db: db}))
 	if err != nil {
 		panic(err)
 	}
 	r := chi.NewRouter()
-	r.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
-		in, err := core.NewSchema(conf, info)
-		if err != nil {
-			panic(err)
-		}
-		_, _ = w.Write(in)
-	})
+	r.Handle("/graphql", engine.Handler())
 	r.HandleFunc("/intro", func(w http.ResponseWriter, r *http.Request) {
 		file, err := os.ReadFile("../conf/intro.json")
 		if err != nil {